@@ -0,0 +1,86 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Channel visibilities
+const (
+	visibilityPrivate   = "private"
+	visibilityProtected = "protected"
+	visibilityPublic    = "public"
+)
+
+// access is the kind of operation on a channel.
+type access int
+
+const (
+	// accessRead covers channel and message retrieval.
+	accessRead access = iota
+	// accessManage covers channel update, removal and plugging.
+	accessManage
+)
+
+// authorize function
+// Checks that the principal may access the channel. The owner may do
+// anything, principals the channel is shared with may read it unless
+// it is private, and anyone may read public ones. Channels the
+// principal can not see are reported as not found, so that their
+// existence is not revealed. Without authentication, and on channels
+// created without it (i.e. without owner), everything is allowed.
+// Publishing is authorized by canPublish instead.
+func authorize(r *http.Request, c models.Channel, a access) error {
+	p, ok := principal(r)
+	if !ok || len(c.Owner) == 0 || p.ID == c.Owner {
+		return nil
+	}
+
+	shared := c.Visibility != visibilityPrivate && contains(c.Shared, p.ID)
+	if !shared && c.Visibility != visibilityPublic {
+		return models.ErrNotFound
+	}
+
+	if a == accessRead {
+		return nil
+	}
+
+	return errForbidden("not permitted on channel " + c.ID)
+}
+
+// visibleTo returns ID of the principal whose channels are listed,
+// or an empty string if all the channels are listed.
+func visibleTo(r *http.Request) string {
+	if p, ok := principal(r); ok {
+		return p.ID
+	}
+
+	return ""
+}
+
+// managedChannelsSide function
+// Restricts channelsSide to the channels the principal manages.
+func managedChannelsSide(r *http.Request) side {
+	s := channelsSide(r)
+	s.links = func(id string) ([]string, error) {
+		c, err := oneChannel(r, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorize(r, c, accessManage); err != nil {
+			return nil, err
+		}
+		return c.Devices, nil
+	}
+
+	return s
+}
@@ -0,0 +1,137 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+func TestChannelAccess(t *testing.T) {
+	key, disable := enableAuth(t)
+	defer disable()
+
+	alice := "Bearer " + hs256("alice")
+	bob := "Bearer " + key
+	carol := "Bearer " + hs256("carol")
+
+	channels.Save(models.Channel{ID: "accC1", Owner: "alice", Visibility: "private", Revision: 1})
+	channels.Save(models.Channel{ID: "accC2", Owner: "alice", Visibility: "protected",
+		Shared: []string{"bob"}, Revision: 1})
+	channels.Save(models.Channel{ID: "accC3", Owner: "alice", Visibility: "public", Revision: 1})
+	channels.Save(models.Channel{ID: "accC4", Owner: "alice", Visibility: "private",
+		Shared: []string{"bob"}, Revision: 1})
+	devices.Save(models.Device{ID: "accD1", Revision: 1})
+
+	msg := `[{"n":"temp","v":21}]`
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+		code   int
+	}{
+		// Private channels are hidden from everyone but the owner
+		{"GET", "/channels/accC1", alice, "", http.StatusOK},
+		{"GET", "/channels/accC1", bob, "", http.StatusNotFound},
+		{"GET", "/channels/accC4", bob, "", http.StatusNotFound},
+		{"DELETE", "/channels/accC1", bob, "", http.StatusNotFound},
+		// Protected channels are read by the shared principals
+		{"GET", "/channels/accC2", bob, "", http.StatusOK},
+		{"GET", "/channels/accC2/msg", bob, "", http.StatusOK},
+		{"GET", "/channels/accC2", carol, "", http.StatusNotFound},
+		{"PUT", "/channels/accC2", bob, `{"name": "mine"}`, http.StatusForbidden},
+		{"POST", "/channels/accC2/plug", bob, `["accD1"]`, http.StatusForbidden},
+		{"POST", "/devices/accD1/plug", bob, `["accC2"]`, http.StatusForbidden},
+		// Public channels are read by anyone
+		{"GET", "/channels/accC3", carol, "", http.StatusOK},
+		{"PATCH", "/channels/accC3", carol, `{"visibility": "private"}`, http.StatusForbidden},
+		// The owner manages visibility and sharing
+		{"PATCH", "/channels/accC3", alice, `{"visibility": "hidden"}`, http.StatusBadRequest},
+		{"PATCH", "/channels/accC3", alice, `{"visibility": "protected", "shared": ["carol"]}`, http.StatusOK},
+		{"GET", "/channels/accC3", carol, "", http.StatusOK},
+		// Only devices publish, not even the owner
+		{"POST", "/channels/accC1/msg", alice, msg, http.StatusUnauthorized},
+		{"POST", "/channels/accC2/msg", bob, msg, http.StatusUnauthorized},
+		{"POST", "/devices/accD1/plug", alice, `["accC1"]`, http.StatusOK},
+		{"DELETE", "/channels/accC1", alice, "", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", c.token)
+		if c.method == "PATCH" {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func TestChannelVisibilityListing(t *testing.T) {
+	key, disable := enableAuth(t)
+	defer disable()
+
+	channels.Save(models.Channel{ID: "visC1", Name: "vis", Owner: "dave", Visibility: "private", Revision: 1})
+	channels.Save(models.Channel{ID: "visC2", Name: "vis", Owner: "dave", Visibility: "protected",
+		Shared: []string{"bob"}, Revision: 1})
+	channels.Save(models.Channel{ID: "visC3", Name: "vis", Owner: "dave", Visibility: "public", Revision: 1})
+	channels.Save(models.Channel{ID: "visC4", Name: "vis", Owner: "bob", Visibility: "private", Revision: 1})
+	channels.Save(models.Channel{ID: "visC5", Name: "vis", Revision: 1})
+
+	cases := []struct {
+		token    string
+		expected []string
+	}{
+		{"Bearer " + hs256("dave"), []string{"visC1", "visC2", "visC3", "visC5"}},
+		{"Bearer " + key, []string{"visC2", "visC3", "visC4", "visC5"}},
+		{"Bearer " + hs256("erin"), []string{"visC3", "visC5"}},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+"/channels?name=vis&sort=name", nil)
+		req.Header.Set("Authorization", c.token)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		var body listResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		ids := []string{}
+		for _, item := range body.Items {
+			ids = append(ids, item["id"].(string))
+		}
+		if strings.Join(ids, ",") != strings.Join(c.expected, ",") || body.Total != len(c.expected) {
+			t.Errorf("case %d: expected %v got %v (total %d)", i+1, c.expected, ids, body.Total)
+		}
+	}
+}
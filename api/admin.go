@@ -0,0 +1,43 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mainflux/mainflux-core/integrity"
+)
+
+// checkIntegrity function
+// Reports broken references between devices, channels and messages.
+// GET only reports them, and so does POST, unless `dry_run=false` is
+// given to repair them.
+func checkIntegrity(w http.ResponseWriter, r *http.Request) {
+	repair := r.Method == "POST"
+	opts := integrity.Options{Repair: repair, DryRun: repair}
+
+	if s := r.URL.Query().Get("dry_run"); len(s) > 0 {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, r, errBadRequest("wrong dry_run format"))
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	c := integrity.NewChecker(deviceRepo, channelRepo, messageRepo)
+	report, err := c.Check(opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
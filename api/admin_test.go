@@ -0,0 +1,160 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/integrity"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	devices.Save(models.Device{ID: "chkD1", Channels: []string{"chkC1", "chkGone"}})
+	devices.Save(models.Device{ID: "chkD2"})
+	channels.Save(models.Channel{ID: "chkC1", Devices: []string{"chkD2"}})
+	messages.Save(models.Message{Channel: "chkGoneMsgs"}, models.Message{Channel: "chkGoneMsgs"})
+	devices.Save(models.Device{ID: "chkD3", Tenant: "acme", Channels: []string{"chkC2"}})
+	channels.Save(models.Channel{ID: "chkC2"})
+
+	expected := map[string]integrity.Issue{
+		"chkD1/chkGone": {Kind: integrity.DanglingChannel, ID: "chkD1", Ref: "chkGone"},
+		"chkD1/chkC1":   {Kind: integrity.OneSidedDevice, ID: "chkD1", Ref: "chkC1"},
+		"chkC1/chkD2":   {Kind: integrity.OneSidedChannel, ID: "chkC1", Ref: "chkD2"},
+		"chkGoneMsgs/":  {Kind: integrity.OrphanedMessages, ID: "chkGoneMsgs", Count: 2},
+		"chkD3/chkC2":   {Kind: integrity.CrossTenantLink, ID: "chkD3", Ref: "chkC2"},
+	}
+
+	cases := []struct {
+		method   string
+		query    string
+		code     int
+		issues   int
+		repaired bool
+	}{
+		{"GET", "", http.StatusOK, 5, false},
+		{"POST", "?dry_run=true", http.StatusOK, 5, false},
+		{"GET", "?dry_run=x", http.StatusBadRequest, 0, false},
+		// Repairs only on demand
+		{"POST", "", http.StatusOK, 5, false},
+		{"POST", "?dry_run=false", http.StatusOK, 5, true},
+		// Cross-tenant links are not repaired
+		{"GET", "", http.StatusOK, 1, false},
+	}
+
+	for i, c := range cases {
+		url := fmt.Sprintf("%s/admin/check%s", ts.URL, c.query)
+		req, _ := http.NewRequest(c.method, url, nil)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+
+		var report integrity.Report
+		json.NewDecoder(res.Body).Decode(&report)
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			continue
+		}
+
+		// Other tests share the repositories, so only
+		// the issues seeded here are taken into account
+		found := 0
+		for _, issue := range report.Issues {
+			e, ok := expected[issue.ID+"/"+issue.Ref]
+			if !ok {
+				continue
+			}
+			found++
+
+			if issue.Kind != e.Kind || issue.Count != e.Count {
+				t.Errorf("case %d: expected issue %+v, got %+v", i+1, e, issue)
+			}
+
+			repairable := issue.Kind != integrity.CrossTenantLink
+			if issue.Repaired != (c.repaired && repairable) {
+				t.Errorf("case %d: expected repaired %t, got %t", i+1, c.repaired, issue.Repaired)
+			}
+		}
+
+		if found != c.issues {
+			t.Errorf("case %d: expected %d issues, got %d", i+1, c.issues, found)
+		}
+	}
+
+	d, _ := devices.One("chkD1")
+	if !sameIDs(d.Channels, []string{"chkC1"}) {
+		t.Errorf("expected device channels [chkC1], got %v", d.Channels)
+	}
+
+	c, _ := channels.One("chkC1")
+	if !sameIDs(c.Devices, []string{"chkD1", "chkD2"}) {
+		t.Errorf("expected channel devices [chkD1 chkD2], got %v", c.Devices)
+	}
+
+	c, _ = channels.One("chkC2")
+	if len(c.Devices) != 0 {
+		t.Errorf("expected channel of the other tenant left unplugged, got %v", c.Devices)
+	}
+}
+
+func TestCheckIntegrityObsolete(t *testing.T) {
+	messages.Save(models.Message{Channel: "obsC1"})
+
+	// Channel of the messages created after the scan
+	checker := integrity.NewChecker(devices, creating{channels, "obsC1"}, messages)
+	report, err := checker.Check(integrity.Options{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, issue := range report.Issues {
+		if issue.ID != "obsC1" {
+			continue
+		}
+		found = true
+		if issue.Kind != integrity.OrphanedMessages || issue.Repaired || !issue.Obsolete {
+			t.Errorf("expected obsolete orphaned messages, got %+v", issue)
+		}
+	}
+	if !found {
+		t.Errorf("expected orphaned messages of obsC1")
+	}
+
+	if n, _ := messages.CountByChannel(); n["obsC1"] != 1 {
+		t.Errorf("expected messages of obsC1 kept, got %d", n["obsC1"])
+	}
+}
+
+// creating channel repository creates the channel once all the
+// channels are listed, as if it was created concurrently with a scan.
+type creating struct {
+	models.ChannelRepository
+	id string
+}
+
+func (c creating) All(q models.Query) ([]models.Channel, int, error) {
+	page, total, err := c.ChannelRepository.All(q)
+	if err == nil && len(page) < q.Limit {
+		err = c.ChannelRepository.Save(models.Channel{ID: c.id, Revision: 1})
+	}
+
+	return page, total, err
+}
@@ -0,0 +1,100 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mainflux/mainflux-core/auth"
+)
+
+var (
+	authMu        sync.RWMutex
+	authenticator *auth.Authenticator
+
+	// publicPaths are served without authentication.
+	publicPaths = map[string]bool{
+		"/status":       true,
+		"/health/live":  true,
+		"/health/ready": true,
+		"/metrics":      true,
+	}
+)
+
+// SetAuthenticator function
+// Enables authentication of API calls by the authenticator, or
+// disables it if the authenticator is nil.
+func SetAuthenticator(a *auth.Authenticator) {
+	authMu.Lock()
+	defer authMu.Unlock()
+
+	authenticator = a
+}
+
+// authenticate middleware attaches the principal authenticated by the
+// bearer token to the request, and rejects requests without valid token.
+// Devices identified by client certificate need no token to publish.
+func authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	authMu.RLock()
+	a := authenticator
+	authMu.RUnlock()
+
+	if a == nil || publicPaths[r.URL.Path] {
+		next(w, r)
+		return
+	}
+
+	if id := authDevice(r); len(id) > 0 && isPublish(r) {
+		next(w, r.WithContext(withPrincipal(r, auth.Principal{ID: id, Kind: auth.KindDevice})))
+		return
+	}
+
+	p, err := a.Authenticate(bearerToken(r))
+	if err != nil {
+		if err != auth.ErrUnauthorized {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mainflux"`)
+		writeError(w, r, &apiError{Code: codeUnauthorized, Message: "unauthorized"})
+		return
+	}
+
+	next(w, r.WithContext(withPrincipal(r, p)))
+}
+
+// isPublish reports whether the request publishes a message.
+func isPublish(r *http.Request) bool {
+	return r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/channels/") &&
+		strings.HasSuffix(r.URL.Path, "/msg")
+}
+
+// bearerToken extracts token from the `Authorization: Bearer` header.
+func bearerToken(r *http.Request) string {
+	hdr := r.Header.Get("Authorization")
+	if len(hdr) < 7 || !strings.EqualFold(hdr[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(hdr[7:])
+}
+
+func withPrincipal(r *http.Request, p auth.Principal) context.Context {
+	return context.WithValue(r.Context(), principalKey, p)
+}
+
+// principal returns the authenticated principal, if authentication
+// is enabled.
+func principal(r *http.Request) (auth.Principal, bool) {
+	p, ok := r.Context().Value(principalKey).(auth.Principal)
+	return p, ok
+}
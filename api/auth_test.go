@@ -0,0 +1,154 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/logging"
+)
+
+const jwtSecret = "test-secret"
+
+// hs256 creates JWT for the subject, signed with jwtSecret.
+func hs256(sub string) string {
+	return hs256Claims(`{"sub":"` + sub + `"}`)
+}
+
+// hs256Claims creates JWT with the claims, signed with jwtSecret.
+func hs256Claims(claims string) string {
+	b64 := base64.RawURLEncoding
+	signed := b64.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		b64.EncodeToString([]byte(claims))
+
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte(signed))
+
+	return signed + "." + b64.EncodeToString(mac.Sum(nil))
+}
+
+// enableAuth enables authentication by JWTs signed with jwtSecret,
+// and by the API keys in apiKeys, including the returned one owned
+// by "bob". Returned function disables authentication.
+func enableAuth(t *testing.T) (string, func()) {
+	v, err := auth.NewVerifier(auth.JWTConfig{Secret: jwtSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, value, err := auth.NewAPIKey("bob", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys.Save(k)
+
+	api.SetAuthenticator(auth.NewAuthenticator(v, apiKeys))
+	return value, func() { api.SetAuthenticator(nil) }
+}
+
+func TestAuthenticate(t *testing.T) {
+	key, disable := enableAuth(t)
+	defer disable()
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+		owner  string
+	}{
+		{"GET", "/status", "", http.StatusOK, ""},
+		{"GET", "/health/live", "", http.StatusOK, ""},
+		{"GET", "/devices", "", http.StatusUnauthorized, ""},
+		{"GET", "/devices", "Bearer invalid", http.StatusUnauthorized, ""},
+		{"GET", "/devices", "Basic " + key, http.StatusUnauthorized, ""},
+		{"GET", "/devices", "Bearer " + hs256("alice"), http.StatusOK, ""},
+		{"GET", "/devices", "Bearer " + key, http.StatusOK, ""},
+		{"POST", "/channels/unknown/msg", "", http.StatusUnauthorized, ""},
+		{"POST", "/channels", "Bearer " + hs256("alice"), http.StatusCreated, "alice"},
+		{"POST", "/channels", "bearer " + key, http.StatusCreated, "bob"},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(""))
+		if len(c.token) > 0 {
+			req.Header.Set("Authorization", c.token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		if res.StatusCode == http.StatusUnauthorized && len(res.Header.Get("WWW-Authenticate")) == 0 {
+			t.Errorf("case %d: expected WWW-Authenticate header", i+1)
+		}
+
+		if res.StatusCode == http.StatusCreated {
+			id := strings.TrimPrefix(res.Header.Get("Location"), "/channels/")
+			ch, err := channels.One(id)
+			if err != nil {
+				t.Errorf("case %d: %s", i+1, err.Error())
+				continue
+			}
+			if ch.Owner != c.owner {
+				t.Errorf("case %d: expected owner %q got %q", i+1, c.owner, ch.Owner)
+			}
+		}
+	}
+}
+
+func TestLogRejected(t *testing.T) {
+	_, disable := enableAuth(t)
+	defer disable()
+
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "core.log")
+	if err := logging.Configure(logging.Config{Level: logging.InfoLevel, File: file}); err != nil {
+		t.Fatal(err)
+	}
+	defer logging.Configure(logging.Config{Level: logging.ErrorLevel})
+
+	res, err := http.Get(ts.URL + "/devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+
+	b, _ := ioutil.ReadFile(file)
+	if !strings.Contains(string(b), "status=401") {
+		t.Errorf("expected rejected request logged got %q", b)
+	}
+}
@@ -0,0 +1,133 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/nats-io/go-nats"
+)
+
+// authzSubject is where the MQTT broker, and other protocol adapters,
+// ask whether a device may publish or subscribe on a channel.
+const authzSubject = "mainflux/core/authz"
+
+// Actions devices request authorization for
+const (
+	actionPublish   = "publish"
+	actionSubscribe = "subscribe"
+)
+
+type (
+	// authzRequest asks whether the device, proving its identity by
+	// the key, may take the action on the channel.
+	authzRequest struct {
+		Device  string `json:"device"`
+		Key     string `json:"key"`
+		Channel string `json:"channel"`
+		Action  string `json:"action"`
+	}
+
+	// authzResponse is the reply to authzRequest.
+	authzResponse struct {
+		Allowed bool   `json:"allowed"`
+		Reason  string `json:"reason,omitempty"`
+	}
+)
+
+// canPublish function
+// Checks that the device is plugged into the channel. Devices publish
+// and subscribe only on the channels they are plugged into.
+func canPublish(c models.Channel, device string) error {
+	if !contains(c.Devices, device) {
+		return errForbidden("device " + device + " is not plugged into channel " + c.ID)
+	}
+
+	return nil
+}
+
+// deviceAccess function
+// Authenticates the device, looked up by the given function, by the
+// key, and checks that it may access the channel.
+func deviceAccess(c models.Channel, device, key string, lookup func(string) (models.Device, error)) error {
+	if len(device) == 0 {
+		return errDeviceKey()
+	}
+
+	d, err := lookup(device)
+	if err == models.ErrNotFound {
+		return errDeviceKey()
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.Tenant != c.Tenant || !auth.ValidDeviceKey(d, key, time.Now()) {
+		return errDeviceKey()
+	}
+
+	return canPublish(c, device)
+}
+
+// authzHandler function
+// Replies to authzRequest. Requests are denied unless the device is
+// authenticated, and plugged into the channel.
+func authzHandler(nm *nats.Msg) {
+	inflight.add()
+	defer inflight.done()
+
+	if len(nm.Reply) == 0 {
+		return
+	}
+
+	res := authorizeDevice(nm.Data)
+	authzRequests.Inc(strconv.FormatBool(res.Allowed))
+
+	b, _ := json.Marshal(res)
+	if err := NatsConn.Publish(nm.Reply, b); err != nil {
+		logging.Warn("cannot reply to authorization request", "err", err)
+	}
+}
+
+// authorizeDevice decides the encoded authzRequest.
+func authorizeDevice(data []byte) authzResponse {
+	var req authzRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return authzResponse{Reason: "cannot decode request"}
+	}
+
+	if req.Action != actionPublish && req.Action != actionSubscribe {
+		return authzResponse{Reason: "unknown action"}
+	}
+
+	c, err := channelRepo.One(req.Channel)
+	if err == nil {
+		err = deviceAccess(c, req.Device, req.Key, deviceRepo.One)
+	}
+
+	switch e := err.(type) {
+	case nil:
+		return authzResponse{Allowed: true}
+	case *apiError:
+		return authzResponse{Reason: e.Message}
+	}
+
+	if err == models.ErrNotFound {
+		return authzResponse{Reason: "channel not found"}
+	}
+
+	logging.Error("cannot authorize device", "device", req.Device, "channel", req.Channel, "err", err)
+	return authzResponse{Reason: "internal error"}
+}
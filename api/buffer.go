@@ -0,0 +1,121 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+	"github.com/mainflux/mainflux-core/wal"
+
+	"github.com/satori/go.uuid"
+)
+
+// errBuffered is returned for messages stored in the write-ahead log,
+// which are written and re-published once replayed.
+var errBuffered = errors.New("message buffered")
+
+var (
+	bufferMu sync.RWMutex
+	buffer   *wal.Log
+)
+
+// bufferEntry is the write-ahead log entry of the message.
+type bufferEntry struct {
+	Message NatsMsg          `json:"message"`
+	Records []models.Message `json:"records"`
+}
+
+// SetBuffer function
+// Enables buffering of the messages in the write-ahead log while they
+// cannot be written to the database, or disables it if the log is nil.
+func SetBuffer(l *wal.Log) {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+
+	buffer = l
+}
+
+func currentBuffer() *wal.Log {
+	bufferMu.RLock()
+	defer bufferMu.RUnlock()
+
+	return buffer
+}
+
+// buffering function
+// Tells whether messages are waiting in the write-ahead log, in which
+// case the new ones are buffered too, so that they are written in order.
+func buffering() bool {
+	b := currentBuffer()
+	return b != nil && b.Depth().Entries > 0
+}
+
+// bufferMessage function
+// Appends the message and its records to the write-ahead log. Messages
+// without ID are given one, so that replays are idempotent.
+func bufferMessage(m NatsMsg, records []models.Message) error {
+	b := currentBuffer()
+	if b == nil {
+		return errors.New("write-ahead log disabled")
+	}
+
+	if len(m.ID) == 0 {
+		m.ID = uuid.NewV4().String()
+		for i := range records {
+			records[i].Key = messageKey(m, i)
+		}
+	}
+
+	data, err := json.Marshal(bufferEntry{Message: m, Records: records})
+	if err != nil {
+		return err
+	}
+
+	if err := b.Append(data); err != nil {
+		return err
+	}
+
+	messagesBuffered.Inc(m.Protocol)
+	logging.Debug("message buffered", "channel", m.Channel, "id", m.ID, "records", len(records))
+	return nil
+}
+
+// saveRecords function
+// Saves the records written by the ingestion workers, unless messages
+// are waiting in the write-ahead log.
+func saveRecords(msgs ...models.Message) ([]bool, error) {
+	if buffering() {
+		return nil, errBuffered
+	}
+
+	return messageRepo.SaveBatch(msgs...)
+}
+
+// ReplayBuffered function
+// Writes and re-publishes the message read from the write-ahead log.
+// Messages are re-published even if their records were written before,
+// as the replay might have failed in between.
+func ReplayBuffered(data []byte) error {
+	var e bufferEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		logging.Warn("cannot decode buffered message", "err", err)
+		return nil
+	}
+
+	if _, err := messageRepo.SaveBatch(e.Records...); err != nil {
+		return err
+	}
+	senmlWritten.Add(float64(len(e.Records)), e.Message.Protocol)
+
+	return republish(e.Message)
+}
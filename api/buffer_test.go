@@ -0,0 +1,131 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/models"
+	"github.com/mainflux/mainflux-core/wal"
+)
+
+// enableBuffer enables the write-ahead log of the size, with a message
+// of the device waiting in it. Returned function disables the log.
+func enableBuffer(t *testing.T, maxSize int64, device, channel string) (*wal.Log, func()) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := wal.Open(wal.Config{Dir: dir, MaxSize: maxSize, Policy: wal.Reject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := `{"message": {"id": "m0", "channel": "` + channel + `", "publisher": "` + device + `", "protocol": "http"},` +
+		`"records": [{"n": "buffered", "channel": "` + channel + `", "key": "m0/0"}]}`
+	if err := l.Append([]byte(entry)); err != nil {
+		t.Fatal(err)
+	}
+
+	api.SetBuffer(l)
+	return l, func() {
+		api.SetBuffer(nil)
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// publish publishes the SenML record named n as the device.
+func publish(t *testing.T, d keyResponse, channel, n string) int {
+	req, _ := http.NewRequest("POST", ts.URL+"/channels/"+channel+"/msg",
+		strings.NewReader(`[{"n":"`+n+`","v":1}]`))
+	req.Header.Set("Client-ID", d.ID)
+	req.Header.Set("Client-Key", d.Key)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode
+}
+
+func TestBufferedPublish(t *testing.T) {
+	channels.Save(models.Channel{ID: "walC1", Revision: 1})
+	d := postKey(t, "/devices")
+	plug(t, d.ID, "walC1")
+
+	l, disable := enableBuffer(t, 1<<20, d.ID, "walC1")
+	defer disable()
+
+	// Messages wait for the buffered one
+	if code := publish(t, d, "walC1", "waiting"); code != http.StatusAccepted {
+		t.Errorf("expected status %d got %d", http.StatusAccepted, code)
+	}
+
+	res, err := http.Get(ts.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Buffer wal.Depth `json:"buffer"`
+	}
+	json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	if status.Buffer.Entries != 2 {
+		t.Errorf("expected 2 buffered messages got %d", status.Buffer.Entries)
+	}
+
+	if msgs, _ := messages.ByChannel("walC1", -1, math.MaxFloat64); len(msgs) != 0 {
+		t.Errorf("expected no messages written got %d", len(msgs))
+	}
+
+	// Replay writes them in order, and then messages are written at once
+	if err := l.Replay(api.ReplayBuffered); err != nil {
+		t.Fatal(err)
+	}
+	if code := publish(t, d, "walC1", "direct"); code != http.StatusAccepted {
+		t.Errorf("expected status %d got %d", http.StatusAccepted, code)
+	}
+
+	msgs, _ := messages.ByChannel("walC1", -1, math.MaxFloat64)
+	names := []string{}
+	for _, m := range msgs {
+		names = append(names, m.Name)
+	}
+	if got := strings.Join(names, ","); got != "buffered,waiting,direct" {
+		t.Errorf("expected buffered,waiting,direct written got %s", got)
+	}
+	if d := l.Depth(); d.Entries != 0 {
+		t.Errorf("expected empty buffer got %d messages", d.Entries)
+	}
+}
+
+func TestBufferFull(t *testing.T) {
+	channels.Save(models.Channel{ID: "walC2", Revision: 1})
+	d := postKey(t, "/devices")
+	plug(t, d.ID, "walC2")
+
+	// Log fits the buffered message only
+	_, disable := enableBuffer(t, 256, d.ID, "walC2")
+	defer disable()
+
+	if code := publish(t, d, "walC2", "rejected"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d got %d", http.StatusServiceUnavailable, code)
+	}
+}
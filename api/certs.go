@@ -0,0 +1,64 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// clientIDHeader carries ID of the message publisher.
+const clientIDHeader = "Client-ID"
+
+// withClientCert middleware identifies the device by the common name
+// of the subject of its client certificate. Only certificates verified
+// by the TLS server, i.e. signed by the client CA, are considered.
+func withClientCert(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if id := r.TLS.VerifiedChains[0][0].Subject.CommonName; len(id) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), authDeviceKey, id))
+		}
+	}
+
+	next(w, r)
+}
+
+// authDevice returns ID of the device identified by client certificate
+// or device key, if any.
+func authDevice(r *http.Request) string {
+	id, _ := r.Context().Value(authDeviceKey).(string)
+	return id
+}
+
+// publisher function
+// Returns ID of the message publisher, i.e. of the device identified
+// by client certificate or device key, which must exist.
+func publisher(r *http.Request) (string, error) {
+	hdr := r.Header.Get(clientIDHeader)
+
+	id := authDevice(r)
+	if len(id) == 0 {
+		return "", errDeviceKey()
+	}
+
+	if len(hdr) > 0 && hdr != id {
+		return "", errForbidden("Client-ID does not match client certificate")
+	}
+
+	if _, err := oneDevice(r, id); err != nil {
+		if err == models.ErrNotFound {
+			return "", errForbidden("client certificate does not identify any device")
+		}
+		return "", err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,194 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// issueCert issues certificate with the common name, signed by the
+// parent, or self-signed if there is no parent.
+func issueCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	issuer, signer := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertPublish(t *testing.T) {
+	devices.Save(models.Device{ID: "certD1", Channels: []string{"certC1"}, Revision: 1})
+	channels.Save(models.Channel{ID: "certC1", Devices: []string{"certD1"}, Revision: 1})
+
+	ca := issueCert(t, "Mainflux CA", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	// Server verifies client certificates if given, like the core does
+	// with tlsClientAuth = "optional"
+	srv := httptest.NewUnstartedServer(ts.Config.Handler)
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// Devices with certificates need no token, while others do
+	_, disable := enableAuth(t)
+	defer disable()
+
+	cases := []struct {
+		certs     []tls.Certificate
+		clientID  string
+		code      int
+		publisher string
+	}{
+		{nil, "header", http.StatusUnauthorized, ""},
+		{[]tls.Certificate{issueCert(t, "certD1", &ca)}, "", http.StatusAccepted, "certD1"},
+		{[]tls.Certificate{issueCert(t, "certD1", &ca)}, "certD1", http.StatusAccepted, "certD1"},
+		{[]tls.Certificate{issueCert(t, "certD1", &ca)}, "certD2", http.StatusForbidden, ""},
+		{[]tls.Certificate{issueCert(t, "unknown", &ca)}, "", http.StatusForbidden, ""},
+	}
+
+	for i, c := range cases {
+		// Every case connects anew, with its own certificate
+		tr := srv.Client().Transport.(*http.Transport).Clone()
+		tr.TLSClientConfig.Certificates = c.certs
+		client := &http.Client{Transport: tr}
+
+		name := fmt.Sprintf("case%d", i+1)
+		req, _ := http.NewRequest("POST", srv.URL+"/channels/certC1/msg",
+			strings.NewReader(`[{"n":"`+name+`","v":1}]`))
+		if len(c.clientID) > 0 {
+			req.Header.Set("Client-ID", c.clientID)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		if res.StatusCode != http.StatusAccepted {
+			continue
+		}
+
+		msgs, _ := messages.ByChannel("certC1", 0, math.MaxFloat64)
+		publisher := ""
+		for _, m := range msgs {
+			if m.Name == name {
+				publisher = m.Publisher
+			}
+		}
+		if publisher != c.publisher {
+			t.Errorf("case %d: expected publisher %q got %q", i+1, c.publisher, publisher)
+		}
+	}
+}
+
+func TestClientCertTenant(t *testing.T) {
+	tenants.Save(models.Tenant{ID: "acme"})
+	tenants.Save(models.Tenant{ID: "initech"})
+	devices.Save(models.Device{ID: "certD3", Tenant: "initech", Channels: []string{"certC3"}, Revision: 1})
+	channels.Save(models.Channel{ID: "certC3", Tenant: "initech", Devices: []string{"certD3"}, Revision: 1})
+
+	ca := issueCert(t, "Mainflux CA", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	srv := httptest.NewUnstartedServer(ts.Config.Handler)
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	_, disable := enableAuth(t)
+	defer disable()
+	acme := "Bearer " + acmeKey(t)
+
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig.Certificates = []tls.Certificate{issueCert(t, "certD3", &ca)}
+	client := &http.Client{Transport: tr}
+
+	cases := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		// Principal confined to acme gains nothing by the initech device certificate
+		{"GET", "/channels/certC3", acme, http.StatusNotFound},
+		{"GET", "/devices/certD3", acme, http.StatusNotFound},
+		{"DELETE", "/channels/certC3", acme, http.StatusNotFound},
+		// The device itself publishes on its tenant
+		{"POST", "/channels/certC3/msg", "", http.StatusAccepted},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, srv.URL+c.path, strings.NewReader(`[{"n":"tenant","v":1}]`))
+		if len(c.token) > 0 {
+			req.Header.Set("Authorization", c.token)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
@@ -11,18 +11,14 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"time"
 
-	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/events"
 	"github.com/mainflux/mainflux-core/models"
 
 	"github.com/satori/go.uuid"
-	"gopkg.in/mgo.v2/bson"
 
-	"io"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/go-zoo/bone"
@@ -41,25 +37,20 @@ type (
 func createChannel(w http.ResponseWriter, r *http.Request) {
 	c := models.Channel{}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	data, err := ioutil.ReadAll(r.Body)
+	data, err := readBody(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	if len(data) > 0 {
-		if err, str := validateChannelSchema(data); err {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, str)
+		if err := validateChannelSchema(data); err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		if err := json.Unmarshal(data, &c); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			str := `{"response": "cannot decode body"}`
-			io.WriteString(w, str)
+			writeError(w, r, errBadRequest("cannot decode body"))
 			return
 		}
 	}
@@ -70,377 +61,432 @@ func createChannel(w http.ResponseWriter, r *http.Request) {
 	// Timestamp
 	ts := time.Now().UTC().Format(time.RFC3339)
 	c.Created, c.Updated = ts, ts
+	c.Revision = 1
+	c.Tenant = tenant(r)
 
-	c.Owner = ""
-	c.Visibility = "private"
-
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	// Anonymous channels are owned by nobody
+	if p, ok := principal(r); ok {
+		c.Owner = p.ID
+	}
+	if len(c.Visibility) == 0 {
+		c.Visibility = visibilityPrivate
+	}
 
 	// Insert Channel
-	if err := Db.C("channels").Insert(c); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	ch, err := stage(r, events.Created, events.ResourceChannel, c.ID, c.Tenant, nil)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-
-	// Publish on NATS
-	go func() {
-		hdr := r.Header.Get("Authorization")
-		msg := `{"type": "channel", "id":"` + c.ID + `", "owner": "` + hdr + `"}`
-		NatsConn.Publish("mainflux/core/auth", []byte(msg))
-	}()
+	if err := channelRepo.Save(c); err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+	ch.commit(c)
 
 	// Send RSP
 	w.Header().Set("Location", fmt.Sprintf("/channels/%s", c.ID))
+	w.Header().Set("ETag", etag(c.Revision))
 	w.WriteHeader(http.StatusCreated)
 }
 
 // getChannels function
+// Lists channels page by page, newest first by default.
+// See parseQuery and parseFilter for the supported parameters.
 func getChannels(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
-	// Get fileter values from parameters:
-	// - climit = count limit, limits number of returned `channel` elements
-	// - vlimit = value limit, limits number of values within the channel
-	var climit int
-	var err error
-	s := r.URL.Query().Get("climit")
-	if len(s) == 0 {
-		// Set default limit to -100
-		climit = -100
-	} else {
-		climit, err = strconv.Atoi(s)
+	// `climit` is kept as an alias of `limit` for older clients
+	if s := r.URL.Query().Get("climit"); len(s) > 0 && len(r.URL.Query().Get("limit")) == 0 {
+		climit, err := strconv.Atoi(s)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			str := `{"response": "wrong count limit"}`
-			io.WriteString(w, str)
+			writeError(w, r, errBadRequest("wrong count limit"))
 			return
 		}
+		// Negative limits used to be passed verbatim to mgo,
+		// which treats them as absolute values
+		if climit < 0 {
+			climit = -climit
+		}
+		params := r.URL.Query()
+		params.Del("climit")
+		params.Set("limit", strconv.Itoa(climit))
+		r.URL.RawQuery = params.Encode()
+	}
+
+	q, err := parseQuery(r, "-"+models.SortCreated)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	// Query DB
-	results := []models.Channel{}
-	if err := Db.C("channels").Find(nil).
-		Sort("-_id").Limit(climit).All(&results); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+	if q.Filter, err = parseFilter(r, false); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if len(results) == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "no channel found"}`
-		io.WriteString(w, str)
+	q.Filter.VisibleTo = visibleTo(r)
+	t := tenant(r)
+	q.Filter.Tenant = &t
+
+	fields, err := parseFields(r, models.Channel{})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	res, err := json.Marshal(results)
+	// Fetch one channel more to find out if there is a next page
+	page := q
+	page.Limit++
+	results, total, err := channelRepo.All(page)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, string(res))
+	var next *models.Cursor
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		last := results[len(results)-1]
+		next = &models.Cursor{Value: last.SortValue(q.Sort), ID: last.ID}
+	}
+
+	items := make([]interface{}, len(results))
+	for i := range results {
+		items[i] = results[i]
+	}
+
+	lr, err := newListResponse(r, q, total, items, next, fields)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, lr)
 }
 
 // getChannel function
 func getChannel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	id := bone.GetValue(r, "channel_id")
 
-	result := models.Channel{}
-	if err := Db.C("channels").Find(bson.M{"id": id}).
-		One(&result); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + id + `"}`
-		io.WriteString(w, str)
+	result, err := oneChannel(r, id)
+	if err == nil {
+		err = authorize(r, result, accessRead)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, id))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	res, err := json.Marshal(result)
-	if err != nil {
-		log.Print(err)
+	// Conditional GET
+	w.Header().Set("ETag", etag(result.Revision))
+	if status := checkPreconditions(r, result.Revision); status != 0 {
+		w.WriteHeader(status)
+		return
 	}
-	io.WriteString(w, string(res))
+
+	writeJSON(w, http.StatusOK, result)
 }
 
 // updateChannel function
+// Replaces all the writable channel fields with the ones from the body,
+// i.e. fields missing from the body are cleared.
 func updateChannel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
-	data, err := ioutil.ReadAll(r.Body)
+	data, err := readBody(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	// Validate JSON schema
 	if len(data) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+		writeError(w, r, errBadRequest("no data provided"))
 		return
 	}
 
-	if err, str := validateChannelSchema(data); err {
-		w.WriteHeader(http.StatusBadRequest)
-		io.WriteString(w, str)
+	if err := validateChannelSchema(data); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	var body map[string]interface{}
 	if err := json.Unmarshal(data, &body); err != nil {
-		panic(err)
+		writeError(w, r, errBadRequest("cannot decode body"))
+		return
 	}
 
-	// Timestamp
-	body["updated"] = time.Now().UTC().Format(time.RFC3339)
-
 	// Channel id
 	id := bone.GetValue(r, "channel_id")
 
-	colQuerier := bson.M{"id": id}
-	change := bson.M{"$set": body}
-	if err := Db.C("channels").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not updated", "id": "` + id + `"}`
-		io.WriteString(w, str)
+	c, err := oneChannel(r, id)
+	if err == nil {
+		err = authorize(r, c, accessManage)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	if status := checkPreconditions(r, c.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
 		return
 	}
+	before := c
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "updated", "id": "` + id + `"}`
-	io.WriteString(w, str)
+	if err := replaceWritable(&c, body); err != nil {
+		writeError(w, r, errBadRequest("cannot decode body"))
+		return
+	}
+
+	storeChannel(w, r, before, c)
 }
 
-// deleteChannel function
-func deleteChannel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// patchChannel function
+// Applies JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902)
+// onto the writable channel fields, depending on Content-Type.
+func patchChannel(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	id := bone.GetValue(r, "channel_id")
 
-	cid := bone.GetValue(r, "channel_id")
+	c, err := oneChannel(r, id)
+	if err == nil {
+		err = authorize(r, c, accessManage)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
 
-	// Get channel
-	c := models.Channel{}
-	if err := Db.C("channels").Find(bson.M{"id": cid}).
-		One(&c); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	if status := checkPreconditions(r, c.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
 		return
 	}
+	before := c
 
-	// Loop to all devices plugged into this channel
-	for _, did := range c.Devices {
-		// Remove channelID from the Device's `Channels` registry
-		t := time.Now().UTC().Format(time.RFC3339)
-		err := Db.C("devices").Update(bson.M{"id": did},
-			bson.M{"$pull": bson.M{"channels": cid}, "$set": bson.M{"updated": t}})
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot unplug channel for device ` + did + `"}`
-			io.WriteString(w, str)
-			return
-		}
+	// Channels created before visibility was enforced have none
+	if len(c.Visibility) == 0 {
+		c.Visibility = visibilityPrivate
 	}
 
-	// Delete channel
-	if err := Db.C("channels").Remove(bson.M{"id": cid}); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not deleted", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	doc, err := writable(c)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "deleted", "id": "` + cid + `"}`
-	io.WriteString(w, str)
-}
-
-// plugChannel function
-// Plugs given channel into devices - i.e. creates a
-// connection between channel and list of devices provided
-func plugChannel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	patched, err := applyPatch(r, doc, data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	data, err := ioutil.ReadAll(r.Body)
+	// Patched document must obey the same rules as PUT body
+	b, err := json.Marshal(patched)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
+	}
+	if err := validateChannelSchema(b); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	/**
-	if len(data) > 0 {
-		var body map[string]interface{}
-		if err := json.Unmarshal(data, &body); err != nil {
-			panic(err)
-		}
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+	if err := replaceWritable(&c, patched); err != nil {
+		writeError(w, r, errBadRequest("cannot decode body"))
 		return
 	}
-	*/
 
-	/**
-	if validateJsonSchema("channel", body) != true {
-		println("Invalid schema")
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "invalid json schema in request"}`
-		io.WriteString(w, str)
+	storeChannel(w, r, before, c)
+}
+
+// storeChannel function
+// Stores channel modified by PUT or PATCH request and responds.
+func storeChannel(w http.ResponseWriter, r *http.Request, before, c models.Channel) {
+	// Timestamp
+	c.Updated = time.Now().UTC().Format(time.RFC3339)
+
+	// Visibility missing from PUT body is reset to the default
+	if len(c.Visibility) == 0 {
+		c.Visibility = visibilityPrivate
+	}
+
+	ch, err := stage(r, events.Updated, events.ResourceChannel, c.ID, c.Tenant, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := channelRepo.Update(c); err != nil {
+		ch.abort()
+		switch err {
+		case models.ErrConflict:
+			writeError(w, r, errModified(r, c.ID))
+		default:
+			writeError(w, r, notFound(err, c.ID))
+		}
 		return
 	}
-	**/
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	c.Revision++
+	ch.commit(c)
+
+	w.Header().Set("ETag", etag(c.Revision))
+	writeJSON(w, http.StatusOK, response{"updated", c.ID})
+}
 
+// deleteChannel function
+func deleteChannel(w http.ResponseWriter, r *http.Request) {
 	cid := bone.GetValue(r, "channel_id")
 
-	var devices []string
-	if err := json.Unmarshal(data, &devices); err != nil {
-		panic(err)
-	}
-
-	for _, did := range devices {
-		// Timestamp
-		t := time.Now().UTC().Format(time.RFC3339)
-		// Append channelID to the Device's `Channels` registry
-		if err := Db.C("devices").Update(bson.M{"id": did},
-			bson.M{"$addToSet": bson.M{"channels": cid},
-				"$set": bson.M{"updated": t}}); err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot plug channel into device ` + did + `"}`
-			io.WriteString(w, str)
-			return
-		}
+	// Get channel
+	c, err := oneChannel(r, cid)
+	if err == nil {
+		err = authorize(r, c, accessManage)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
+		return
+	}
 
+	if status := checkPreconditions(r, c.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(cid))
+		return
 	}
 
-	/** Append device list to channel's Devices[] */
-	colQuerier := bson.M{"id": cid}
-	// Timestamp
-	t := time.Now().UTC().Format(time.RFC3339)
-	// Append entry to exiting array
-	change := bson.M{"$addToSet": bson.M{"devices": bson.M{"$each": devices}}, "$set": bson.M{"updated": t}}
-	if err := Db.C("channels").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not inserted", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Deleted, events.ResourceChannel, cid, c.Tenant, c)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceDevice, c.Devices)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+
+	// Remove this channel from all the devices plugged into it
+	err = disconnect(cid, devicesSide(r), c.Devices, func() error {
+		return channelRepo.Remove(cid, c.Revision)
+	})
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		switch err {
+		case models.ErrConflict:
+			writeError(w, r, errModified(r, cid))
+		default:
+			writeError(w, r, notFound(err, cid))
+		}
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "plugged", "id": "` + cid + `"}`
-	io.WriteString(w, str)
+	ch.commit(nil)
+	peers.commit()
+
+	writeJSON(w, http.StatusOK, response{"deleted", cid})
 }
 
-// unplugChannel function
-// Unlugs given list of devices from given channel - i.e. removes
+// plugChannel function
+// Plugs given channel into devices - i.e. creates a
 // connection between channel and list of devices provided
-func unplugChannel(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+func plugChannel(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	cid := bone.GetValue(r, "channel_id")
 
-	data, err := ioutil.ReadAll(r.Body)
+	devices, err := decodeIDs(data)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
 
-	/**
-	if len(data) > 0 {
-		var body map[string]interface{}
-		if err := json.Unmarshal(data, &body); err != nil {
-			panic(err)
-		}
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+	before, err := oneChannel(r, cid)
+	if err == nil {
+		err = authorize(r, before, accessManage)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
 		return
 	}
-	*/
 
-	/**
-	if validateJsonSchema("channel", body) != true {
-		println("Invalid schema")
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "invalid json schema in request"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Plugged, events.ResourceChannel, cid, before.Tenant, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceDevice, devices)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
 		return
 	}
-	**/
+	results, err := connect(managedChannelsSide(r), cid, devicesSide(r), devices, true)
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		writeError(w, r, err)
+		return
+	}
+	ch.commitLinks(results)
+	peers.commit()
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	writeJSON(w, http.StatusOK, plugResponse{"plugged", cid, results})
+}
+
+// unplugChannel function
+// Unlugs given list of devices from given channel - i.e. removes
+// connection between channel and list of devices provided
+func unplugChannel(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	cid := bone.GetValue(r, "channel_id")
 
-	var devices []string
-	if err := json.Unmarshal(data, &devices); err != nil {
-		panic(err)
+	devices, err := decodeIDs(data)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	for _, did := range devices {
-		// Remove cid from the Device's `Channels` registry
-		t := time.Now().UTC().Format(time.RFC3339)
-		err := Db.C("devices").Update(bson.M{"id": did},
-			bson.M{"$pull": bson.M{"channels": cid}, "$set": bson.M{"updated": t}})
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot unplug channel ` + cid + ` for device ` + did + `"}`
-			io.WriteString(w, str)
-			return
-		}
-
+	before, err := oneChannel(r, cid)
+	if err == nil {
+		err = authorize(r, before, accessManage)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
+		return
 	}
 
-	/** Remove device list from channel's Devices[] */
-	colQuerier := bson.M{"id": cid}
-	// Timestamp
-	t := time.Now().UTC().Format(time.RFC3339)
-	// Remove entry from exiting array
-	change := bson.M{"$pull": bson.M{"devices": bson.M{"$in": devices}}, "$set": bson.M{"updated": t}}
-	if err := Db.C("channels").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not inserted", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Unplugged, events.ResourceChannel, cid, before.Tenant, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceDevice, devices)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+	results, err := connect(managedChannelsSide(r), cid, devicesSide(r), devices, false)
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		writeError(w, r, err)
 		return
 	}
+	ch.commitLinks(results)
+	peers.commit()
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "uplugged", "id": "` + cid + `"}`
-	io.WriteString(w, str)
+	writeJSON(w, http.StatusOK, plugResponse{"unplugged", cid, results})
 }
@@ -9,12 +9,12 @@
 package api_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"testing"
 
-	"github.com/mainflux/mainflux-core/db"
 	"github.com/mainflux/mainflux-core/models"
 )
 
@@ -42,11 +42,11 @@ func TestCreateChannel(t *testing.T) {
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -70,75 +70,77 @@ func TestGetChannels(t *testing.T) {
 	cases := []struct {
 		header string
 		code   int
+		total  int
 	}{
-		{"api-key", http.StatusOK},
-		{"api-key", http.StatusNotFound},
+		{"api-key", http.StatusOK, 2},
+		{"api-key", http.StatusOK, 0},
 	}
 
-	// Init MongoDB (docker_test)
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	for i, c := range cases {
 		// case 1
 		if i == 0 {
-			// Insert Devices
-			d := models.Channel{}
-			d.ID = "testID"
-			Db.C("channels").Insert(d)
-			d.ID = "testID2"
-			Db.C("channels").Insert(d)
-		// case 2
+			removeDevices()
+			removeChannels()
+			// Insert Channels
+			m := models.Channel{}
+			m.ID = "testID"
+			channels.Save(m)
+			m.ID = "testID2"
+			channels.Save(m)
+			// case 2
 		} else {
-			Db.C("channels").RemoveAll(nil)
+			removeChannels()
 		}
 
 		url := fmt.Sprintf("%s/channels", ts.URL)
 		cli := &http.Client{}
 		res, err := cli.Get(url)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
 		}
+
+		var body listResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		if body.Total != c.total || len(body.Items) != c.total {
+			t.Errorf("case %d: expected %d items, got %d of %d", i+1, c.total, len(body.Items), body.Total)
+		}
 	}
 }
 
-
 func TestGetChannel(t *testing.T) {
 	cases := []struct {
 		id     string
 		header string
 		code   int
 	}{
-		{"validID",   "api-key", http.StatusOK},
+		{"validID", "api-key", http.StatusOK},
 		{"invalidID", "api-key", http.StatusNotFound},
 	}
 
-	// Init MongoDB (docker_test)
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device with id "existentTestID" in DB
 	d := models.Channel{}
 	d.ID = cases[0].id
-	Db.C("channels").Insert(d)
+	channels.Save(d)
 
 	for i, c := range cases {
-		url := fmt.Sprintf("%s/channels/" + c.id, ts.URL)
+		url := fmt.Sprintf("%s/channels/"+c.id, ts.URL)
 		cli := &http.Client{}
 		res, err := cli.Get(url)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -156,15 +158,10 @@ func TestUpdateChannel(t *testing.T) {
 		{`{"description":"test"}`, "api-key", http.StatusOK},
 	}
 
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device in DB
 	d := models.Channel{}
 	d.ID = "IDtestChannel"
-	Db.C("channels").Insert(d)
+	channels.Save(d)
 
 	url := fmt.Sprintf("%s/channels/%s", ts.URL, "IDtestChannel")
 
@@ -177,11 +174,11 @@ func TestUpdateChannel(t *testing.T) {
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -203,40 +200,118 @@ func TestUpdateChannel(t *testing.T) {
 
 func TestDeleteChannel(t *testing.T) {
 	cases := []struct {
-		ID   string
+		ID     string
 		header string
 		code   int
 	}{
-		{"existentTestID",  "api-key", http.StatusOK},
-		{"invalid",         "api-key", http.StatusNotFound},
+		{"existentTestID", "api-key", http.StatusOK},
+		{"invalid", "api-key", http.StatusNotFound},
 	}
 
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device in DB
 	d := models.Channel{}
 	d.ID = cases[0].ID
-	Db.C("channels").Insert(d)
+	channels.Save(d)
 
 	for i, c := range cases {
 		url := fmt.Sprintf("%s/channels/%s", ts.URL, c.ID)
 
-		req, err := http.NewRequest("DELETE", url, nil)
+		req, _ := http.NewRequest("DELETE", url, nil)
 		req.Header.Set("Authorization", c.header)
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
 		}
 	}
 }
+
+func removeChannels() {
+	all, _, _ := channels.All(models.Query{})
+	for _, c := range all {
+		channels.Remove(c.ID, models.AnyRevision)
+	}
+}
+
+func TestChannelConditionalRequests(t *testing.T) {
+	c := models.Channel{ID: "etagChannelID", Revision: 4}
+	channels.Save(c)
+
+	url := fmt.Sprintf("%s/channels/%s", ts.URL, c.ID)
+
+	cases := []struct {
+		method string
+		header string
+		value  string
+		body   string
+		code   int
+	}{
+		{"GET", "If-None-Match", `"4"`, "", http.StatusNotModified},
+		{"PUT", "If-Match", `"3"`, `{"name": "test"}`, http.StatusPreconditionFailed},
+		{"PUT", "If-Match", `"4"`, `{"name": "test"}`, http.StatusOK},
+		{"GET", "If-None-Match", `"4"`, "", http.StatusOK},
+		{"DELETE", "If-Match", `"4"`, "", http.StatusPreconditionFailed},
+		{"DELETE", "If-Match", "*", "", http.StatusOK},
+	}
+
+	for i, tc := range cases {
+		req, _ := http.NewRequest(tc.method, url, strings.NewReader(tc.body))
+		req.Header.Set(tc.header, tc.value)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tc.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, tc.code, res.StatusCode)
+		}
+	}
+}
+
+func TestPatchChannel(t *testing.T) {
+	c := models.Channel{ID: "patchChannelID", Name: "old", Metadata: map[string]interface{}{"k": "v"}}
+	channels.Save(c)
+
+	url := fmt.Sprintf("%s/channels/%s", ts.URL, c.ID)
+
+	cases := []struct {
+		ctype string
+		body  string
+		code  int
+		name  string
+	}{
+		{"application/merge-patch+json", `{"name": "merged"}`, http.StatusOK, "merged"},
+		{"application/json-patch+json", `[{"op": "replace", "path": "/name", "value": "patched"}]`, http.StatusOK, "patched"},
+		{"application/json-patch+json", `[{"op": "add", "path": "/devices", "value": ["d1"]}]`, http.StatusBadRequest, "patched"},
+		{"text/plain", `name=plain`, http.StatusUnsupportedMediaType, "patched"},
+	}
+
+	for i, tc := range cases {
+		req, _ := http.NewRequest("PATCH", url, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.ctype)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		if res.StatusCode != tc.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, tc.code, res.StatusCode)
+		}
+
+		stored, _ := channels.One(c.ID)
+		if stored.Name != tc.name || stored.Metadata["k"] != "v" {
+			t.Errorf("case %d: unexpected channel %+v", i+1, stored)
+		}
+	}
+}
@@ -10,22 +10,18 @@ package api
 
 import (
 	"encoding/json"
-	"log"
-	"time"
 	"fmt"
+	"time"
 
-	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/events"
 	"github.com/mainflux/mainflux-core/models"
 
 	"github.com/satori/go.uuid"
-	"gopkg.in/mgo.v2/bson"
 
-	"io"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/go-zoo/bone"
-
 )
 
 /** == Functions == */
@@ -35,24 +31,20 @@ func createDevice(w http.ResponseWriter, r *http.Request) {
 	// Set up defaults and pick up new values from user-provided JSON
 	d := models.Device{}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	data, err := ioutil.ReadAll(r.Body)
+	data, err := readBody(r)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
 
 	if len(data) > 0 {
-		if err, str := validateDeviceSchema(data); err {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, str)
+		if err := validateDeviceSchema(data); err != nil {
+			writeError(w, r, err)
 			return
 		}
 
 		if err := json.Unmarshal(data, &d); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			str := `{"response": "cannot decode body"}`
-			io.WriteString(w, str)
+			writeError(w, r, errBadRequest("cannot decode body"))
 			return
 		}
 	}
@@ -64,354 +56,384 @@ func createDevice(w http.ResponseWriter, r *http.Request) {
 	// Timestamp
 	t := time.Now().UTC().Format(time.RFC3339)
 	d.Created, d.Updated = t, t
+	d.Revision = 1
+	d.Tenant = tenant(r)
 
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	// Device key is returned once, and stored hashed
+	k, key, err := auth.NewDeviceKey()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	d.Keys = []models.DeviceKey{k}
 
 	// Insert Device
-	if err := Db.C("devices").Insert(d); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "cannot create device"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Created, events.ResourceDevice, d.ID, d.Tenant, nil)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-
-	// Publish on NATS
-	hdr := r.Header.Get("Authorization")
-	msg := `{"type": "device", "id":"` + d.ID + `", "owner": "` + hdr + `"}`
-	NatsConn.Publish("core-auth", []byte(msg))
+	if err := deviceRepo.Save(d); err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+	ch.commit(d)
 
 	// Send RSP
 	w.Header().Set("Location", fmt.Sprintf("/devices/%s", d.ID))
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("ETag", etag(d.Revision))
+	writeJSON(w, http.StatusCreated, deviceKeyResponse{ID: d.ID, KeyID: k.ID, Key: key})
 }
 
 // getDevices function
+// Lists devices page by page, see parseQuery and parseFilter
+// for the supported parameters.
 func getDevices(w http.ResponseWriter, r *http.Request) {
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	q, err := parseQuery(r, models.SortCreated)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if q.Filter, err = parseFilter(r, true); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	t := tenant(r)
+	q.Filter.Tenant = &t
 
-	results := []models.Device{}
-	if err := Db.C("devices").Find(nil).All(&results); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+	fields, err := parseFields(r, models.Device{})
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if len(results) == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "no device found"}`
-		io.WriteString(w, str)
+
+	// Fetch one device more to find out if there is a next page
+	page := q
+	page.Limit++
+	results, total, err := deviceRepo.All(page)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	res, err := json.Marshal(results)
+	var next *models.Cursor
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		last := results[len(results)-1]
+		next = &models.Cursor{Value: last.SortValue(q.Sort), ID: last.ID}
+	}
+
+	items := make([]interface{}, len(results))
+	for i := range results {
+		items[i] = results[i]
+	}
+
+	lr, err := newListResponse(r, q, total, items, next, fields)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, string(res))
+	writeJSON(w, http.StatusOK, lr)
 }
 
 // getDevice function
 func getDevice(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	id := bone.GetValue(r, "device_id")
 
-	result := models.Device{}
-	err := Db.C("devices").Find(bson.M{"id": id}).One(&result)
+	result, err := oneDevice(r, id)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + id + `"}`
-		io.WriteString(w, str)
+		writeError(w, r, notFound(err, id))
 		return
 	}
 
-	res, err := json.Marshal(result)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+	// Conditional GET
+	w.Header().Set("ETag", etag(result.Revision))
+	if status := checkPreconditions(r, result.Revision); status != 0 {
+		w.WriteHeader(status)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, string(res))
+	writeJSON(w, http.StatusOK, result)
 }
 
 // updateDevice function
+// Replaces all the writable device fields with the ones from the body,
+// i.e. fields missing from the body are cleared.
 func updateDevice(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	data, err := ioutil.ReadAll(r.Body)
+	data, err := readBody(r)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
 
 	if len(data) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+		writeError(w, r, errBadRequest("no data provided"))
 		return
 	}
 
-	if err, str := validateDeviceSchema(data); err {
-		w.WriteHeader(http.StatusBadRequest)
-		io.WriteString(w, str)
+	if err := validateDeviceSchema(data); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	var body map[string]interface{}
 	if err := json.Unmarshal(data, &body); err != nil {
-		panic(err)
+		writeError(w, r, errBadRequest("cannot decode body"))
+		return
 	}
 
-	// Timestamp
-	body["updated"] = time.Now().UTC().Format(time.RFC3339)
-
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Device id
 	id := bone.GetValue(r, "device_id")
 
-	colQuerier := bson.M{"id": id}
-	change := bson.M{"$set": body}
-	if err := Db.C("devices").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not updated", "id": "` + id + `"}`
-		io.WriteString(w, str)
+	d, err := oneDevice(r, id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	if status := checkPreconditions(r, d.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
+		return
+	}
+	before := d
+
+	if err := replaceWritable(&d, body); err != nil {
+		writeError(w, r, errBadRequest("cannot decode body"))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "updated", "id": "` + id + `"}`
-	io.WriteString(w, str)
+	storeDevice(w, r, before, d)
 }
 
-// deleteDevice function
-func deleteDevice(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// patchDevice function
+// Applies JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902)
+// onto the writable device fields, depending on Content-Type.
+func patchDevice(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	id := bone.GetValue(r, "device_id")
 
-	did := bone.GetValue(r, "device_id")
+	d, err := oneDevice(r, id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
 
-	// Get Device
-	d := models.Device{}
-	err := Db.C("devices").Find(bson.M{"id": did}).One(&d)
+	if status := checkPreconditions(r, d.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
+		return
+	}
+	before := d
+
+	doc, err := writable(d)
 	if err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "` + err.Error() + `", "id": "` + did + `"}`
-		io.WriteString(w, str)
+		writeError(w, r, err)
 		return
 	}
 
-	// Remove this device from all the channels it was plugged into
-	for _, cid := range d.Channels {
-		// Remove did from the Channels's `Devices` registry
-		t := time.Now().UTC().Format(time.RFC3339)
-		err := Db.C("channels").Update(bson.M{"id": cid},
-			bson.M{"$pull": bson.M{"devices": did}, "$set": bson.M{"updated": t}})
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot unplug channel ` + cid + ` for device ` + did + `"}`
-			io.WriteString(w, str)
-			return
-		}
+	patched, err := applyPatch(r, doc, data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Patched document must obey the same rules as PUT body
+	b, err := json.Marshal(patched)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateDeviceSchema(b); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	// Delete device
-	if err := Db.C("devices").Remove(bson.M{"id": did}); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not deleted", "id": "` + did + `"}`
-		io.WriteString(w, str)
+	if err := replaceWritable(&d, patched); err != nil {
+		writeError(w, r, errBadRequest("cannot decode body"))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "deleted", "id": "` + did + `"}`
-	io.WriteString(w, str)
+	storeDevice(w, r, before, d)
 }
 
-// plugDevice function
-// Plugs given list of channle into device - i.e. creates a
-// connection between device and list of devices provided
-func plugDevice(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// storeDevice function
+// Stores device modified by PUT or PATCH request and responds.
+func storeDevice(w http.ResponseWriter, r *http.Request, before, d models.Device) {
+	// Timestamp
+	d.Updated = time.Now().UTC().Format(time.RFC3339)
 
-	data, err := ioutil.ReadAll(r.Body)
+	ch, err := stage(r, events.Updated, events.ResourceDevice, d.ID, d.Tenant, before)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
-
-	/**
-	if len(data) > 0 {
-		var body map[string]interface{}
-		if err := json.Unmarshal(data, &body); err != nil {
-			panic(err)
+	if err := deviceRepo.Update(d); err != nil {
+		ch.abort()
+		switch err {
+		case models.ErrConflict:
+			writeError(w, r, errModified(r, d.ID))
+		default:
+			writeError(w, r, notFound(err, d.ID))
 		}
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
 		return
 	}
-	*/
 
-	/**
-	if validateJsonSchema("channel", body) != true {
-		println("Invalid schema")
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "invalid json schema in request"}`
-		io.WriteString(w, str)
-		return
-	}
-	**/
+	d.Revision++
+	ch.commit(d)
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	w.Header().Set("ETag", etag(d.Revision))
+	writeJSON(w, http.StatusOK, response{"updated", d.ID})
+}
 
+// deleteDevice function
+func deleteDevice(w http.ResponseWriter, r *http.Request) {
 	did := bone.GetValue(r, "device_id")
 
-	var channels []string
-	if err := json.Unmarshal(data, &channels); err != nil {
-		panic(err)
-	}
-
-	for _, cid := range channels {
-		// Timestamp
-		t := time.Now().UTC().Format(time.RFC3339)
-		// Append channelID to the Device's `Channels` registry
-		if err := Db.C("channels").Update(bson.M{"id": cid},
-			bson.M{"$addToSet": bson.M{"devices": did},
-				"$set": bson.M{"updated": t}}); err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot plug channel ` + cid + ` into device ` + did + `"}`
-			io.WriteString(w, str)
-			return
-		}
+	// Get Device
+	d, err := oneDevice(r, did)
+	if err != nil {
+		writeError(w, r, notFound(err, did))
+		return
+	}
 
+	if status := checkPreconditions(r, d.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(did))
+		return
 	}
 
-	/** Add channel list to device's Channels[] */
-	colQuerier := bson.M{"id": did}
-	// Timestamp
-	t := time.Now().UTC().Format(time.RFC3339)
-	// Append entry to exiting array
-	change := bson.M{"$addToSet": bson.M{"channels": bson.M{"$each": channels}}, "$set": bson.M{"updated": t}}
-	if err := Db.C("devices").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "cannot plug channels into device ` + did + `"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Deleted, events.ResourceDevice, did, d.Tenant, d)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceChannel, d.Channels)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "plugged", "id": "` + did + `"}`
-	io.WriteString(w, str)
+	// Remove this device from all the channels it was plugged into
+	err = disconnect(did, channelsSide(r), d.Channels, func() error {
+		return deviceRepo.Remove(did, d.Revision)
+	})
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		switch err {
+		case models.ErrConflict:
+			writeError(w, r, errModified(r, did))
+		default:
+			writeError(w, r, notFound(err, did))
+		}
+		return
+	}
+
+	ch.commit(nil)
+	peers.commit()
+
+	writeJSON(w, http.StatusOK, response{"deleted", did})
 }
 
-// unplugDevice function
-// Unlugs given device from a list of channels - i.e. removes
+// plugDevice function
+// Plugs given list of channels into device - i.e. creates a
 // connection between device and list of channels provided
-func unplugDevice(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+func plugDevice(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	did := bone.GetValue(r, "device_id")
 
-	data, err := ioutil.ReadAll(r.Body)
+	channels, err := decodeIDs(data)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
 
-	/**
-	if len(data) > 0 {
-		var body map[string]interface{}
-		if err := json.Unmarshal(data, &body); err != nil {
-			panic(err)
-		}
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+	before, err := oneDevice(r, did)
+	if err != nil {
+		writeError(w, r, notFound(err, did))
 		return
 	}
-	*/
 
-	/**
-	if validateJsonSchema("channel", body) != true {
-		println("Invalid schema")
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "invalid json schema in request"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Plugged, events.ResourceDevice, did, before.Tenant, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceChannel, channels)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+	results, err := connect(devicesSide(r), did, managedChannelsSide(r), channels, true)
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		writeError(w, r, err)
 		return
 	}
-	**/
+	ch.commitLinks(results)
+	peers.commit()
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	writeJSON(w, http.StatusOK, plugResponse{"plugged", did, results})
+}
+
+// unplugDevice function
+// Unlugs given device from a list of channels - i.e. removes
+// connection between device and list of channels provided
+func unplugDevice(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	did := bone.GetValue(r, "device_id")
 
-	var channels []string
-	if err := json.Unmarshal(data, &channels); err != nil {
-		panic(err)
-	}
-
-	for _, cid := range channels {
-		// Remove cid from the Device's `Channels` registry
-		t := time.Now().UTC().Format(time.RFC3339)
-		err := Db.C("channels").Update(bson.M{"id": cid},
-			bson.M{"$pull": bson.M{"devices": did}, "$set": bson.M{"updated": t}})
-		if err != nil {
-			log.Print(err)
-			w.WriteHeader(http.StatusNotFound)
-			str := `{"response": "cannot unplug device ` + did + ` from channel ` + cid + `"}`
-			io.WriteString(w, str)
-			return
-		}
+	channels, err := decodeIDs(data)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
+	before, err := oneDevice(r, did)
+	if err != nil {
+		writeError(w, r, notFound(err, did))
+		return
 	}
 
-	/** Remove channel list from devices's Channels[] */
-	colQuerier := bson.M{"id": did}
-	// Timestamp
-	t := time.Now().UTC().Format(time.RFC3339)
-	// Remove entry from exiting array
-	change := bson.M{"$pull": bson.M{"channels": bson.M{"$in": channels}}, "$set": bson.M{"updated": t}}
-	if err := Db.C("devices").Update(colQuerier, change); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not unplugged", "id": "` + did + `"}`
-		io.WriteString(w, str)
+	ch, err := stage(r, events.Unplugged, events.ResourceDevice, did, before.Tenant, before)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	peers, err := stagePeers(r, events.ResourceChannel, channels)
+	if err != nil {
+		ch.abort()
+		writeError(w, r, err)
+		return
+	}
+	results, err := connect(devicesSide(r), did, managedChannelsSide(r), channels, false)
+	if err != nil {
+		ch.abort()
+		peers.abort()
+		writeError(w, r, err)
 		return
 	}
+	ch.commitLinks(results)
+	peers.commit()
 
-	w.WriteHeader(http.StatusOK)
-	str := `{"response": "uplugged", "id": "` + did + `"}`
-	io.WriteString(w, str)
+	writeJSON(w, http.StatusOK, plugResponse{"unplugged", did, results})
 }
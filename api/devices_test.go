@@ -9,12 +9,12 @@
 package api_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"testing"
 
-	"github.com/mainflux/mainflux-core/db"
 	"github.com/mainflux/mainflux-core/models"
 )
 
@@ -29,21 +29,21 @@ func TestCreateDevice(t *testing.T) {
 		header string
 		code   int
 	}{
-		{"",                                 "api-key", http.StatusCreated},
-		{`{"name": "test"}`,                 "api-key", http.StatusCreated},
-		{`{"description": "test"}`,          "api-key", http.StatusCreated},
-		{`{"metadata": {"test": "mTest"}}`,  "api-key", http.StatusCreated},
+		{"", "api-key", http.StatusCreated},
+		{`{"name": "test"}`, "api-key", http.StatusCreated},
+		{`{"description": "test"}`, "api-key", http.StatusCreated},
+		{`{"metadata": {"test": "mTest"}}`, "api-key", http.StatusCreated},
 		{`{"name": "test",` +
-		 `"metadata": {"m1": "test",` +
-		 `"m2": "test" }}`,                  "api-key", http.StatusCreated},
-
-		{"invalid",                          "api-key", http.StatusBadRequest},
-		{`{"id": "test"}`,                   "api-key", http.StatusBadRequest},
-		{`{"created": "test"}`,              "api-key", http.StatusBadRequest},
-		{`{"channels": "test"}`,             "api-key", http.StatusBadRequest},
-		{`{"metadata": "string"`,            "api-key", http.StatusBadRequest},
-		{`{"connected_at": "0"`,             "api-key", http.StatusBadRequest},
-		{`{"disconnected_at": "0"`,          "api-key", http.StatusBadRequest},
+			`"metadata": {"m1": "test",` +
+			`"m2": "test" }}`, "api-key", http.StatusCreated},
+
+		{"invalid", "api-key", http.StatusBadRequest},
+		{`{"id": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"created": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"channels": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"metadata": "string"`, "api-key", http.StatusBadRequest},
+		{`{"connected_at": "0"`, "api-key", http.StatusBadRequest},
+		{`{"disconnected_at": "0"`, "api-key", http.StatusBadRequest},
 		{`{"name": "` + string(n[:]) + `"}`, "api-key", http.StatusBadRequest},
 		{`{"description": "` + string(d[:]) + `"}`, "api-key", http.StatusBadRequest},
 	}
@@ -59,11 +59,11 @@ func TestCreateDevice(t *testing.T) {
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -87,75 +87,77 @@ func TestGetDevices(t *testing.T) {
 	cases := []struct {
 		header string
 		code   int
+		total  int
 	}{
-		{"api-key", http.StatusOK},
-		{"api-key", http.StatusNotFound},
+		{"api-key", http.StatusOK, 2},
+		{"api-key", http.StatusOK, 0},
 	}
 
-	// Init MongoDB (docker_test)
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	for i, c := range cases {
 		// case 1
 		if i == 0 {
+			removeDevices()
+			removeChannels()
 			// Insert Devices
-			d := models.Device{}
-			d.ID = "testID"
-			Db.C("devices").Insert(d)
-			d.ID = "testID2"
-			Db.C("devices").Insert(d)
-		// case 2
+			m := models.Device{}
+			m.ID = "testID"
+			devices.Save(m)
+			m.ID = "testID2"
+			devices.Save(m)
+			// case 2
 		} else {
-			Db.C("devices").RemoveAll(nil)
+			removeDevices()
 		}
 
 		url := fmt.Sprintf("%s/devices", ts.URL)
 		cli := &http.Client{}
 		res, err := cli.Get(url)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
 		}
+
+		var body listResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		if body.Total != c.total || len(body.Items) != c.total {
+			t.Errorf("case %d: expected %d items, got %d of %d", i+1, c.total, len(body.Items), body.Total)
+		}
 	}
 }
 
-
 func TestGetDevice(t *testing.T) {
 	cases := []struct {
 		id     string
 		header string
 		code   int
 	}{
-		{"validID",   "api-key", http.StatusOK},
+		{"validID", "api-key", http.StatusOK},
 		{"invalidID", "api-key", http.StatusNotFound},
 	}
 
-	// Init MongoDB (docker_test)
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device with id "existentTestID" in DB
 	d := models.Device{}
 	d.ID = cases[0].id
-	Db.C("devices").Insert(d)
+	devices.Save(d)
 
 	for i, c := range cases {
-		url := fmt.Sprintf("%s/devices/" + c.id, ts.URL)
+		url := fmt.Sprintf("%s/devices/"+c.id, ts.URL)
 		cli := &http.Client{}
 		res, err := cli.Get(url)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -169,34 +171,29 @@ func TestUpdateDevice(t *testing.T) {
 		header string
 		code   int
 	}{
-		{"",                                 "api-key", http.StatusBadRequest}, // `{"description": "no data provided"}`,
-		{`{"name": "test"}`,                 "api-key", http.StatusOK},
-		{`{"description": "test"}`,          "api-key", http.StatusOK},
-		{`{"metadata": {"test": "mTest"}}`,  "api-key", http.StatusOK},
+		{"", "api-key", http.StatusBadRequest}, // `{"description": "no data provided"}`,
+		{`{"name": "test"}`, "api-key", http.StatusOK},
+		{`{"description": "test"}`, "api-key", http.StatusOK},
+		{`{"metadata": {"test": "mTest"}}`, "api-key", http.StatusOK},
 		{`{"name": "test",` +
-		 `"metadata": {"m1": "test",` +
-		 `"m2": "test" }}`,                  "api-key", http.StatusOK},
-
-		{"invalid",                          "api-key", http.StatusBadRequest}, // `{"description": "no data provided"}`,
-		{`{"id": "test"}`,                   "api-key", http.StatusBadRequest},
-		{`{"created": "test"}`,              "api-key", http.StatusBadRequest},
-		{`{"channels": "test"}`,             "api-key", http.StatusBadRequest},
-		{`{"metadata": "string"`,            "api-key", http.StatusBadRequest},
-		{`{"connected_at": "0"`,             "api-key", http.StatusBadRequest},
-		{`{"disconnected_at": "0"`,          "api-key", http.StatusBadRequest},
+			`"metadata": {"m1": "test",` +
+			`"m2": "test" }}`, "api-key", http.StatusOK},
+
+		{"invalid", "api-key", http.StatusBadRequest}, // `{"description": "no data provided"}`,
+		{`{"id": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"created": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"channels": "test"}`, "api-key", http.StatusBadRequest},
+		{`{"metadata": "string"`, "api-key", http.StatusBadRequest},
+		{`{"connected_at": "0"`, "api-key", http.StatusBadRequest},
+		{`{"disconnected_at": "0"`, "api-key", http.StatusBadRequest},
 		{`{"name": "` + string(n[:]) + `"}`, "api-key", http.StatusBadRequest},
 		{`{"description": "` + string(d[:]) + `"}`, "api-key", http.StatusBadRequest},
 	}
 
-	// Init MongoDB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device in DB
 	d := models.Device{}
 	d.ID = "IDtestDevice"
-	Db.C("devices").Insert(d)
+	devices.Save(d)
 
 	url := fmt.Sprintf("%s/devices/%s", ts.URL, d.ID)
 
@@ -209,11 +206,11 @@ func TestUpdateDevice(t *testing.T) {
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
-		defer res.Body.Close()
-
 		if err != nil {
 			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
 		}
+		defer res.Body.Close()
 
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
@@ -223,40 +220,350 @@ func TestUpdateDevice(t *testing.T) {
 
 func TestDeleteDevice(t *testing.T) {
 	cases := []struct {
-		ID   string
+		ID     string
 		header string
 		code   int
 	}{
-		{"invalid",        "api-key", http.StatusNotFound},
+		{"invalid", "api-key", http.StatusNotFound},
 		{"existentTestID", "api-key", http.StatusOK},
 	}
 
-	// TODO: use docker_test for DB
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	// Insert device with id "existentTestID" in DB
 	d := models.Device{}
 	d.ID = cases[1].ID
-	Db.C("devices").Insert(d)
+	devices.Save(d)
 
 	for i, c := range cases {
 		url := fmt.Sprintf("%s/devices/%s", ts.URL, c.ID)
 
-		req, err := http.NewRequest("DELETE", url, nil)
+		req, _ := http.NewRequest("DELETE", url, nil)
 		req.Header.Set("Authorization", c.header)
 
 		cli := &http.Client{}
 		res, err := cli.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
 		defer res.Body.Close()
 
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func removeDevices() {
+	all, _, _ := devices.All(models.Query{})
+	for _, d := range all {
+		devices.Remove(d.ID, models.AnyRevision)
+	}
+}
+
+func TestGetDevicesPagination(t *testing.T) {
+	removeDevices()
+
+	names := []string{"e", "b", "d", "a", "c"}
+	for i, name := range names {
+		d := models.Device{
+			ID:      fmt.Sprintf("pagedID%d", i),
+			Name:    name,
+			Created: fmt.Sprintf("2017-01-0%dT00:00:00Z", i+1),
+		}
+		devices.Save(d)
+	}
+
+	cases := []struct {
+		query  string
+		code   int
+		names  []string
+		fields int
+	}{
+		{"?limit=2", http.StatusOK, names, 0},
+		{"?limit=2&sort=name", http.StatusOK, []string{"a", "b", "c", "d", "e"}, 0},
+		{"?limit=3&sort=-name", http.StatusOK, []string{"e", "d", "c", "b", "a"}, 0},
+		{"?sort=-created&fields=name", http.StatusOK, []string{"c", "a", "d", "b", "e"}, 1},
+		{"?limit=0", http.StatusBadRequest, nil, 0},
+		{"?limit=abc", http.StatusBadRequest, nil, 0},
+		{"?sort=metadata", http.StatusBadRequest, nil, 0},
+		{"?fields=unknown", http.StatusBadRequest, nil, 0},
+		{"?cursor=invalid", http.StatusBadRequest, nil, 0},
+	}
+
+	for i, c := range cases {
+		got := []string{}
+		next := "/devices" + c.query
+
+		// Follow `next` links until the last page
+		for len(next) > 0 {
+			res, err := http.Get(ts.URL + next)
+			if err != nil {
+				t.Fatalf("case %d: %s", i+1, err.Error())
+			}
+
+			if res.StatusCode != c.code {
+				t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+			}
+
+			var body listResponse
+			json.NewDecoder(res.Body).Decode(&body)
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				break
+			}
+
+			if body.Total != len(names) {
+				t.Errorf("case %d: expected total %d, got %d", i+1, len(names), body.Total)
+			}
+
+			for _, item := range body.Items {
+				if c.fields > 0 && len(item) != c.fields {
+					t.Errorf("case %d: expected %d fields, got %v", i+1, c.fields, item)
+				}
+				got = append(got, item["name"].(string))
+			}
+			next = body.Next
+		}
+
+		if c.names != nil && strings.Join(got, "") != strings.Join(c.names, "") {
+			t.Errorf("case %d: expected %v, got %v", i+1, c.names, got)
+		}
+	}
+}
+
+func TestGetDevicesFilter(t *testing.T) {
+	removeDevices()
+
+	online := true
+	seed := []models.Device{
+		{ID: "f1", Name: "Boiler sensor", Online: online, Tags: []string{"temp", "hall"},
+			Created: "2017-01-01T00:00:00Z", Updated: "2017-02-01T00:00:00Z",
+			Metadata: map[string]interface{}{"site": "plant-3", "floor": float64(2)}},
+		{ID: "f2", Name: "Door relay", Tags: []string{"hall"},
+			Created: "2017-01-02T00:00:00Z", Updated: "2017-03-01T00:00:00Z",
+			Metadata: map[string]interface{}{"site": "plant-1", "loc": map[string]interface{}{"room": "a1"}}},
+		{ID: "f3", Name: "boiler valve", Online: online,
+			Created: "2017-01-03T00:00:00Z", Updated: "2017-04-01T00:00:00Z",
+			Metadata: map[string]interface{}{"site": "plant-3"}},
+	}
+	for _, d := range seed {
+		devices.Save(d)
+	}
+
+	cases := []struct {
+		query string
+		code  int
+		ids   string
+	}{
+		{"", http.StatusOK, "f1f2f3"},
+		{"?name=Door%20relay", http.StatusOK, "f2"},
+		{"?name~=BOILER", http.StatusOK, "f1f3"},
+		{"?tag=hall", http.StatusOK, "f1f2"},
+		{"?tag=hall&tag=temp", http.StatusOK, "f1"},
+		{"?online=true", http.StatusOK, "f1f3"},
+		{"?online=false", http.StatusOK, "f2"},
+		{"?metadata.site=plant-3", http.StatusOK, "f1f3"},
+		{"?metadata.floor=2", http.StatusOK, "f1"},
+		{"?metadata.loc.room=a1", http.StatusOK, "f2"},
+		{"?updated_after=2017-02-15T00:00:00Z", http.StatusOK, "f2f3"},
+		{"?created_before=2017-01-02T00:00:00Z", http.StatusOK, "f1"},
+		{"?name~=boiler&metadata.site=plant-3&online=true&tag=temp", http.StatusOK, "f1"},
+		{"?metadata.site=plant-9", http.StatusOK, ""},
+		{"?online=maybe", http.StatusBadRequest, ""},
+		{"?updated_after=yesterday", http.StatusBadRequest, ""},
+		{"?colour=red", http.StatusBadRequest, ""},
+		{"?metadata.=plant-3", http.StatusBadRequest, ""},
+		{"?metadata.loc..room=a1", http.StatusBadRequest, ""},
+		{"?metadata.site.=plant-3", http.StatusBadRequest, ""},
+		{"?metadata.%24where=1", http.StatusBadRequest, ""},
+		{"?metadata.loc.%24gt=a", http.StatusBadRequest, ""},
+	}
+
+	for i, c := range cases {
+		res, err := http.Get(ts.URL + "/devices" + c.query)
 		if err != nil {
-			t.Errorf("case %d: %s", i+1, err.Error())
+			t.Fatalf("case %d: %s", i+1, err.Error())
 		}
 
+		var body listResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
 		if res.StatusCode != c.code {
 			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		ids := ""
+		for _, item := range body.Items {
+			ids += item["id"].(string)
+		}
+
+		if ids != c.ids || body.Total != len(body.Items) {
+			t.Errorf("case %d: expected %s, got %s (total %d)", i+1, c.ids, ids, body.Total)
+		}
+	}
+}
+
+func TestDeviceConditionalRequests(t *testing.T) {
+	d := models.Device{ID: "etagID", Revision: 1}
+	devices.Save(d)
+
+	url := fmt.Sprintf("%s/devices/%s", ts.URL, d.ID)
+
+	cases := []struct {
+		method string
+		header string
+		value  string
+		body   string
+		code   int
+		etag   string
+	}{
+		{"GET", "", "", "", http.StatusOK, `"1"`},
+		{"GET", "If-None-Match", `"1"`, "", http.StatusNotModified, `"1"`},
+		{"GET", "If-None-Match", `W/"1"`, "", http.StatusNotModified, `"1"`},
+		{"GET", "If-None-Match", `"0"`, "", http.StatusOK, `"1"`},
+		{"PUT", "If-Match", `"0"`, `{"name": "test"}`, http.StatusPreconditionFailed, ""},
+		{"PUT", "If-None-Match", "*", `{"name": "test"}`, http.StatusPreconditionFailed, ""},
+		{"PUT", "If-Match", `"0", "1"`, `{"name": "test"}`, http.StatusOK, `"2"`},
+		{"PUT", "", "", `{"name": "test"}`, http.StatusOK, `"3"`},
+		{"DELETE", "If-Match", `"2"`, "", http.StatusPreconditionFailed, ""},
+		{"DELETE", "If-Match", `"3"`, "", http.StatusOK, ""},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, url, strings.NewReader(c.body))
+		if len(c.header) > 0 {
+			req.Header.Set(c.header, c.value)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+		}
+
+		if len(c.etag) > 0 && res.Header.Get("ETag") != c.etag {
+			t.Errorf("case %d: expected ETag %s, got %s", i+1, c.etag, res.Header.Get("ETag"))
 		}
 	}
 }
+
+func TestPatchDevice(t *testing.T) {
+	d := models.Device{
+		ID:       "patchID",
+		Name:     "old",
+		Revision: 1,
+		Tags:     []string{"a", "b"},
+		Metadata: map[string]interface{}{
+			"site": "plant-3",
+			"loc":  map[string]interface{}{"room": "a1", "floor": float64(2)},
+		},
+	}
+	devices.Save(d)
+
+	url := fmt.Sprintf("%s/devices/%s", ts.URL, d.ID)
+
+	merge := "application/merge-patch+json"
+	patch := "application/json-patch+json"
+
+	cases := []struct {
+		ctype string
+		body  string
+		code  int
+		check func(models.Device) bool
+	}{
+		{merge, `{"metadata": {"site": null, "loc": {"room": "b2"}}}`, http.StatusOK,
+			func(d models.Device) bool {
+				loc := d.Metadata["loc"].(map[string]interface{})
+				_, ok := d.Metadata["site"]
+				return !ok && loc["room"] == "b2" && loc["floor"] == float64(2) && d.Name == "old"
+			}},
+		{merge, `{"name": "new", "tags": null}`, http.StatusOK,
+			func(d models.Device) bool { return d.Name == "new" && len(d.Tags) == 0 }},
+		{patch, `[{"op": "add", "path": "/tags", "value": ["x"]},` +
+			`{"op": "add", "path": "/tags/-", "value": "z"},` +
+			`{"op": "add", "path": "/tags/1", "value": "y"}]`, http.StatusOK,
+			func(d models.Device) bool { return strings.Join(d.Tags, "") == "xyz" }},
+		{patch, `[{"op": "test", "path": "/metadata/loc/room", "value": "b2"},` +
+			`{"op": "move", "from": "/metadata/loc/room", "path": "/metadata/room"},` +
+			`{"op": "copy", "from": "/name", "path": "/description"},` +
+			`{"op": "remove", "path": "/tags/0"}]`, http.StatusOK,
+			func(d models.Device) bool {
+				loc := d.Metadata["loc"].(map[string]interface{})
+				_, ok := loc["room"]
+				return !ok && d.Metadata["room"] == "b2" && d.Description == "new" &&
+					strings.Join(d.Tags, "") == "yz"
+			}},
+		{patch, `[{"op": "replace", "path": "/name", "value": "a~b/c"},` +
+			`{"op": "add", "path": "/metadata/a~1b", "value": 1}]`, http.StatusOK,
+			func(d models.Device) bool { return d.Name == "a~b/c" && d.Metadata["a/b"] == float64(1) }},
+
+		// Failing patches must leave the device intact
+		{patch, `[{"op": "replace", "path": "/name", "value": "x"},` +
+			`{"op": "test", "path": "/name", "value": "y"}]`, http.StatusBadRequest, nil},
+		{patch, `[{"op": "remove", "path": "/metadata/missing"}]`, http.StatusBadRequest, nil},
+		{patch, `[{"op": "add", "path": "/id", "value": "hijack"}]`, http.StatusBadRequest, nil},
+		{patch, `[{"op": "replace", "path": "/name", "value": 5}]`, http.StatusBadRequest, nil},
+		{patch, `[{"op": "jump", "path": "/name"}]`, http.StatusBadRequest, nil},
+		{patch, `{"op": "add"}`, http.StatusBadRequest, nil},
+		{merge, `{"channels": ["c1"]}`, http.StatusBadRequest, nil},
+		{merge, `["not", "an", "object"]`, http.StatusBadRequest, nil},
+		{"application/json", `{"name": "x"}`, http.StatusUnsupportedMediaType, nil},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("PATCH", url, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.ctype)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		stored, _ := devices.One(d.ID)
+		if c.check != nil && !c.check(stored) {
+			t.Errorf("case %d: unexpected device %+v", i+1, stored)
+		}
+		if c.check == nil && stored.Name != "a~b/c" {
+			t.Errorf("case %d: device modified by failed patch: %+v", i+1, stored)
+		}
+	}
+}
+
+func TestUpdateDeviceReplaces(t *testing.T) {
+	d := models.Device{
+		ID:       "replaceID",
+		Name:     "old",
+		Channels: []string{"c1"},
+		Metadata: map[string]interface{}{"site": "plant-3"},
+	}
+	devices.Save(d)
+
+	url := fmt.Sprintf("%s/devices/%s", ts.URL, d.ID)
+	req, _ := http.NewRequest("PUT", url, strings.NewReader(`{"description": "new"}`))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+	res.Body.Close()
+
+	stored, _ := devices.One(d.ID)
+	if stored.Name != "" || stored.Metadata != nil || stored.Description != "new" {
+		t.Errorf("expected writable fields to be replaced, got %+v", stored)
+	}
+	if len(stored.Channels) != 1 || stored.Revision != 1 {
+		t.Errorf("expected read-only fields to be kept, got %+v", stored)
+	}
+}
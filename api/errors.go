@@ -0,0 +1,168 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Machine-readable error codes
+const (
+	codeBadRequest           = "bad_request"
+	codeValidationFailed     = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeConflict             = "conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeUnavailable          = "unavailable"
+	codeInternal             = "internal_error"
+)
+
+// statusCodes maps error codes onto HTTP statuses.
+var statusCodes = map[string]int{
+	codeBadRequest:           http.StatusBadRequest,
+	codeValidationFailed:     http.StatusBadRequest,
+	codeUnauthorized:         http.StatusUnauthorized,
+	codeForbidden:            http.StatusForbidden,
+	codeNotFound:             http.StatusNotFound,
+	codeConflict:             http.StatusConflict,
+	codePreconditionFailed:   http.StatusPreconditionFailed,
+	codeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	codeUnavailable:          http.StatusServiceUnavailable,
+	codeInternal:             http.StatusInternalServerError,
+}
+
+type (
+	// apiError is the error reported to API clients.
+	apiError struct {
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Details   []fieldError `json:"details,omitempty"`
+		ID        string       `json:"id,omitempty"`
+		Results   []idResult   `json:"results,omitempty"`
+		RequestID string       `json:"request_id,omitempty"`
+	}
+
+	// fieldError describes invalid field of the request body.
+	fieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// errorResponse is the envelope of error responses.
+	errorResponse struct {
+		Error apiError `json:"error"`
+	}
+
+	// response is the body of successful responses that
+	// carry no resource representation.
+	response struct {
+		Response string `json:"response"`
+		ID       string `json:"id,omitempty"`
+	}
+)
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// status returns HTTP status of the error.
+func (e *apiError) status() int {
+	if s, ok := statusCodes[e.Code]; ok {
+		return s
+	}
+
+	return http.StatusInternalServerError
+}
+
+func errBadRequest(msg string) *apiError {
+	return &apiError{Code: codeBadRequest, Message: msg}
+}
+
+func errForbidden(msg string) *apiError {
+	return &apiError{Code: codeForbidden, Message: msg}
+}
+
+func errNotFound(id string) *apiError {
+	return &apiError{Code: codeNotFound, Message: "not found", ID: id}
+}
+
+func errUnavailable(msg string) *apiError {
+	return &apiError{Code: codeUnavailable, Message: msg}
+}
+
+func errPreconditionFailed(id string) *apiError {
+	return &apiError{Code: codePreconditionFailed, Message: "precondition failed", ID: id}
+}
+
+// errModified is reported when the resource was modified concurrently
+// since it was read. Conditional requests get 412, others 409.
+func errModified(r *http.Request, id string) *apiError {
+	e := &apiError{Code: codeConflict, Message: "modified concurrently", ID: id}
+	if isConditional(r) {
+		e.Code = codePreconditionFailed
+	}
+
+	return e
+}
+
+// writeError responds with the error. Repository errors are translated,
+// and any other error is logged and reported as internal error, so that
+// no implementation details leak to the clients.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e apiError
+
+	switch t := err.(type) {
+	case *apiError:
+		e = *t
+	default:
+		switch err {
+		case models.ErrNotFound:
+			e = *errNotFound("")
+		case models.ErrConflict:
+			e = *errModified(r, "")
+		default:
+			reqLog(r).Error("request failed", "err", err)
+			e = apiError{Code: codeInternal, Message: "internal server error"}
+		}
+	}
+
+	e.RequestID = requestID(r)
+	writeJSON(w, e.status(), errorResponse{e})
+}
+
+// writeJSON responds with the JSON encoded value.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logging.Error("cannot encode response", "err", err)
+		status = http.StatusInternalServerError
+		b = []byte(`{"error": {"code": "` + codeInternal + `", "message": "internal server error"}}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// notFound attaches resource ID to repository ErrNotFound,
+// and leaves other errors intact.
+func notFound(err error, id string) error {
+	if err == models.ErrNotFound {
+		return errNotFound(id)
+	}
+
+	return err
+}
@@ -0,0 +1,183 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// errorResponse mirrors the envelope of error responses.
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"details"`
+		ID        string `json:"id"`
+		RequestID string `json:"request_id"`
+	} `json:"error"`
+}
+
+func TestErrorResponses(t *testing.T) {
+	devices.Save(models.Device{ID: "errorsID", Revision: 1})
+	channels.Save(models.Channel{ID: "errorsID", Revision: 1})
+
+	cases := []struct {
+		method  string
+		path    string
+		body    string
+		code    int
+		errCode string
+		id      string
+		fields  []string
+	}{
+		{"GET", "/devices/unknown", "", http.StatusNotFound, "not_found", "unknown", nil},
+		{"GET", "/channels/unknown", "", http.StatusNotFound, "not_found", "unknown", nil},
+		{"GET", "/devices?limit=x", "", http.StatusBadRequest, "bad_request", "", nil},
+		{"GET", "/devices?unknown=1", "", http.StatusBadRequest, "bad_request", "", nil},
+		{"POST", "/devices", `{"id": "x", "name": 1}`, http.StatusBadRequest, "validation_failed", "", []string{"id", "name"}},
+		{"POST", "/channels", `{"tags": "x"}`, http.StatusBadRequest, "validation_failed", "", []string{"tags"}},
+		{"POST", "/devices/errorsID/plug", `{"channels"`, http.StatusBadRequest, "bad_request", "", nil},
+		{"POST", "/devices/errorsID/plug", `["unknown"]`, http.StatusNotFound, "not_found", "errorsID", nil},
+		{"POST", "/channels/errorsID/unplug", `"errorsID"`, http.StatusBadRequest, "bad_request", "", nil},
+		{"POST", "/channels/unknown/msg", `[]`, http.StatusNotFound, "not_found", "unknown", nil},
+		{"POST", "/channels/errorsID/msg", "", http.StatusBadRequest, "bad_request", "", nil},
+		{"GET", "/channels/errorsID/msg?start_time=x", "", http.StatusBadRequest, "bad_request", "", nil},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+
+		var body errorResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+		}
+
+		if err != nil {
+			t.Errorf("case %d: cannot decode error body: %s", i+1, err.Error())
+			continue
+		}
+
+		if body.Error.Code != c.errCode {
+			t.Errorf("case %d: expected code %s, got %s", i+1, c.errCode, body.Error.Code)
+		}
+
+		if body.Error.ID != c.id {
+			t.Errorf("case %d: expected id %s, got %s", i+1, c.id, body.Error.ID)
+		}
+
+		if len(body.Error.RequestID) == 0 ||
+			body.Error.RequestID != res.Header.Get("X-Request-ID") {
+			t.Errorf("case %d: expected request ID to match header, got %s", i+1, body.Error.RequestID)
+		}
+
+		if len(body.Error.Details) != len(c.fields) {
+			t.Errorf("case %d: expected %d details, got %d", i+1, len(c.fields), len(body.Error.Details))
+			continue
+		}
+
+		for j, f := range c.fields {
+			if body.Error.Details[j].Field != f {
+				t.Errorf("case %d: expected field %s, got %s", i+1, f, body.Error.Details[j].Field)
+			}
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	cases := []struct {
+		id       string
+		expected string
+	}{
+		{"", ""},
+		{"client-id", "client-id"},
+		{strings.Repeat("x", 129), ""},
+	}
+
+	url := fmt.Sprintf("%s/status", ts.URL)
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", url, nil)
+		if len(c.id) > 0 {
+			req.Header.Set("X-Request-ID", c.id)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		id := res.Header.Get("X-Request-ID")
+		if len(id) == 0 {
+			t.Errorf("case %d: expected request ID to be set", i+1)
+		}
+
+		if len(c.expected) > 0 && id != c.expected {
+			t.Errorf("case %d: expected request ID %s, got %s", i+1, c.expected, id)
+		}
+
+		if len(c.expected) == 0 && id == c.id {
+			t.Errorf("case %d: expected request ID to be generated", i+1)
+		}
+	}
+}
+
+// panicking tenant repository panics on every lookup.
+type panicking struct {
+	models.TenantRepository
+}
+
+func (p panicking) One(id string) (models.Tenant, error) {
+	panic("tenant lookup " + id)
+}
+
+func TestRecovery(t *testing.T) {
+	// Tenant is resolved by middleware, ahead of the handlers
+	s := httptest.NewServer(api.HTTPServer(devices, channels, messages, panicking{tenants}, apiKeys, outbox))
+	defer s.Close()
+	defer api.HTTPServer(devices, channels, messages, tenants, apiKeys, outbox)
+
+	req, _ := http.NewRequest("GET", s.URL+"/channels", nil)
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("X-Request-ID", "recovery")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var body errorResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("cannot decode error body: %s", err.Error())
+	}
+
+	if res.StatusCode != http.StatusInternalServerError || body.Error.Code != "internal_error" || body.Error.RequestID != "recovery" {
+		t.Errorf("expected internal error of request recovery, got %d %+v", res.StatusCode, body.Error)
+	}
+}
@@ -0,0 +1,65 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats resource revision as a strong entity tag.
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// matchETag reports whether the If-Match or If-None-Match header value
+// matches the entity tag. Weak comparison ignores the `W/` prefix.
+func matchETag(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match request headers
+// against the current resource revision, as described in RFC 7232.
+// It returns 0 if the request may proceed, or the status to respond with:
+// 304 for safe methods and 412 for the others.
+func checkPreconditions(r *http.Request, revision int64) int {
+	tag := etag(revision)
+
+	if h := r.Header.Get("If-Match"); len(h) > 0 && !matchETag(h, tag, false) {
+		return http.StatusPreconditionFailed
+	}
+
+	if h := r.Header.Get("If-None-Match"); len(h) > 0 && matchETag(h, tag, true) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+
+	return 0
+}
+
+// isConditional reports whether the client asked for a conditional request.
+func isConditional(r *http.Request) bool {
+	return len(r.Header.Get("If-Match")) > 0 || len(r.Header.Get("If-None-Match")) > 0
+}
@@ -0,0 +1,287 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/mainflux/mainflux-core/events"
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+	"github.com/mainflux/mainflux-core/outbox"
+)
+
+type (
+	// change is an event staged in the outbox before the change it
+	// announces is made.
+	change struct {
+		seq   int64
+		event events.Event
+		// revision of the resource before the change
+		revision int64
+	}
+
+	// peerChanges are `updated` events staged about the peers of the
+	// resource, i.e. channels of a device or devices of a channel, which
+	// the request may change along with the resource.
+	peerChanges []*change
+)
+
+var (
+	eventsMu      sync.RWMutex
+	eventsSubject = events.DefaultSubject
+)
+
+// SetEventsSubject function
+// Sets prefix of the subjects events are published on.
+func SetEventsSubject(s string) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	eventsSubject = s
+}
+
+// stage function
+// Stages event about the change of the resource the request is about
+// to make in the outbox, where it holds back the later events about the
+// resource. Mongo offers no transactions across collections, so the
+// event is staged before the change, and either committed or aborted
+// after it. Events left staged, e.g. by a crash, are reconciled by the
+// outbox relay with ReconcileEvent.
+func stage(r *http.Request, typ, resource, id, tenant string, before interface{}) (*change, error) {
+	e, err := events.New(typ, resource, id, before, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.Tenant = tenant
+	if p, ok := principal(r); ok {
+		e.Actor = p.ID
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := outboxRepo.Stage(outbox.NewRecord(e.ID, "", eventKey(e), b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &change{seq: seq, event: e}, nil
+}
+
+// commit resolves the staged event by the state of the resource after
+// the change. The change is made at this point, so failures are left
+// to the relay to reconcile rather than reported to the client.
+func (c *change) commit(after interface{}) {
+	rec, err := eventRecord(c.event, after)
+	if err == nil {
+		err = outboxRepo.Resolve(c.seq, rec)
+	}
+	if err != nil {
+		logging.Warn("cannot commit event", "id", c.event.ID, "err", err)
+	}
+}
+
+// abort resolves the staged event of the change that was not made by
+// a record without subject, which the relay drops. Removing it would
+// leave a gap in sequence numbers the relay waits for.
+func (c *change) abort() {
+	rec := outbox.NewRecord(c.event.ID, "", eventKey(c.event), nil)
+	if err := outboxRepo.Resolve(c.seq, rec); err != nil && err != models.ErrNotFound {
+		logging.Warn("cannot abort event", "id", c.event.ID, "err", err)
+	}
+}
+
+// stagePeers function
+// Stages events about the peers of the request tenant with the IDs.
+// Missing peers are skipped, as the request changes none of them.
+func stagePeers(r *http.Request, resource string, ids []string) (peerChanges, error) {
+	var pcs peerChanges
+	for _, id := range ids {
+		var before interface{}
+		var tenant string
+		var revision int64
+		var err error
+		switch resource {
+		case events.ResourceDevice:
+			var d models.Device
+			d, err = oneDevice(r, id)
+			before, tenant, revision = d, d.Tenant, d.Revision
+		case events.ResourceChannel:
+			var c models.Channel
+			c, err = oneChannel(r, id)
+			before, tenant, revision = c, c.Tenant, c.Revision
+		}
+		if err == models.ErrNotFound {
+			continue
+		}
+
+		var c *change
+		if err == nil {
+			c, err = stage(r, events.Updated, resource, id, tenant, before)
+		}
+		if err != nil {
+			pcs.abort()
+			return nil, err
+		}
+		c.revision = revision
+		pcs = append(pcs, c)
+	}
+
+	return pcs, nil
+}
+
+// commit commits events about the peers changed by the request, i.e.
+// whose revision advanced, and aborts the rest.
+func (pcs peerChanges) commit() {
+	for _, c := range pcs {
+		after, revision, err := resourceState(c.event.Resource, c.event.ResourceID)
+		switch {
+		case err != nil:
+			logging.Warn("cannot commit event", "id", c.event.ID, "err", err)
+		case after == nil || revision <= c.revision:
+			c.abort()
+		default:
+			c.commit(after)
+		}
+	}
+}
+
+// abort aborts events about all the peers.
+func (pcs peerChanges) abort() {
+	for _, c := range pcs {
+		c.abort()
+	}
+}
+
+// eventRecord function
+// Creates outbox record of the event with the state of the resource
+// after the change, published on the subject of the event type.
+func eventRecord(e events.Event, after interface{}) (models.OutboxRecord, error) {
+	var err error
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			return models.OutboxRecord{}, err
+		}
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return models.OutboxRecord{}, err
+	}
+
+	eventsMu.RLock()
+	subject := events.Subject(eventsSubject, e)
+	eventsMu.RUnlock()
+
+	return outbox.NewRecord(e.ID, subject, eventKey(e), b), nil
+}
+
+// eventKey returns the key keeping events about the resource in order.
+func eventKey(e events.Event) string {
+	return e.Resource + ":" + e.ResourceID
+}
+
+// ReconcileEvent function
+// Resolves event left staged by the current state of the resource.
+// Revisions tell whether the change was made, in which case the event
+// is published with the current state of the resource, which includes
+// any changes made afterwards. Events of the changes that were not made
+// are abandoned.
+func ReconcileEvent(rec models.OutboxRecord) (models.OutboxRecord, error) {
+	var e events.Event
+	if err := json.Unmarshal(rec.Data, &e); err != nil {
+		return rec, err
+	}
+
+	var before struct {
+		Revision int64 `json:"revision"`
+	}
+	if len(e.Before) > 0 {
+		if err := json.Unmarshal(e.Before, &before); err != nil {
+			return rec, err
+		}
+	}
+
+	current, revision, err := resourceState(e.Resource, e.ResourceID)
+	if err != nil {
+		return rec, err
+	}
+
+	made := false
+	switch e.Type {
+	case events.Created:
+		made = current != nil
+	case events.Deleted:
+		made = current == nil
+	default:
+		made = current != nil && revision > before.Revision
+	}
+	if !made {
+		return rec, outbox.ErrAbandoned
+	}
+
+	return eventRecord(e, current)
+}
+
+// resourceState returns the current state and revision of the resource,
+// or nil if the resource is missing.
+func resourceState(resource, id string) (interface{}, int64, error) {
+	var err error
+	switch resource {
+	case events.ResourceDevice:
+		var d models.Device
+		if d, err = deviceRepo.One(id); err == nil {
+			return d, d.Revision, nil
+		}
+	case events.ResourceChannel:
+		var c models.Channel
+		if c, err = channelRepo.One(id); err == nil {
+			return c, c.Revision, nil
+		}
+	}
+
+	if err == models.ErrNotFound {
+		return nil, 0, nil
+	}
+	return nil, 0, err
+}
+
+// relinked reports whether any of the results changed the relations.
+func relinked(results []idResult) bool {
+	for _, res := range results {
+		if res.Status == statusPlugged || res.Status == statusUnplugged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commitLinks commits staged plug or unplug event if the request
+// changed the relations, and aborts it otherwise. The resource is
+// fetched again for its state after the change.
+func (c *change) commitLinks(results []idResult) {
+	if !relinked(results) {
+		c.abort()
+		return
+	}
+
+	after, _, err := resourceState(c.event.Resource, c.event.ResourceID)
+	if err != nil {
+		logging.Warn("cannot commit event", "id", c.event.ID, "err", err)
+		return
+	}
+
+	c.commit(after)
+}
@@ -0,0 +1,279 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/events"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// outboxEvents returns the events about the resource waiting in the
+// outbox, along with their subjects.
+func outboxEvents(t *testing.T, id string) ([]events.Event, []string) {
+	records, err := outbox.Pending(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var evs []events.Event
+	var subjects []string
+	for _, r := range records {
+		var e events.Event
+		if json.Unmarshal(r.Data, &e) != nil || e.ResourceID != id {
+			continue
+		}
+		if r.ID != e.ID {
+			t.Errorf("expected record ID %s got %s", e.ID, r.ID)
+		}
+		evs = append(evs, e)
+		subjects = append(subjects, r.Subject)
+	}
+
+	return evs, subjects
+}
+
+func TestEvents(t *testing.T) {
+	channels.Save(models.Channel{ID: "evC1", Revision: 1})
+
+	res, err := http.Post(ts.URL+"/devices", "application/json", strings.NewReader(`{"name": "before"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	id := strings.TrimPrefix(res.Header.Get("Location"), "/devices/")
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"PUT", "/devices/" + id, `{"name": "after"}`},
+		{"POST", "/devices/" + id + "/plug", `["evC1"]`},
+		// Plugging again changes nothing
+		{"POST", "/devices/" + id + "/plug", `["evC1"]`},
+		{"POST", "/devices/" + id + "/unplug", `["evC1"]`},
+		{"DELETE", "/devices/" + id, ""},
+	}
+	for i, r := range requests {
+		req, _ := http.NewRequest(r.method, ts.URL+r.path, strings.NewReader(r.body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+	}
+
+	evs, subjects := outboxEvents(t, id)
+
+	expected := []string{"created", "updated", "plugged", "unplugged", "deleted"}
+	if len(evs) != len(expected) {
+		t.Fatalf("expected %d events got %d", len(expected), len(evs))
+	}
+
+	for i, e := range evs {
+		if e.Type != expected[i] || e.Resource != events.ResourceDevice || e.Version != events.Version {
+			t.Errorf("event %d: unexpected event %+v", i+1, e)
+		}
+		if s := "mainflux.core.events.device." + expected[i]; subjects[i] != s {
+			t.Errorf("event %d: expected subject %s got %s", i+1, s, subjects[i])
+		}
+	}
+
+	var before, after models.Device
+	json.Unmarshal(evs[1].Before, &before)
+	json.Unmarshal(evs[1].After, &after)
+	if before.Name != "before" || after.Name != "after" || after.Revision != before.Revision+1 {
+		t.Errorf("expected update from %q to %q got %+v to %+v", "before", "after", before, after)
+	}
+
+	json.Unmarshal(evs[2].After, &after)
+	if len(after.Channels) != 1 || after.Channels[0] != "evC1" {
+		t.Errorf("expected device plugged into evC1 got %v", after.Channels)
+	}
+
+	if len(evs[0].Before) > 0 || len(evs[4].After) > 0 {
+		t.Errorf("expected no state before creation and after deletion")
+	}
+}
+
+func TestReconcileEvent(t *testing.T) {
+	devices.Save(models.Device{ID: "recD1", Revision: 2})
+
+	cases := []struct {
+		typ       string
+		id        string
+		before    interface{}
+		abandoned bool
+	}{
+		{events.Created, "recD1", nil, false},
+		{events.Created, "recD2", nil, true},
+		{events.Updated, "recD1", models.Device{ID: "recD1", Revision: 1}, false},
+		{events.Updated, "recD1", models.Device{ID: "recD1", Revision: 2}, true},
+		{events.Plugged, "recD2", models.Device{ID: "recD2", Revision: 1}, true},
+		{events.Deleted, "recD1", models.Device{ID: "recD1", Revision: 2}, true},
+		{events.Deleted, "recD2", models.Device{ID: "recD2", Revision: 1}, false},
+	}
+
+	for i, c := range cases {
+		e, _ := events.New(c.typ, events.ResourceDevice, c.id, c.before, nil)
+		b, _ := json.Marshal(e)
+
+		rec, err := api.ReconcileEvent(models.OutboxRecord{ID: e.ID, Data: b, Staged: true})
+		if c.abandoned {
+			if err == nil {
+				t.Errorf("case %d: expected event to be abandoned", i+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		var got events.Event
+		json.Unmarshal(rec.Data, &got)
+		if s := "mainflux.core.events.device." + c.typ; rec.Subject != s || rec.ID != e.ID || got.ID != e.ID {
+			t.Errorf("case %d: expected event %s on %s got %+v", i+1, e.ID, s, rec)
+		}
+		if exists := c.typ != events.Deleted; exists != (len(got.After) > 0) {
+			t.Errorf("case %d: expected state after the change %v got %s", i+1, exists, got.After)
+		}
+	}
+}
+
+func TestAbortedEvent(t *testing.T) {
+	devices.Save(models.Device{ID: "abD1", Revision: 1})
+
+	req, _ := http.NewRequest("POST", ts.URL+"/devices/abD1/plug", strings.NewReader(`["abC1"]`))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d got %d", http.StatusNotFound, res.StatusCode)
+	}
+	if evs, _ := outboxEvents(t, "abD1"); len(evs) != 0 {
+		t.Errorf("expected no events got %+v", evs)
+	}
+}
+
+func TestPeerEvents(t *testing.T) {
+	devices.Save(models.Device{ID: "peerD1", Revision: 1})
+	channels.Save(models.Channel{ID: "peerC1", Revision: 1})
+	channels.Save(models.Channel{ID: "peerC2", Revision: 1})
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		events map[string][]string
+	}{
+		{"POST", "/devices/peerD1/plug", `["peerC1", "peerC2"]`, map[string][]string{
+			"peerD1": {"plugged"}, "peerC1": {"updated"}, "peerC2": {"updated"}}},
+		// Channels plugged already are not changed
+		{"POST", "/devices/peerD1/plug", `["peerC1", "peerC2"]`, map[string][]string{}},
+		{"POST", "/channels/peerC1/unplug", `["peerD1"]`, map[string][]string{
+			"peerC1": {"unplugged"}, "peerD1": {"updated"}}},
+		{"POST", "/devices/peerD1/keys", "", map[string][]string{
+			"peerD1": {"updated"}}},
+		{"DELETE", "/channels/peerC2", "", map[string][]string{
+			"peerC2": {"deleted"}, "peerD1": {"updated"}}},
+	}
+
+	ids := []string{"peerD1", "peerC1", "peerC2"}
+	for i, c := range cases {
+		outbox.Remove(allSeqs(t)...)
+
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		for _, id := range ids {
+			evs, _ := outboxEvents(t, id)
+			var types []string
+			for _, e := range evs {
+				types = append(types, e.Type)
+			}
+			if strings.Join(types, ",") != strings.Join(c.events[id], ",") {
+				t.Errorf("case %d: expected %v events about %s got %v", i+1, c.events[id], id, types)
+			}
+		}
+	}
+}
+
+func TestRejectedPlug(t *testing.T) {
+	_, disable := enableAuth(t)
+	defer disable()
+
+	alice := "Bearer " + hs256("alice")
+	carol := "Bearer " + hs256("carol")
+
+	devices.Save(models.Device{ID: "rejD1", Revision: 1})
+	channels.Save(models.Channel{ID: "rejC1", Owner: "alice", Visibility: "public", Revision: 1})
+
+	cases := []struct {
+		path  string
+		token string
+		body  string
+		code  int
+	}{
+		{"/channels/rejC2/plug", alice, `["rejD1"]`, http.StatusNotFound},
+		{"/channels/rejC2/unplug", alice, `["rejD1"]`, http.StatusNotFound},
+		{"/devices/rejD2/plug", alice, `["rejC1"]`, http.StatusNotFound},
+		{"/devices/rejD2/unplug", alice, `["rejC1"]`, http.StatusNotFound},
+		{"/channels/rejC1/plug", carol, `["rejD1"]`, http.StatusForbidden},
+		{"/channels/rejC1/unplug", carol, `["rejD1"]`, http.StatusForbidden},
+	}
+
+	for i, c := range cases {
+		outbox.Remove(allSeqs(t)...)
+
+		req, _ := http.NewRequest("POST", ts.URL+c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", c.token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+		// Nothing is staged for the rejected requests
+		if seqs := allSeqs(t); len(seqs) != 0 {
+			t.Errorf("case %d: expected empty outbox got %d records", i+1, len(seqs))
+		}
+	}
+}
+
+// allSeqs returns sequence numbers of all the records in the outbox.
+func allSeqs(t *testing.T) []int64 {
+	records, err := outbox.Pending(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := make([]int64, len(records))
+	for i, r := range records {
+		seqs[i] = r.Seq
+	}
+
+	return seqs
+}
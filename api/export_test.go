@@ -0,0 +1,36 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"time"
+
+	"github.com/mainflux/mainflux-core/ingest"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// MsgHandler exports the NATS message handler to the tests.
+var MsgHandler = msgHandler
+
+// StartIngester starts the ingestion workers of the NATS message handler
+// on the repositories, caching the lookups for the ttl. Returned function
+// restores the repositories of the HTTP server.
+func StartIngester(cfg ingest.Config, ttl time.Duration, dr models.DeviceRepository,
+	cr models.ChannelRepository, mr models.MessageRepository, or models.OutboxRepository) (*ingest.Pool, func()) {
+	pdr, pcr, pmr, por := deviceRepo, channelRepo, messageRepo, outboxRepo
+	deviceRepo, channelRepo, messageRepo, outboxRepo = dr, cr, mr, or
+
+	lookups = newLookupCache(ttl)
+	ingester = ingest.NewPool(cfg, saveRecords, republished.flush)
+
+	return ingester, func() {
+		deviceRepo, channelRepo, messageRepo, outboxRepo = pdr, pcr, pmr, por
+		lookups = newLookupCache(defaultLookupTTL)
+	}
+}
@@ -0,0 +1,104 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// listParams are non-filter parameters accepted by the listings.
+var listParams = map[string]bool{
+	"cursor": true,
+	"limit":  true,
+	"sort":   true,
+	"fields": true,
+	"climit": true,
+}
+
+// parseFilter extracts filter parameters from the request:
+// - name = exact name
+// - name~ = case-insensitive name substring, i.e. `name~=sensor`
+// - tag = attached tag, can be repeated to require several tags
+// - online = device connection state (devices only)
+// - metadata.<path> = metadata value, i.e. `metadata.site=plant-3`
+// - created_after, created_before, updated_after, updated_before = RFC3339 time
+// Unknown parameters are rejected, so that typos do not silently
+// turn into full listings.
+func parseFilter(r *http.Request, devices bool) (models.Filter, error) {
+	f := models.Filter{}
+
+	for k, v := range r.URL.Query() {
+		if len(v) == 0 || listParams[k] {
+			continue
+		}
+
+		switch {
+		case k == "name":
+			f.Name = v[0]
+		case k == "name~":
+			f.NameContains = v[0]
+		case k == "tag":
+			f.Tags = v
+		case k == "online" && devices:
+			online, err := strconv.ParseBool(v[0])
+			if err != nil {
+				return f, errBadRequest("online must be true or false")
+			}
+			f.Online = &online
+		case strings.HasPrefix(k, "metadata."):
+			path := strings.TrimPrefix(k, "metadata.")
+			if !validMetadataPath(path) {
+				return f, errBadRequest("invalid metadata path " + path)
+			}
+			if f.Metadata == nil {
+				f.Metadata = map[string]string{}
+			}
+			f.Metadata[path] = v[0]
+		case k == "created_after", k == "created_before",
+			k == "updated_after", k == "updated_before":
+			t, err := time.Parse(time.RFC3339, v[0])
+			if err != nil {
+				return f, errBadRequest(k + " must be RFC3339 time")
+			}
+			ts := t.UTC().Format(time.RFC3339)
+			switch k {
+			case "created_after":
+				f.CreatedAfter = ts
+			case "created_before":
+				f.CreatedBefore = ts
+			case "updated_after":
+				f.UpdatedAfter = ts
+			case "updated_before":
+				f.UpdatedBefore = ts
+			}
+		default:
+			return f, errBadRequest("unknown parameter " + k)
+		}
+	}
+
+	return f, nil
+}
+
+// validMetadataPath function
+// Path is queried as MongoDB field path, so its segments must not be
+// empty, nor carry operators.
+func validMetadataPath(path string) bool {
+	for _, s := range strings.Split(path, ".") {
+		if len(s) == 0 || strings.ContainsAny(s, "$\x00") {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,149 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health statuses
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+// healthTimeout bounds the time a single dependency check may take.
+const healthTimeout = 2 * time.Second
+
+var (
+	// Version is the build version reported by health checks.
+	Version string
+
+	healthMu     sync.RWMutex
+	healthChecks = make(map[string]func() error)
+
+	errHealthTimeout = errors.New("timed out")
+)
+
+type (
+	// dependencyHealth is the outcome of a single dependency check.
+	dependencyHealth struct {
+		Status    string  `json:"status"`
+		LatencyMs float64 `json:"latency_ms"`
+		Error     string  `json:"error,omitempty"`
+	}
+
+	// healthResponse is the body of health check responses.
+	healthResponse struct {
+		Status  string                      `json:"status"`
+		Version string                      `json:"version"`
+		Checks  map[string]dependencyHealth `json:"checks,omitempty"`
+	}
+)
+
+// RegisterHealthCheck function
+// Adds a dependency probed by the readiness check. The check
+// reports that the dependency is unavailable by returning an error.
+func RegisterHealthCheck(name string, check func() error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	healthChecks[name] = check
+}
+
+// getLiveness function
+// Reports that the process is up and serving requests.
+func getLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: healthOK, Version: Version})
+}
+
+// getReadiness function
+// Probes all the registered dependencies concurrently, and responds
+// with 503 if any of them is unavailable.
+func getReadiness(w http.ResponseWriter, r *http.Request) {
+	healthMu.RLock()
+	names := make([]string, 0, len(healthChecks))
+	for name := range healthChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]dependencyHealth, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check func() error) {
+			defer wg.Done()
+			results[i] = probe(check)
+		}(i, healthChecks[name])
+	}
+	healthMu.RUnlock()
+	wg.Wait()
+
+	res := healthResponse{
+		Status:  healthOK,
+		Version: Version,
+		Checks:  make(map[string]dependencyHealth, len(names)),
+	}
+	for i, name := range names {
+		res.Checks[name] = results[i]
+		if results[i].Status != healthOK {
+			res.Status = healthUnavailable
+		}
+	}
+
+	status := http.StatusOK
+	if res.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, res)
+}
+
+// probe runs the check with healthTimeout. A check that does not
+// return in time is left to finish in the background.
+func probe(check func() error) dependencyHealth {
+	done := make(chan error, 1)
+	start := time.Now()
+
+	go func() {
+		done <- check()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(healthTimeout):
+		err = errHealthTimeout
+	}
+
+	h := dependencyHealth{
+		Status:    healthOK,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		h.Status, h.Error = healthUnavailable, err.Error()
+	}
+
+	return h
+}
+
+// natsHealth checks that NATS connection is up, with a round trip
+// to the server.
+func natsHealth() error {
+	if NatsConn == nil || !NatsConn.IsConnected() {
+		return errors.New("not connected")
+	}
+
+	return NatsConn.FlushTimeout(healthTimeout)
+}
@@ -0,0 +1,82 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/api"
+)
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Checks  map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func TestHealth(t *testing.T) {
+	var mongoErr error
+	api.Version = "test"
+	api.RegisterHealthCheck("mongo", func() error { return mongoErr })
+
+	cases := []struct {
+		path   string
+		err    error
+		code   int
+		status string
+		mongo  string
+	}{
+		{"/health/live", nil, http.StatusOK, "ok", ""},
+		{"/health/ready", nil, http.StatusOK, "ok", "ok"},
+		{"/health/live", errors.New("down"), http.StatusOK, "ok", ""},
+		{"/health/ready", errors.New("down"), http.StatusServiceUnavailable, "unavailable", "unavailable"},
+	}
+
+	for i, c := range cases {
+		mongoErr = c.err
+
+		res, err := http.Get(ts.URL + c.path)
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+
+		var body healthResponse
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+		}
+
+		if body.Status != c.status {
+			t.Errorf("case %d: expected health %s, got %s", i+1, c.status, body.Status)
+		}
+
+		if body.Version != "test" {
+			t.Errorf("case %d: expected version test, got %s", i+1, body.Version)
+		}
+
+		if body.Checks["mongo"].Status != c.mongo {
+			t.Errorf("case %d: expected mongo health %s, got %s", i+1, c.mongo, body.Checks["mongo"].Status)
+		}
+
+		if c.err != nil && len(c.mongo) > 0 && body.Checks["mongo"].Error != c.err.Error() {
+			t.Errorf("case %d: expected mongo error %s, got %s", i+1, c.err, body.Checks["mongo"].Error)
+		}
+	}
+}
@@ -9,69 +9,55 @@
 package api_test
 
 import (
-	"fmt"
-	"log"
 	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/mainflux/mainflux-core/api"
-	mfdb "github.com/mainflux/mainflux-core/db"
-
-	"gopkg.in/mgo.v2"
-	"gopkg.in/ory-am/dockertest.v3"
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/memory"
+	"github.com/mainflux/mainflux-core/models"
 )
 
-var ts *httptest.Server
-
-func TestMain(m *testing.M) {
-	var (
-		db  *mgo.Session
-		err error
-	)
-
-	// uses a sensible default on windows (tcp/http) and linux/osx (socket)
-	pool, err := dockertest.NewPool("")
-	if err != nil {
-		log.Fatalf("Could not connect to docker: %s", err)
-	}
-
-	// pulls an image, creates a container based on it and runs it
-	resource, err := pool.Run("mongo", "3.4", nil)
-	if err != nil {
-		log.Fatalf("Could not start resource: %s", err)
-	}
+var (
+	ts *httptest.Server
 
-	// exponential backoff-retry, because the application in the container might not be ready to accept connections yet
-	if err := pool.Retry(func() error {
-		var err error
-		db, err = mgo.Dial(fmt.Sprintf("localhost:%s", resource.GetPort("27017/tcp")))
-		if err != nil {
-			return err
-		}
+	devices  models.DeviceRepository
+	channels models.ChannelRepository
+	messages models.MessageRepository
+	tenants  models.TenantRepository
+	apiKeys  models.APIKeyRepository
+	outbox   models.OutboxRepository
+)
 
-		mfdb.SetMainSession(db)
-		mfdb.SetMainDb("mainflux_test")
+func TestMain(m *testing.M) {
+	// Keep the test output readable
+	logging.Configure(logging.Config{Level: logging.ErrorLevel})
 
-		return db.Ping()
-	}); err != nil {
-		log.Fatalf("Could not connect to docker: %s", err)
-	}
+	// In-memory repositories let the suite run without MongoDB
+	devices = memory.NewDeviceRepository()
+	channels = memory.NewChannelRepository()
+	messages = memory.NewMessageRepository()
+	tenants = memory.NewTenantRepository()
+	apiKeys = memory.NewAPIKeyRepository()
+	outbox = memory.NewOutboxRepository()
 
 	// Start the HTTP server
-	ts = httptest.NewServer(api.HTTPServer())
-	defer ts.Close()
+	ts = httptest.NewServer(api.HTTPServer(devices, channels, messages, tenants, apiKeys, outbox))
 
 	code := m.Run()
 
-	// Close database connection.
-	db.Close()
-
 	// You can't defer this because os.Exit doesn't care for defer
-	if err := pool.Purge(resource); err != nil {
-		log.Fatalf("Could not purge resource: %s", err)
-	}
+	ts.Close()
 
 	// Exit tests
 	os.Exit(code)
 }
+
+// listResponse mirrors the envelope of device and channel listings.
+type listResponse struct {
+	Total int                      `json:"total"`
+	Limit int                      `json:"limit"`
+	Next  string                   `json:"next"`
+	Items []map[string]interface{} `json:"items"`
+}
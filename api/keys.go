@@ -0,0 +1,213 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/events"
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/go-zoo/bone"
+)
+
+// clientKeyHeader carries key of the device named by Client-ID.
+const clientKeyHeader = "Client-Key"
+
+// deviceKeyGrace is how long the keys replaced by rotation remain
+// valid, unless the `grace` parameter says otherwise.
+var deviceKeyGrace = 24 * time.Hour
+
+// deviceKeyResponse carries the generated key, which is shown only once.
+type deviceKeyResponse struct {
+	ID    string `json:"id"`
+	KeyID string `json:"key_id"`
+	Key   string `json:"key"`
+}
+
+func errDeviceKey() *apiError {
+	return &apiError{Code: codeUnauthorized, Message: "invalid device credentials"}
+}
+
+// withDeviceKey middleware identifies the publishing device by the
+// Client-ID and Client-Key headers. Requests with invalid keys are
+// rejected, and devices identified by client certificate are left be.
+func withDeviceKey(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	value := r.Header.Get(clientKeyHeader)
+	if len(value) == 0 || !isPublish(r) || len(authDevice(r)) > 0 {
+		next(w, r)
+		return
+	}
+
+	id := r.Header.Get(clientIDHeader)
+	d, err := deviceRepo.One(id)
+	if err != nil && err != models.ErrNotFound {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil || !auth.ValidDeviceKey(d, value, time.Now()) {
+		writeError(w, r, errDeviceKey())
+		return
+	}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), authDeviceKey, id)))
+}
+
+// rotateKeys function
+// Expires the keys in grace period from now, unless they expire
+// sooner, and drops the keys already expired.
+func rotateKeys(keys []models.DeviceKey, now time.Time, grace time.Duration) []models.DeviceKey {
+	expires := now.Add(grace)
+
+	rotated := []models.DeviceKey{}
+	for _, k := range keys {
+		if auth.Expired(k, now) {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, k.Expires); err != nil || t.After(expires) {
+			k.Expires = expires.UTC().Format(time.RFC3339)
+		}
+		rotated = append(rotated, k)
+	}
+
+	return rotated
+}
+
+// getDeviceKeys function
+func getDeviceKeys(w http.ResponseWriter, r *http.Request) {
+	id := bone.GetValue(r, "device_id")
+
+	d, err := oneDevice(r, id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	keys := d.Keys
+	if keys == nil {
+		keys = []models.DeviceKey{}
+	}
+
+	w.Header().Set("ETag", etag(d.Revision))
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// rotateDeviceKey function
+// Generates a new device key. The keys it replaces remain valid for
+// `grace` seconds, so that the device can switch to the new key.
+func rotateDeviceKey(w http.ResponseWriter, r *http.Request) {
+	id := bone.GetValue(r, "device_id")
+
+	grace := deviceKeyGrace
+	if s := r.URL.Query().Get("grace"); len(s) > 0 {
+		secs, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			writeError(w, r, errBadRequest("wrong grace format"))
+			return
+		}
+		grace = time.Duration(secs) * time.Second
+	}
+
+	d, err := oneDevice(r, id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	if status := checkPreconditions(r, d.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
+		return
+	}
+
+	k, value, err := auth.NewDeviceKey()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := d
+	d.Keys = append(rotateKeys(d.Keys, time.Now(), grace), k)
+
+	if err := updateKeys(r, before, d); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/devices/%s/keys/%s", id, k.ID))
+	w.Header().Set("ETag", etag(d.Revision+1))
+	writeJSON(w, http.StatusCreated, deviceKeyResponse{ID: id, KeyID: k.ID, Key: value})
+}
+
+// revokeDeviceKey function
+// Invalidates the device key immediately.
+func revokeDeviceKey(w http.ResponseWriter, r *http.Request) {
+	id := bone.GetValue(r, "device_id")
+	kid := bone.GetValue(r, "key_id")
+
+	d, err := oneDevice(r, id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	if status := checkPreconditions(r, d.Revision); status != 0 {
+		writeError(w, r, errPreconditionFailed(id))
+		return
+	}
+
+	before := d
+	keys := []models.DeviceKey{}
+	for _, k := range d.Keys {
+		if k.ID != kid {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == len(d.Keys) {
+		writeError(w, r, errNotFound(kid))
+		return
+	}
+	d.Keys = keys
+
+	if err := updateKeys(r, before, d); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(d.Revision+1))
+	writeJSON(w, http.StatusOK, response{"revoked", kid})
+}
+
+// updateKeys stores the device with modified keys, and emits event
+// about the update.
+func updateKeys(r *http.Request, before, d models.Device) error {
+	d.Updated = time.Now().UTC().Format(time.RFC3339)
+
+	ch, err := stage(r, events.Updated, events.ResourceDevice, d.ID, d.Tenant, before)
+	if err != nil {
+		return err
+	}
+
+	err = deviceRepo.Update(d)
+	switch err {
+	case nil:
+		d.Revision++
+		ch.commit(d)
+		return nil
+	case models.ErrConflict:
+		ch.abort()
+		return errModified(r, d.ID)
+	}
+
+	ch.abort()
+	return notFound(err, d.ID)
+}
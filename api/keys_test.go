@@ -0,0 +1,273 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type keyResponse struct {
+	ID    string `json:"id"`
+	KeyID string `json:"key_id"`
+	Key   string `json:"key"`
+}
+
+// postKey creates device, or rotates its key, and returns the new key.
+func postKey(t *testing.T, path string) keyResponse {
+	res, err := http.Post(ts.URL+path, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d got %d", http.StatusCreated, res.StatusCode)
+	}
+
+	var k keyResponse
+	if err := json.NewDecoder(res.Body).Decode(&k); err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// plug plugs the device into the channel.
+func plug(t *testing.T, device, channel string) {
+	res, err := http.Post(ts.URL+"/devices/"+device+"/plug", "application/json",
+		strings.NewReader(`["`+channel+`"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestDeviceKeys(t *testing.T) {
+	channels.Save(models.Channel{ID: "keyC1", Revision: 1})
+
+	first := postKey(t, "/devices")
+	id := first.ID
+	plug(t, id, "keyC1")
+	unplugged := postKey(t, "/devices")
+	second := postKey(t, "/devices/"+id+"/keys")
+	third := postKey(t, "/devices/"+id+"/keys?grace=0")
+
+	// The hashes are never exposed
+	res, err := http.Get(ts.URL + "/devices/" + id + "/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&keys)
+	res.Body.Close()
+	if len(keys) != 3 {
+		t.Errorf("expected 3 keys got %d", len(keys))
+	}
+	for _, k := range keys {
+		if _, ok := k["hash"]; ok {
+			t.Errorf("expected key hash to be hidden")
+		}
+	}
+
+	cases := []struct {
+		clientID string
+		key      string
+		code     int
+	}{
+		{id, third.Key, http.StatusAccepted},
+		// Rotation with no grace expires the older keys at once
+		{id, second.Key, http.StatusUnauthorized},
+		{id, first.Key, http.StatusUnauthorized},
+		{id, "mfd_unknown", http.StatusUnauthorized},
+		{"unknown", third.Key, http.StatusUnauthorized},
+		{id, "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+		// Only devices plugged into the channel publish on it
+		{unplugged.ID, unplugged.Key, http.StatusForbidden},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", ts.URL+"/channels/keyC1/msg",
+			strings.NewReader(`[{"n":"key","v":1}]`))
+		if len(c.clientID) > 0 {
+			req.Header.Set("Client-ID", c.clientID)
+		}
+		if len(c.key) > 0 {
+			req.Header.Set("Client-Key", c.key)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+
+	msgs, _ := messages.ByChannel("keyC1", 0, math.MaxFloat64)
+	publishers := []string{}
+	for _, m := range msgs {
+		publishers = append(publishers, m.Publisher)
+	}
+	if strings.Join(publishers, ",") != id {
+		t.Errorf("expected publishers [%s] got %v", id, publishers)
+	}
+}
+
+func TestRotateDeviceKey(t *testing.T) {
+	channels.Save(models.Channel{ID: "keyC2", Revision: 1})
+
+	old := postKey(t, "/devices")
+	id := old.ID
+	plug(t, id, "keyC2")
+	current := postKey(t, "/devices/"+id+"/keys")
+
+	cases := []struct {
+		method string
+		path   string
+		key    string
+		code   int
+	}{
+		// Replaced keys remain valid in grace period
+		{"POST", "/channels/keyC2/msg", old.Key, http.StatusAccepted},
+		{"POST", "/channels/keyC2/msg", current.Key, http.StatusAccepted},
+		{"POST", "/devices/" + id + "/keys?grace=-1", "", http.StatusBadRequest},
+		{"DELETE", "/devices/" + id + "/keys/" + old.KeyID, "", http.StatusOK},
+		{"DELETE", "/devices/" + id + "/keys/" + old.KeyID, "", http.StatusNotFound},
+		{"DELETE", "/devices/unknown/keys/" + current.KeyID, "", http.StatusNotFound},
+		{"POST", "/channels/keyC2/msg", old.Key, http.StatusUnauthorized},
+		{"POST", "/channels/keyC2/msg", current.Key, http.StatusAccepted},
+		// Keys are maintained by the core
+		{"PUT", "/devices/" + id, `{"keys": []}`, http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		body := ""
+		if c.method == "PUT" {
+			body = c.key
+		} else if c.method == "POST" && len(c.key) > 0 {
+			body = `[{"n":"rotate","v":1}]`
+		}
+
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(body))
+		if c.method == "POST" && len(c.key) > 0 {
+			req.Header.Set("Client-ID", id)
+			req.Header.Set("Client-Key", c.key)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func TestIdempotentPublish(t *testing.T) {
+	channels.Save(models.Channel{ID: "idemC1", Revision: 1})
+
+	d := postKey(t, "/devices")
+	plug(t, d.ID, "idemC1")
+
+	cases := []struct {
+		key  string
+		body string
+	}{
+		{"m1", `[{"n":"idem","v":1},{"n":"idem","v":2}]`},
+		// Retried request is accepted, but written once
+		{"m1", `[{"n":"idem","v":1},{"n":"idem","v":2}]`},
+		{"m2", `[{"n":"idem","v":3}]`},
+		// Messages without key are always written
+		{"", `[{"n":"idem","v":4}]`},
+		{"", `[{"n":"idem","v":4}]`},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("POST", ts.URL+"/channels/idemC1/msg", strings.NewReader(c.body))
+		req.Header.Set("Client-ID", d.ID)
+		req.Header.Set("Client-Key", d.Key)
+		if len(c.key) > 0 {
+			req.Header.Set("Idempotency-Key", c.key)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusAccepted {
+			t.Errorf("case %d: expected status %d got %d", i+1, http.StatusAccepted, res.StatusCode)
+		}
+	}
+
+	msgs, _ := messages.ByChannel("idemC1", 0, math.MaxFloat64)
+	if len(msgs) != 5 {
+		t.Errorf("expected 5 messages got %d", len(msgs))
+	}
+}
+
+func TestDeviceKeyAfterUpdate(t *testing.T) {
+	channels.Save(models.Channel{ID: "keyC3", Revision: 1})
+
+	d := postKey(t, "/devices")
+	plug(t, d.ID, "keyC3")
+
+	cases := []struct {
+		method      string
+		contentType string
+		body        string
+	}{
+		{"PUT", "application/json", `{"name": "renamed"}`},
+		{"PATCH", "application/merge-patch+json", `{"description": "patched"}`},
+		{"PATCH", "application/json-patch+json", `[{"op": "add", "path": "/tags", "value": ["t"]}]`},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+"/devices/"+d.ID, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("case %d: expected status %d got %d", i+1, http.StatusOK, res.StatusCode)
+			continue
+		}
+
+		// The key keeps authenticating the device
+		if code := publish(t, d, "keyC3", "updated"); code != http.StatusAccepted {
+			t.Errorf("case %d: expected status %d got %d", i+1, http.StatusAccepted, code)
+		}
+	}
+}
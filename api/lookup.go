@@ -0,0 +1,114 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+const (
+	// defaultLookupTTL is the time for which the channels and devices
+	// looked up by the NATS message handler are reused. Changes to
+	// them, e.g. unplugged devices or revoked keys, take effect on the
+	// received messages within it.
+	defaultLookupTTL = time.Second
+	// maxLookups bounds the number of cached lookups.
+	maxLookups = 10000
+)
+
+// lookups caches the channels and devices of the received messages,
+// so that most of the messages are authorized without a round trip to
+// the database.
+var lookups = newLookupCache(defaultLookupTTL)
+
+type (
+	lookupCache struct {
+		mu       sync.Mutex
+		ttl      time.Duration
+		channels map[string]cachedChannel
+		devices  map[string]cachedDevice
+	}
+
+	cachedChannel struct {
+		channel models.Channel
+		expires time.Time
+	}
+
+	cachedDevice struct {
+		device  models.Device
+		expires time.Time
+	}
+)
+
+func newLookupCache(ttl time.Duration) *lookupCache {
+	return &lookupCache{
+		ttl:      ttl,
+		channels: map[string]cachedChannel{},
+		devices:  map[string]cachedDevice{},
+	}
+}
+
+// channel function
+// Returns the channel, looked up within the ttl or by the repository.
+// Failed lookups are not cached.
+func (lc *lookupCache) channel(id string) (models.Channel, error) {
+	now := time.Now()
+
+	lc.mu.Lock()
+	cc, ok := lc.channels[id]
+	lc.mu.Unlock()
+	if ok && now.Before(cc.expires) {
+		return cc.channel, nil
+	}
+
+	c, err := channelRepo.One(id)
+	if err != nil || lc.ttl <= 0 {
+		return c, err
+	}
+
+	lc.mu.Lock()
+	if len(lc.channels) >= maxLookups {
+		lc.channels = map[string]cachedChannel{}
+	}
+	lc.channels[id] = cachedChannel{c, now.Add(lc.ttl)}
+	lc.mu.Unlock()
+
+	return c, nil
+}
+
+// device function
+// Returns the device, looked up within the ttl or by the repository.
+// Failed lookups are not cached.
+func (lc *lookupCache) device(id string) (models.Device, error) {
+	now := time.Now()
+
+	lc.mu.Lock()
+	cd, ok := lc.devices[id]
+	lc.mu.Unlock()
+	if ok && now.Before(cd.expires) {
+		return cd.device, nil
+	}
+
+	d, err := deviceRepo.One(id)
+	if err != nil || lc.ttl <= 0 {
+		return d, err
+	}
+
+	lc.mu.Lock()
+	if len(lc.devices) >= maxLookups {
+		lc.devices = map[string]cachedDevice{}
+	}
+	lc.devices[id] = cachedDevice{d, now.Add(lc.ttl)}
+	lc.mu.Unlock()
+
+	return d, nil
+}
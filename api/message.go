@@ -10,38 +10,77 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
 	"strconv"
 	"time"
 
-	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/logging"
 	"github.com/mainflux/mainflux-core/models"
-
-	"gopkg.in/mgo.v2/bson"
+	"github.com/mainflux/mainflux-core/wal"
 
 	"github.com/cisco/senml"
 
-	"io"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/go-zoo/bone"
 )
 
+// idempotencyKeyHeader carries ID of the published message, by which
+// retried requests are told apart from the new ones.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 // writeMessage function
 // Writtes message into DB.
 // Can be called via various protocols.
 func writeMessage(nm NatsMsg) error {
+	msgs, err := messageRecords(nm)
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+
+	// Messages wait for the buffered ones, so that they are written in order
+	if buffering() {
+		return buffered(nm, msgs)
+	}
+
+	// Insert messages in DB
+	if err := messageRepo.Save(msgs...); err != nil {
+		if err == models.ErrConflict {
+			logging.Debug("duplicate messages skipped", "channel", nm.Channel, "id", nm.ID)
+			return err
+		}
+		logging.Error("cannot write messages", "channel", nm.Channel, "err", err)
+		if currentBuffer() != nil {
+			return buffered(nm, msgs)
+		}
+		return err
+	}
+
+	senmlWritten.Add(float64(len(msgs)), nm.Protocol)
+	logging.Debug("messages written", "channel", nm.Channel,
+		"protocol", nm.Protocol, "records", len(msgs))
+	return nil
+}
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+// buffered function
+// Stores the message in the write-ahead log, and reports errBuffered
+// once it is stored.
+func buffered(nm NatsMsg, msgs []models.Message) error {
+	if err := bufferMessage(nm, msgs); err == wal.ErrFull {
+		return errUnavailable("message buffer is full")
+	} else if err != nil {
+		return err
+	}
 
+	return errBuffered
+}
+
+// messageRecords function
+// Decodes SenML payload of the message into the records to be written.
+func messageRecords(nm NatsMsg) ([]models.Message, error) {
 	var s senml.SenML
 	var err error
 	if s, err = senml.Decode(nm.Payload, senml.JSON); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Normalize (i.e. resolve) SenMLRecord
@@ -49,114 +88,121 @@ func writeMessage(nm NatsMsg) error {
 
 	// Timestamp
 	t := time.Now().UTC().Format(time.RFC3339)
-	for _, r := range sn.Records {
+	msgs := make([]models.Message, 0, len(sn.Records))
+	for i, r := range sn.Records {
 
 		m := models.Message{}
 
 		// Copy SenMLRecord struct to Message struct
 		b, err := json.Marshal(r)
 		if err != nil {
-			log.Print(err)
-			return err
+			return nil, err
 		}
 		if err := json.Unmarshal(b, &m); err != nil {
-			log.Print(err)
-			return err
+			return nil, err
 		}
 
 		// Fill-in Mainflux stuff
 		m.Channel = nm.Channel
+		m.Tenant = nm.Tenant
 		m.Publisher = nm.Publisher
 		m.Protocol = nm.Protocol
 		m.Timestamp = t
+		m.Key = messageKey(nm, i)
 
-		// Insert message in DB
-		if err := Db.C("messages").Insert(m); err != nil {
-			log.Print(err)
-			return err
-		}
+		msgs = append(msgs, m)
 	}
 
-	fmt.Println("Msg written")
-	return nil
+	return msgs, nil
 }
 
-// sendMessage function
-func sendMessage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+// messageKey function
+// Returns idempotency key of the i-th record of the message, unique per
+// channel and publisher, or empty key for messages without ID.
+func messageKey(nm NatsMsg, i int) string {
+	if len(nm.ID) == 0 {
+		return ""
+	}
 
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
+	return nm.Channel + "/" + nm.Publisher + "/" + nm.ID + "/" + strconv.Itoa(i)
+}
 
-	data, err := ioutil.ReadAll(r.Body)
+// sendMessage function
+func sendMessage(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
 	if err != nil {
-		panic(err)
+		writeError(w, r, err)
+		return
 	}
 
 	if len(data) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "no data provided"}`
-		io.WriteString(w, str)
+		writeError(w, r, errBadRequest("no data provided"))
 		return
 	}
 
 	cid := bone.GetValue(r, "channel_id")
 
 	// check if channel exist
-	if err = Db.C("channels").Find(bson.M{"id": cid}).One(nil); err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	c, err := oneChannel(r, cid)
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
 		return
 	}
 
-	// Publisher ID header
-	hdr := r.Header.Get("Client-ID")
+	// Device identified by client certificate or device key,
+	// which must be plugged into the channel
+	hdr, err := publisher(r)
+	if err == nil {
+		err = canPublish(c, hdr)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	// Publish message on MQTT via NATS
 	m := NatsMsg{}
 	m.Channel = cid
+	m.Tenant = c.Tenant
 	m.Publisher = hdr
 	m.Protocol = "http"
 	m.Payload = data
-
-	b, err := json.Marshal(m)
-	if err != nil {
-		log.Print(err)
+	m.ID = r.Header.Get(idempotencyKeyHeader)
+
+	// Write the message in DB. Retried requests were already
+	// written and published, and buffered messages are published
+	// once written.
+	switch err := writeMessage(m); err {
+	case nil:
+	case models.ErrConflict, errBuffered:
+		writeJSON(w, http.StatusAccepted, response{Response: "message sent"})
+		return
+	default:
+		writeError(w, r, err)
+		return
 	}
-	NatsConn.Publish("mainflux/core/out", b)
 
-	// Write the message in DB
-	if err := writeMessage(m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		str := `{"response": "` + err.Error() + `"}`
-		io.WriteString(w, str)
+	// Publish message on MQTT via NATS
+	if err := republish(m); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Send back response to HTTP client
 	// We have accepted the request and published it over MQTT,
 	// but we do not know if it will be executed or not (MQTT is not req-reply protocol)
-	w.WriteHeader(http.StatusAccepted)
-	str := `{"response": "message sent"}`
-	io.WriteString(w, str)
+	writeJSON(w, http.StatusAccepted, response{Response: "message sent"})
 }
 
 // getMessage function
 func getMessage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-
-	Db := db.MgoDb{}
-	Db.Init()
-	defer Db.Close()
-
 	cid := bone.GetValue(r, "channel_id")
 
-	if err := Db.C("channels").Find(bson.M{"id": cid}).One(nil); err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	c, err := oneChannel(r, cid)
+	if err == nil {
+		err = authorize(r, c, accessRead)
+	}
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
 		return
 	}
 
@@ -165,7 +211,6 @@ func getMessage(w http.ResponseWriter, r *http.Request) {
 	// - end_time = messages to this moment. UNIX time format.
 	var st float64
 	var et float64
-	var err error
 	var s string
 	s = r.URL.Query().Get("start_time")
 	if len(s) == 0 {
@@ -173,9 +218,7 @@ func getMessage(w http.ResponseWriter, r *http.Request) {
 	} else {
 		st, err = strconv.ParseFloat(s, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			str := `{"response": "wrong start_time format"}`
-			io.WriteString(w, str)
+			writeError(w, r, errBadRequest("wrong start_time format"))
 			return
 		}
 	}
@@ -185,27 +228,16 @@ func getMessage(w http.ResponseWriter, r *http.Request) {
 	} else {
 		et, err = strconv.ParseFloat(s, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			str := `{"response": "wrong end_time format"}`
-			io.WriteString(w, str)
+			writeError(w, r, errBadRequest("wrong end_time format"))
 			return
 		}
 	}
 
-	results := []models.Message{}
-	if err := Db.C("messages").Find(bson.M{"channel": cid, "time": bson.M{"$gt": st, "$lt": et}}).
-		All(&results); err != nil {
-		log.Print(err)
-		w.WriteHeader(http.StatusNotFound)
-		str := `{"response": "not found", "id": "` + cid + `"}`
-		io.WriteString(w, str)
+	results, err := messageRepo.ByChannel(cid, st, et)
+	if err != nil {
+		writeError(w, r, notFound(err, cid))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	res, err := json.Marshal(results)
-	if err != nil {
-		log.Print(err)
-	}
-	io.WriteString(w, string(res))
+	writeJSON(w, http.StatusOK, results)
 }
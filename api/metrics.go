@@ -0,0 +1,90 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mainflux/mainflux-core/metrics"
+
+	"github.com/codegangsta/negroni"
+	"github.com/go-zoo/bone"
+)
+
+// NATS message processing stages, used as `stage` label values
+const (
+	stageDecode  = "decode"
+	stageChannel = "channel"
+	stageAuth    = "auth"
+	stageWrite   = "write"
+	stageOutbox  = "outbox"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("mainflux_http_requests_total",
+		"Number of HTTP requests.", "method", "route", "code")
+	httpDuration = metrics.NewHistogramVec("mainflux_http_request_duration_seconds",
+		"HTTP request latency in seconds.", nil, "method", "route")
+
+	natsReceived = metrics.NewCounterVec("mainflux_nats_messages_received_total",
+		"Number of messages received over NATS.")
+	natsDecoded = metrics.NewCounterVec("mainflux_nats_messages_decoded_total",
+		"Number of NATS messages successfully decoded.")
+	natsFailed = metrics.NewCounterVec("mainflux_nats_messages_failed_total",
+		"Number of NATS messages that failed processing, by stage.", "stage")
+	natsDuplicates = metrics.NewCounterVec("mainflux_nats_messages_duplicate_total",
+		"Number of redelivered NATS messages skipped as already written.")
+	natsSlowConsumer = metrics.NewCounterVec("mainflux_nats_slow_consumer_total",
+		"Number of times NATS reported slow consumer, i.e. started dropping messages.")
+	_ = metrics.NewGaugeFunc("mainflux_nats_messages_dropped",
+		"Number of messages dropped by NATS, as the ingestion fell behind.", natsDropped)
+	authzRequests = metrics.NewCounterVec("mainflux_authz_requests_total",
+		"Number of device authorization requests, by decision.", "allowed")
+
+	senmlWritten = metrics.NewCounterVec("mainflux_senml_records_written_total",
+		"Number of SenML records written, by protocol.", "protocol")
+	messagesBuffered = metrics.NewCounterVec("mainflux_messages_buffered_total",
+		"Number of messages stored in the write-ahead log, by protocol.", "protocol")
+)
+
+// instrument middleware counts requests and measures their latency
+// per route pattern, so that IDs do not blow up the label values.
+func instrument(mux *bone.Mux) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		start := time.Now()
+		route := mux.GetRequestRoute(r)
+
+		next(w, r)
+
+		status := http.StatusOK
+		if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+			status = rw.Status()
+		}
+
+		httpRequests.Inc(r.Method, route, strconv.Itoa(status))
+		httpDuration.ObserveSince(start, r.Method, route)
+	}
+}
+
+// natsDropped returns the number of messages NATS dropped from the
+// ingestion subscription.
+func natsDropped() float64 {
+	if natsSub == nil {
+		return 0
+	}
+
+	n, err := natsSub.Dropped()
+	if err != nil {
+		return 0
+	}
+
+	return float64(n)
+}
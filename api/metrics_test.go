@@ -0,0 +1,61 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	// Generate some traffic to be reported
+	for _, path := range []string{"/devices/metricsID", "/status", "/unknown"} {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		res.Body.Close()
+	}
+
+	res, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	cases := []string{
+		"# TYPE mainflux_http_requests_total counter",
+		`mainflux_http_requests_total{method="GET",route="/devices/:device_id",code="404"}`,
+		`mainflux_http_requests_total{method="GET",route="/status",code="200"}`,
+		`mainflux_http_requests_total{method="GET",route="NotFound",code="404"}`,
+		"# TYPE mainflux_http_request_duration_seconds histogram",
+		`mainflux_http_request_duration_seconds_bucket{method="GET",route="/status",le="+Inf"}`,
+		`mainflux_http_request_duration_seconds_count{method="GET",route="/status"}`,
+		"# TYPE mainflux_nats_messages_received_total counter",
+		"# TYPE mainflux_senml_records_written_total counter",
+		"# TYPE go_goroutines gauge",
+	}
+
+	for i, c := range cases {
+		if !strings.Contains(string(body), c) {
+			t.Errorf("case %d: expected metrics to contain %s", i+1, c)
+		}
+	}
+}
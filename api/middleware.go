@@ -0,0 +1,88 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/mainflux/mainflux-core/logging"
+
+	"github.com/codegangsta/negroni"
+	"github.com/satori/go.uuid"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	authDeviceKey
+	principalKey
+	tenantKey
+)
+
+// requestIDHeader carries request ID, either provided by the client
+// (or a proxy in front of the core) or generated by the core.
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID middleware tags every request with an ID, which is
+// sent back in the response header and in error bodies.
+func withRequestID(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(requestIDHeader)
+	if len(id) == 0 || len(id) > 128 {
+		id = uuid.NewV4().String()
+	}
+
+	w.Header().Set(requestIDHeader, id)
+	ctx := context.WithValue(r.Context(), requestIDKey, id)
+	next(w, r.WithContext(ctx))
+}
+
+// requestID returns ID of the request, if any.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// reqLog returns logger tagging entries with the request ID.
+func reqLog(r *http.Request) *logging.Logger {
+	return logging.With("request_id", requestID(r))
+}
+
+// logRequests middleware logs every request once it is served.
+func logRequests(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+
+	next(w, r)
+
+	status := http.StatusOK
+	if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+
+	reqLog(r).Info("request served", "method", r.Method, "path", r.URL.Path,
+		"status", status, "duration", time.Since(start))
+}
+
+// recovery middleware converts panics into 500 JSON responses.
+func recovery(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	defer func() {
+		if err := recover(); err != nil {
+			reqLog(r).Error("panic", "err", fmt.Sprint(err), "stack", string(debug.Stack()))
+			e := apiError{Code: codeInternal, Message: "internal server error"}
+			e.RequestID = requestID(r)
+			writeJSON(w, e.status(), errorResponse{e})
+		}
+	}()
+
+	next(w, r)
+}
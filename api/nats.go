@@ -9,56 +9,387 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/nats-io/go-nats"
-	"log"
 	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/ingest"
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+	"github.com/mainflux/mainflux-core/outbox"
+
+	"github.com/nats-io/go-nats"
+	"github.com/satori/go.uuid"
 )
 
 type (
 	NatsMsg struct {
+		// ID identifies duplicates of the re-published message
+		ID        string `json:"id,omitempty"`
 		Channel   string `json:"channel"`
+		Tenant    string `json:"tenant,omitempty"`
 		Publisher string `json:"publisher"`
-		Protocol  string `json:"protocol"`
-		Payload   []byte `json:"payload"`
+		// Key of the publisher device, verified on ingestion
+		// and never passed on
+		Key      string `json:"key,omitempty"`
+		Protocol string `json:"protocol"`
+		Payload  []byte `json:"payload"`
 	}
 )
 
+// defaultFlushTimeout bounds NATS flush on shutdown without deadline.
+const defaultFlushTimeout = 5 * time.Second
+
 var (
 	NatsConn *nats.Conn
+
+	natsSub  *nats.Subscription
+	authzSub *nats.Subscription
+
+	// ingester writes the messages received on mainflux/core/in
+	ingester *ingest.Pool
+
+	// republished collects the messages written by the ingester
+	republished republisher
+
+	// inflight tracks message handlers and asynchronous publishes,
+	// which shutdown waits for.
+	inflight tracker
 )
 
+// msgHandler function
+// Decodes the message and submits it to the ingestion workers. Messages
+// of a channel are handled by the same worker, so that they are written
+// and re-published in order.
 func msgHandler(nm *nats.Msg) {
-	fmt.Printf("Received a message: %s\n", string(nm.Data))
+	inflight.add()
+	defer inflight.done()
 
-	// Re-publish it
-	NatsConn.Publish("mainflux/core/out", nm.Data)
+	natsReceived.Inc()
 
-	// And write it into the database
 	m := NatsMsg{}
 	if len(nm.Data) > 0 {
 		if err := json.Unmarshal(nm.Data, &m); err != nil {
-			println("Can not decode NATS msg")
+			logging.Warn("cannot decode NATS message", "subject", nm.Subject, "err", err)
+			natsFailed.Inc(stageDecode)
 			return
 		}
 	}
+	natsDecoded.Inc()
+
+	// Data carries the device key, so it is never logged
+	logging.Trace("NATS message received", "subject", nm.Subject, "channel", m.Channel,
+		"publisher", m.Publisher, "protocol", m.Protocol)
+
+	// Closures run one after the other on the worker
+	var records []models.Message
+	ingester.Submit(ingest.Message{
+		Key: m.Channel,
+		Prepare: func() ([]models.Message, error) {
+			var err error
+			records, err = prepareMessage(&m)
+			return records, err
+		},
+		Done: func(dup bool, err error) { messageWritten(m, records, dup, err) },
+	})
+}
+
+// prepareMessage function
+// Authorizes the publisher of the message, and returns its records. The
+// channel and the publisher are looked up through the cache, as every
+// message would take two round trips to the database otherwise.
+func prepareMessage(m *NatsMsg) ([]models.Message, error) {
+	// Messages belong to the tenant of their channel, whatever
+	// the publisher claims
+	c, err := lookups.channel(m.Channel)
+	if err != nil {
+		logging.Warn("cannot resolve NATS message channel", "channel", m.Channel, "err", err)
+		natsFailed.Inc(stageChannel)
+		return nil, err
+	}
+	m.Tenant = c.Tenant
+
+	// Publisher must prove its identity by the device key,
+	// and be plugged into the channel
+	if err := deviceAccess(c, m.Publisher, m.Key, lookups.device); err != nil {
+		logging.Warn("cannot authorize NATS message publisher", "channel", m.Channel,
+			"publisher", m.Publisher, "err", err)
+		natsFailed.Inc(stageAuth)
+		return nil, err
+	}
+	m.Key = ""
+
+	msgs, err := messageRecords(*m)
+	if err != nil {
+		logging.Warn("cannot decode NATS message payload", "channel", m.Channel,
+			"publisher", m.Publisher, "protocol", m.Protocol, "err", err)
+		natsFailed.Inc(stageWrite)
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// messageWritten function
+// Re-publishes the message once its records are written, unless it was
+// redelivered. Messages which cannot be written are buffered.
+func messageWritten(m NatsMsg, records []models.Message, dup bool, err error) {
+	if err != nil && currentBuffer() != nil {
+		if err = bufferMessage(m, records); err == nil {
+			return
+		}
+	}
+	if err != nil {
+		logging.Warn("cannot write NATS message", "channel", m.Channel,
+			"publisher", m.Publisher, "protocol", m.Protocol, "err", err)
+		natsFailed.Inc(stageWrite)
+		return
+	}
+
+	if dup {
+		natsDuplicates.Inc()
+		return
+	}
+	senmlWritten.Add(float64(len(records)), m.Protocol)
+
+	if err := republished.add(m); err != nil {
+		logging.Error("cannot re-publish NATS message", "channel", m.Channel, "err", err)
+		natsFailed.Inc(stageOutbox)
+	}
+}
+
+// republish function
+// Stores the message in the outbox, to be re-published on
+// mainflux/core/out in order with the other messages of the channel.
+func republish(m NatsMsg) error {
+	rec, err := republishRecord(m)
+	if err != nil {
+		return err
+	}
 
-	println("Calling writeMessage()")
-	fmt.Println(m.Publisher, m.Protocol, m.Channel, m.Payload)
-	writeMessage(m)
+	return outboxRepo.Append(rec)
 }
 
-func NatsInit(host string, port int) error {
+// republishRecord function
+// Returns the outbox record of the message. Messages keep ID given by
+// the publisher, so that the subscribers can tell the duplicates apart
+// too.
+func republishRecord(m NatsMsg) (models.OutboxRecord, error) {
+	if len(m.ID) == 0 {
+		m.ID = uuid.NewV4().String()
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return models.OutboxRecord{}, err
+	}
+
+	return outbox.NewRecord(m.ID, "mainflux/core/out", "channel:"+m.Channel, b), nil
+}
+
+// republisher stores the messages written by the ingestion workers in
+// the outbox in bulk, once per batch, so that the batch reserves its
+// sequence numbers at once.
+type republisher struct {
+	mu      sync.Mutex
+	records []models.OutboxRecord
+}
+
+// add queues the message to be stored by the next flush.
+func (rp *republisher) add(m NatsMsg) error {
+	rec, err := republishRecord(m)
+	if err != nil {
+		return err
+	}
+
+	rp.mu.Lock()
+	rp.records = append(rp.records, rec)
+	rp.mu.Unlock()
+	return nil
+}
+
+// flush function
+// Stores the queued messages in the outbox. The lock is held while
+// appending, so that the records of a channel, queued by the same
+// worker, are stored in order.
+func (rp *republisher) flush() {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if len(rp.records) == 0 {
+		return
+	}
+
+	if err := outboxRepo.Append(rp.records...); err != nil {
+		logging.Error("cannot re-publish NATS messages", "count", len(rp.records), "err", err)
+		natsFailed.Add(float64(len(rp.records)), stageOutbox)
+	}
+	rp.records = rp.records[:0]
+}
+
+// NatsPublish function
+// Publishes the data on the subject. The outbox relay publishes by it.
+func NatsPublish(subject string, data []byte) error {
+	err := NatsConn.Publish(subject, data)
+	switch {
+	case err == nil:
+		return nil
+	case err == nats.ErrBadSubject || err == nats.ErrMaxPayload:
+		return outbox.ErrRejected
+	case !NatsConn.IsConnected():
+		return outbox.ErrUnavailable
+	}
+
+	return err
+}
+
+// NatsInit function
+// Connects to NATS and subscribes the handlers. Instances sharing the
+// queue group share the messages, each handled by one of them. Empty
+// queue subscribes every instance to every message. Received messages
+// are written by the ingestion workers. Returns error if NATS cannot be
+// connected to, or subscribed on.
+func NatsInit(host string, port int, queue string, ic ingest.Config) error {
+	ingester = ingest.NewPool(ic, saveRecords, republished.flush)
+
 	/** Connect to NATS broker */
 	var err error
-	NatsConn, err = nats.Connect("nats://" + host + ":" + strconv.Itoa(port))
+	NatsConn, err = nats.Connect("nats://"+host+":"+strconv.Itoa(port), nats.ErrorHandler(natsError))
 	if err != nil {
-		log.Fatalf("NATS: Can't connect: %v\n", err)
+		return fmt.Errorf("cannot connect to NATS: %s", err)
 	}
 
 	// Create MQTT bridge
-	NatsConn.Subscribe("mainflux/core/in", msgHandler)
+	if natsSub, err = subscribe("mainflux/core/in", queue, msgHandler); err != nil {
+		NatsConn.Close()
+		return fmt.Errorf("cannot subscribe to NATS subject mainflux/core/in: %s", err)
+	}
+
+	// Answer device authorization requests
+	if authzSub, err = subscribe(authzSubject, queue, authzHandler); err != nil {
+		NatsConn.Close()
+		return fmt.Errorf("cannot subscribe to NATS subject %s: %s", authzSubject, err)
+	}
+
+	RegisterHealthCheck("nats", natsHealth)
+
+	return nil
+}
+
+// natsError function
+// Reports asynchronous NATS errors. Slow consumer errors tell that NATS
+// dropped messages, as the workers did not keep up.
+func natsError(_ *nats.Conn, sub *nats.Subscription, err error) {
+	if err == nats.ErrSlowConsumer {
+		natsSlowConsumer.Inc()
+	}
+
+	subject := ""
+	if sub != nil {
+		subject = sub.Subject
+	}
+	logging.Warn("NATS error", "subject", subject, "err", err)
+}
+
+func subscribe(subject, queue string, h nats.MsgHandler) (*nats.Subscription, error) {
+	if len(queue) == 0 {
+		return NatsConn.Subscribe(subject, h)
+	}
+
+	return NatsConn.QueueSubscribe(subject, queue, h)
+}
+
+// NatsDrain function
+// Stops receiving messages and waits for the messages being handled and
+// written, until ctx is done. Written messages are re-published through
+// the outbox, so the relay is stopped after the drain, and NATS
+// connection is closed by NatsClose after the relay.
+func NatsDrain(ctx context.Context) error {
+	for _, sub := range []*nats.Subscription{natsSub, authzSub} {
+		if sub == nil {
+			continue
+		}
+		if err := sub.Unsubscribe(); err != nil {
+			logging.Warn("cannot unsubscribe from NATS", "subject", sub.Subject, "err", err)
+		}
+	}
+
+	err := inflight.wait(ctx)
+
+	if ingester != nil {
+		if ierr := ingester.Close(ctx); ierr != nil && err == nil {
+			err = ierr
+		}
+	}
 
 	return err
 }
+
+// NatsClose function
+// Flushes the pending publishes, until ctx is done, and closes NATS
+// connection.
+func NatsClose(ctx context.Context) error {
+	if NatsConn == nil {
+		return nil
+	}
+	defer NatsConn.Close()
+
+	timeout := defaultFlushTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if timeout > 0 && NatsConn.IsConnected() {
+		return NatsConn.FlushTimeout(timeout)
+	}
+
+	return nil
+}
+
+// tracker counts work in progress.
+type tracker struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (t *tracker) add() {
+	t.mu.Lock()
+	t.n++
+	t.mu.Unlock()
+}
+
+func (t *tracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.n--
+	if t.n == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// wait waits until there is no work in progress, or ctx is done.
+func (t *tracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.n == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
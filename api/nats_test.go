@@ -0,0 +1,135 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/ingest"
+	"github.com/mainflux/mainflux-core/memory"
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/nats-io/go-nats"
+)
+
+func TestNatsShutdown(t *testing.T) {
+	// Creating channel publishes event
+	res, err := http.Post(ts.URL+"/channels", "application/json", strings.NewReader(`{"name":"shutdown"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d got %d", http.StatusCreated, res.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := api.NatsDrain(ctx); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if err := api.NatsClose(ctx); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+}
+
+// roundTrip simulates the database round trip of the slow repositories.
+const roundTrip = 200 * time.Microsecond
+
+type (
+	slowDevices  struct{ models.DeviceRepository }
+	slowChannels struct{ models.ChannelRepository }
+	slowMessages struct{ models.MessageRepository }
+	slowOutbox   struct{ models.OutboxRepository }
+)
+
+func (s slowDevices) One(id string) (models.Device, error) {
+	time.Sleep(roundTrip)
+	return s.DeviceRepository.One(id)
+}
+
+func (s slowChannels) One(id string) (models.Channel, error) {
+	time.Sleep(roundTrip)
+	return s.ChannelRepository.One(id)
+}
+
+func (s slowMessages) SaveBatch(msgs ...models.Message) ([]bool, error) {
+	time.Sleep(roundTrip)
+	return s.MessageRepository.SaveBatch(msgs...)
+}
+
+func (s slowOutbox) Append(records ...models.OutboxRecord) error {
+	time.Sleep(roundTrip)
+	return s.OutboxRepository.Append(records...)
+}
+
+// BenchmarkMsgHandler handles NATS messages of 16 channels, published
+// by the same device, with a simulated database round trip of 200µs
+// per lookup, insert and outbox append. Uncached lookups, and batches
+// of one message, are how NATS messages were handled before.
+func BenchmarkMsgHandler(b *testing.B) {
+	configs := []struct {
+		name  string
+		ttl   time.Duration
+		batch int
+	}{
+		{"uncached/batch=1", 0, 1},
+		{"cached/batch=1", time.Second, 1},
+		{"uncached/batch=500", 0, 500},
+		{"cached/batch=500", time.Second, 500},
+	}
+
+	k, key, err := auth.NewDeviceKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			dr, cr := memory.NewDeviceRepository(), memory.NewChannelRepository()
+			dr.Save(models.Device{ID: "benchD", Keys: []models.DeviceKey{k}})
+			for i := 0; i < 16; i++ {
+				cr.Save(models.Channel{ID: fmt.Sprintf("benchC%d", i), Devices: []string{"benchD"}})
+			}
+
+			data := make([][]byte, b.N)
+			for i := range data {
+				data[i], _ = json.Marshal(api.NatsMsg{
+					ID:        fmt.Sprintf("bench%d", i),
+					Channel:   fmt.Sprintf("benchC%d", i%16),
+					Publisher: "benchD",
+					Key:       key,
+					Protocol:  "nats",
+					Payload:   []byte(`[{"n":"temperature","v":21.5}]`),
+				})
+			}
+
+			cfg := ingest.Config{Workers: 4, QueueSize: 1024, BatchSize: c.batch, FlushInterval: 100 * time.Millisecond}
+			p, restore := api.StartIngester(cfg, c.ttl, slowDevices{dr}, slowChannels{cr},
+				slowMessages{memory.NewMessageRepository()}, slowOutbox{memory.NewOutboxRepository()})
+			defer restore()
+
+			b.ResetTimer()
+			for i := range data {
+				api.MsgHandler(&nats.Msg{Subject: "mainflux/core/in", Data: data[i]})
+			}
+			p.Close(context.Background())
+		})
+	}
+}
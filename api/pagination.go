@@ -0,0 +1,195 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+const (
+	// defaultLimit is the page size used when `limit` is not provided.
+	defaultLimit = 100
+	// maxLimit is the maximal page size a client can request.
+	maxLimit = 1000
+)
+
+type (
+	// listResponse is the envelope of device and channel listings.
+	listResponse struct {
+		Total int           `json:"total"`
+		Limit int           `json:"limit"`
+		Next  string        `json:"next,omitempty"`
+		Items []interface{} `json:"items"`
+	}
+)
+
+// parseQuery extracts pagination parameters from the request:
+// - cursor = opaque position returned in the `next` link of the previous page
+// - limit = page size, at most maxLimit
+// - sort = created, updated or name, prefixed with `-` for descending order
+func parseQuery(r *http.Request, defaultSort string) (models.Query, error) {
+	q := models.Query{Limit: defaultLimit}
+	params := r.URL.Query()
+
+	if s := params.Get("limit"); len(s) > 0 {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return q, errBadRequest("limit must be between 1 and " + strconv.Itoa(maxLimit))
+		}
+		q.Limit = limit
+	}
+
+	sort := params.Get("sort")
+	if len(sort) == 0 {
+		sort = defaultSort
+	}
+	if strings.HasPrefix(sort, "-") {
+		q.Desc = true
+		sort = sort[1:]
+	}
+	switch sort {
+	case models.SortCreated, models.SortUpdated, models.SortName:
+		q.Sort = sort
+	default:
+		return q, errBadRequest("cannot sort by " + sort)
+	}
+
+	if s := params.Get("cursor"); len(s) > 0 {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, errBadRequest("invalid cursor")
+		}
+		q.Cursor = &c
+	}
+
+	return q, nil
+}
+
+// parseFields extracts the `fields` projection parameter, if any,
+// checking that all the fields exist in the model.
+func parseFields(r *http.Request, model interface{}) (map[string]bool, error) {
+	s := r.URL.Query().Get("fields")
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	doc, err := toMap(model)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); len(f) == 0 {
+			continue
+		}
+		if _, ok := doc[f]; !ok {
+			return nil, errBadRequest("unknown field " + f)
+		}
+		fields[f] = true
+	}
+
+	return fields, nil
+}
+
+func encodeCursor(c models.Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (models.Cursor, error) {
+	c := models.Cursor{}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+
+	if len(c.ID) == 0 {
+		return c, errors.New("cursor without id")
+	}
+
+	return c, nil
+}
+
+// project keeps only the requested fields of the resource.
+func project(v interface{}, fields map[string]bool) (interface{}, error) {
+	if fields == nil {
+		return v, nil
+	}
+
+	doc, err := toMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range doc {
+		if !fields[k] {
+			delete(doc, k)
+		}
+	}
+
+	return doc, nil
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// newListResponse builds the listing envelope. If next is not nil,
+// the envelope links to the page following the given cursor.
+func newListResponse(r *http.Request, q models.Query, total int,
+	items []interface{}, next *models.Cursor, fields map[string]bool) (listResponse, error) {
+	res := listResponse{
+		Total: total,
+		Limit: q.Limit,
+		Items: []interface{}{},
+	}
+
+	if next != nil {
+		params := url.Values{}
+		for k, v := range r.URL.Query() {
+			params[k] = v
+		}
+		params.Set("cursor", encodeCursor(*next))
+		res.Next = r.URL.Path + "?" + params.Encode()
+	}
+
+	for _, item := range items {
+		p, err := project(item, fields)
+		if err != nil {
+			return res, err
+		}
+		res.Items = append(res.Items, p)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,420 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// mergePatchType is the media type of RFC 7396 JSON Merge Patch
+	mergePatchType = "application/merge-patch+json"
+	// jsonPatchType is the media type of RFC 6902 JSON Patch
+	jsonPatchType = "application/json-patch+json"
+)
+
+// writableFields are the resource fields clients can change.
+// Everything else is maintained by the core. Visibility and
+// sharing apply to channels only.
+var writableFields = []string{"name", "description", "metadata", "tags",
+	"visibility", "shared"}
+
+type (
+	// patchOperation is a single RFC 6902 operation
+	patchOperation struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+)
+
+// writable returns writable fields of the resource as a JSON document.
+func writable(v interface{}) (map[string]interface{}, error) {
+	doc, err := toMap(v)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]interface{}{}
+	for _, f := range writableFields {
+		if val, ok := doc[f]; ok && val != nil {
+			res[f] = val
+		}
+	}
+
+	return res, nil
+}
+
+// replaceWritable replaces all writable fields of the resource pointed
+// to by v with the ones from doc. Writable fields missing from doc are
+// reset, while the fields maintained by the core are left untouched,
+// including the ones never exposed in JSON, such as key hashes.
+func replaceWritable(v interface{}, doc map[string]interface{}) error {
+	w := map[string]interface{}{}
+	for _, f := range writableFields {
+		if val, ok := doc[f]; ok {
+			w[f] = val
+		}
+	}
+
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+
+	// Decode into zero value, so that stale maps are not merged with new ones
+	rv := reflect.ValueOf(v).Elem()
+	fresh := reflect.New(rv.Type())
+	if err := json.Unmarshal(b, fresh.Interface()); err != nil {
+		return err
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if isWritable(name) {
+			rv.Field(i).Set(fresh.Elem().Field(i))
+		}
+	}
+
+	return nil
+}
+
+func isWritable(field string) bool {
+	for _, f := range writableFields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergePatch applies RFC 7396 JSON Merge Patch onto the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// jsonPatch applies RFC 6902 JSON Patch operations onto the document.
+// Operations are applied in order; if any of them fails, the error
+// is returned and the whole patch must be discarded.
+func jsonPatch(doc interface{}, ops []patchOperation) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+		if doc, err = applyOperation(doc, op); err != nil {
+			return nil, errors.New("operation " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+
+	return doc, nil
+}
+
+func applyOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errors.New("missing value")
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			return replaceValue(doc, path, value)
+		default:
+			current, err := getValue(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, errors.New("test failed for " + op.Path)
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		return removeValue(doc, path)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, errors.New("cannot move value into its own child")
+			}
+			if doc, err = removeValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// Copy must not share containers with the source
+			b, _ := json.Marshal(value)
+			json.Unmarshal(b, &value)
+		}
+
+		return addValue(doc, path, value)
+
+	default:
+		return nil, errors.New("unknown operation " + op.Op)
+	}
+}
+
+// parsePointer splits RFC 6901 JSON Pointer into reference tokens.
+func parsePointer(p string) ([]string, error) {
+	if len(p) == 0 {
+		return []string{}, nil
+	}
+
+	if p[0] != '/' {
+		return nil, errors.New("invalid pointer " + p)
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		t = strings.Replace(t, "~1", "/", -1)
+		tokens[i] = strings.Replace(t, "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, errors.New("missing member " + t)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errors.New("cannot traverse scalar value at " + t)
+		}
+	}
+
+	return doc, nil
+}
+
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return patchAt(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			if key == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(key, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errors.New("cannot add member to scalar value")
+		}
+	})
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	return patchAt(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, errors.New("missing member " + key)
+			}
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		default:
+			return nil, errors.New("cannot remove member of scalar value")
+		}
+	})
+}
+
+func replaceValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return patchAt(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, errors.New("missing member " + key)
+			}
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		default:
+			return nil, errors.New("cannot replace member of scalar value")
+		}
+	})
+}
+
+// patchAt walks the document down to the parent of the location the
+// path points to, and calls fn with the parent and the last token.
+// Parent returned by fn replaces the original one, as arrays can be
+// reallocated.
+func patchAt(doc interface{}, path []string,
+	fn func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[path[0]]
+		if !ok {
+			return nil, errors.New("missing member " + path[0])
+		}
+		n, err := patchAt(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = n
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(c)-1)
+		if err != nil {
+			return nil, err
+		}
+		n, err := patchAt(c[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = n
+		return c, nil
+	default:
+		return nil, errors.New("cannot traverse scalar value at " + path[0])
+	}
+}
+
+// arrayIndex parses array index token, which must not exceed max.
+func arrayIndex(t string, max int) (int, error) {
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 || i > max || (len(t) > 1 && t[0] == '0') {
+		return 0, errors.New("invalid array index " + t)
+	}
+
+	return i, nil
+}
+
+// errUnsupportedPatch is returned for PATCH requests with a media type
+// other than mergePatchType and jsonPatchType.
+var errUnsupportedPatch = &apiError{
+	Code:    codeUnsupportedMediaType,
+	Message: "use " + mergePatchType + " or " + jsonPatchType,
+}
+
+// applyPatch applies the patch document from the request body onto the
+// resource document, according to the request Content-Type.
+func applyPatch(r *http.Request, doc map[string]interface{}, data []byte) (map[string]interface{}, error) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errUnsupportedPatch
+	}
+
+	var res interface{}
+	switch mt {
+	case mergePatchType:
+		var patch interface{}
+		if err := json.Unmarshal(data, &patch); err != nil {
+			return nil, errBadRequest("cannot decode body")
+		}
+		res = mergePatch(doc, patch)
+	case jsonPatchType:
+		var ops []patchOperation
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return nil, errBadRequest("cannot decode body")
+		}
+		if res, err = jsonPatch(doc, ops); err != nil {
+			return nil, errBadRequest(err.Error())
+		}
+	default:
+		return nil, errUnsupportedPatch
+	}
+
+	patched, ok := res.(map[string]interface{})
+	if !ok {
+		return nil, errBadRequest("patched document must be an object")
+	}
+
+	return patched, nil
+}
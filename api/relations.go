@@ -0,0 +1,237 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Per-ID statuses of plug and unplug requests
+const (
+	statusPlugged        = "plugged"
+	statusAlreadyPlugged = "already_plugged"
+	statusUnplugged      = "unplugged"
+	statusNotPlugged     = "not_plugged"
+	statusNotFound       = "not_found"
+)
+
+type (
+	// side is one side of the device-channel relation, i.e. either
+	// devices with their channels, or channels with their devices.
+	side struct {
+		links  func(id string) ([]string, error)
+		plug   func(id string, ids ...string) error
+		unplug func(id string, ids ...string) error
+	}
+
+	// idResult reports the outcome of plug or unplug for a single ID.
+	idResult struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+
+	// plugResponse is the body of successful plug and unplug responses.
+	plugResponse struct {
+		Response string     `json:"response"`
+		ID       string     `json:"id"`
+		Results  []idResult `json:"results"`
+	}
+
+	// rollback collects compensating actions of a multi-step update.
+	rollback []func() error
+)
+
+// devicesSide function
+// Devices of the request tenant, with their channels.
+func devicesSide(r *http.Request) side {
+	return side{
+		links: func(id string) ([]string, error) {
+			d, err := oneDevice(r, id)
+			return d.Channels, err
+		},
+		plug:   deviceRepo.Plug,
+		unplug: deviceRepo.Unplug,
+	}
+}
+
+// channelsSide function
+// Channels of the request tenant, with their devices.
+func channelsSide(r *http.Request) side {
+	return side{
+		links: func(id string) ([]string, error) {
+			c, err := oneChannel(r, id)
+			return c.Devices, err
+		},
+		plug:   channelRepo.Plug,
+		unplug: channelRepo.Unplug,
+	}
+}
+
+// push registers compensating action.
+func (rb *rollback) push(f func() error) {
+	*rb = append(*rb, f)
+}
+
+// run undoes registered actions in reverse order. Failures are only
+// logged, since there is nothing more that could be done about them.
+func (rb rollback) run() {
+	for i := len(rb) - 1; i >= 0; i-- {
+		if err := rb[i](); err != nil {
+			logging.Error("rollback failed", "err", err)
+		}
+	}
+}
+
+// connect function
+// Plugs (or unplugs) the owner into each of the peers and each of the
+// peers into the owner. All the peers must exist, and either both sides
+// of every relation are updated or none is.
+func connect(owner side, id string, peer side, ids []string, plug bool) ([]idResult, error) {
+	links, err := owner.links(id)
+	if err != nil {
+		return nil, notFound(err, id)
+	}
+
+	ids, err = uniqueIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]idResult, len(ids))
+	// Relations missing on either side, which need to be undone on failure
+	var ownerNew []string
+	var peerNew []string
+	missing := false
+
+	for i, pid := range ids {
+		results[i].ID = pid
+
+		peerLinks, err := peer.links(pid)
+		if err == models.ErrNotFound {
+			results[i].Status = statusNotFound
+			missing = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ownerHas, peerHas := contains(links, pid), contains(peerLinks, id)
+		if plug {
+			results[i].Status = statusPlugged
+			if ownerHas && peerHas {
+				results[i].Status = statusAlreadyPlugged
+			}
+		} else {
+			results[i].Status = statusUnplugged
+			if !ownerHas && !peerHas {
+				results[i].Status = statusNotPlugged
+			}
+		}
+
+		if ownerHas != plug {
+			ownerNew = append(ownerNew, pid)
+		}
+		if peerHas != plug {
+			peerNew = append(peerNew, pid)
+		}
+	}
+
+	if missing {
+		return nil, &apiError{
+			Code:    codeNotFound,
+			Message: "not found",
+			ID:      id,
+			Results: results,
+		}
+	}
+
+	apply, undo := peer.plug, peer.unplug
+	if !plug {
+		apply, undo = undo, apply
+	}
+
+	var rb rollback
+	for _, pid := range peerNew {
+		if err := apply(pid, id); err != nil {
+			rb.run()
+			return nil, notFound(err, pid)
+		}
+		pid := pid
+		rb.push(func() error { return undo(pid, id) })
+	}
+
+	apply, undo = owner.plug, owner.unplug
+	if !plug {
+		apply, undo = undo, apply
+	}
+
+	if len(ownerNew) > 0 {
+		if err := apply(id, ownerNew...); err != nil {
+			rb.run()
+			return nil, notFound(err, id)
+		}
+	}
+
+	return results, nil
+}
+
+// disconnect function
+// Unplugs the owner from all of the peers and removes it. If removal
+// fails, the owner is plugged back into the peers it was unplugged from.
+func disconnect(id string, peer side, ids []string, remove func() error) error {
+	var rb rollback
+	for _, pid := range ids {
+		peerLinks, err := peer.links(pid)
+		if err == models.ErrNotFound {
+			// Dangling reference, nothing to unplug
+			continue
+		}
+		if err != nil {
+			rb.run()
+			return err
+		}
+
+		if !contains(peerLinks, id) {
+			continue
+		}
+
+		if err := peer.unplug(pid, id); err != nil && err != models.ErrNotFound {
+			rb.run()
+			return err
+		}
+		pid := pid
+		rb.push(func() error { return peer.plug(pid, id) })
+	}
+
+	if err := remove(); err != nil {
+		rb.run()
+		return err
+	}
+
+	return nil
+}
+
+// uniqueIDs drops duplicate IDs and rejects empty ones.
+func uniqueIDs(ids []string) ([]string, error) {
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if len(id) == 0 {
+			return nil, errBadRequest("empty ID")
+		}
+		if !contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+
+	return unique, nil
+}
@@ -0,0 +1,181 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type plugResponse struct {
+	Response string `json:"response"`
+	ID       string `json:"id"`
+	Results  []struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	} `json:"results"`
+	Error struct {
+		Code    string `json:"code"`
+		Results []struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"results"`
+	} `json:"error"`
+}
+
+func TestPlugUnplug(t *testing.T) {
+	devices.Save(models.Device{ID: "relD1", Revision: 1})
+	devices.Save(models.Device{ID: "relD2", Revision: 1})
+	channels.Save(models.Channel{ID: "relC1", Revision: 1})
+	channels.Save(models.Channel{ID: "relC2", Revision: 1})
+
+	cases := []struct {
+		path     string
+		body     string
+		code     int
+		statuses []string
+		channels map[string][]string
+		devices  map[string][]string
+	}{
+		// Unknown IDs are reported, and nothing is plugged
+		{"/devices/relD1/plug", `["relC1", "unknown"]`, http.StatusNotFound,
+			[]string{"plugged", "not_found"},
+			map[string][]string{"relD1": nil},
+			map[string][]string{"relC1": nil}},
+		{"/devices/unknown/plug", `["relC1"]`, http.StatusNotFound, nil, nil, nil},
+		{"/devices/relD1/plug", `["relC1", ""]`, http.StatusBadRequest, nil, nil, nil},
+		{"/devices/relD1/plug", `["relC1", "relC2", "relC1"]`, http.StatusOK,
+			[]string{"plugged", "plugged"},
+			map[string][]string{"relD1": {"relC1", "relC2"}},
+			map[string][]string{"relC1": {"relD1"}, "relC2": {"relD1"}}},
+		{"/channels/relC1/plug", `["relD1", "relD2"]`, http.StatusOK,
+			[]string{"already_plugged", "plugged"},
+			map[string][]string{"relD1": {"relC1", "relC2"}, "relD2": {"relC1"}},
+			map[string][]string{"relC1": {"relD1", "relD2"}}},
+		{"/channels/relC1/unplug", `["relD2", "unknown"]`, http.StatusNotFound,
+			[]string{"unplugged", "not_found"},
+			map[string][]string{"relD2": {"relC1"}},
+			map[string][]string{"relC1": {"relD1", "relD2"}}},
+		{"/devices/relD1/unplug", `["relC2", "relC2"]`, http.StatusOK,
+			[]string{"unplugged"},
+			map[string][]string{"relD1": {"relC1"}},
+			map[string][]string{"relC2": nil}},
+		{"/channels/relC2/unplug", `["relD2"]`, http.StatusOK,
+			[]string{"not_plugged"},
+			map[string][]string{"relD2": {"relC1"}},
+			map[string][]string{"relC2": nil}},
+	}
+
+	for i, c := range cases {
+		res, err := http.Post(ts.URL+c.path, "application/json", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("case %d: %s", i+1, err.Error())
+		}
+
+		var body plugResponse
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d, got %d", i+1, c.code, res.StatusCode)
+		}
+
+		results := body.Results
+		if res.StatusCode != http.StatusOK {
+			results = body.Error.Results
+		}
+
+		if c.statuses != nil {
+			statuses := []string{}
+			for _, r := range results {
+				statuses = append(statuses, r.Status)
+			}
+
+			if !reflect.DeepEqual(statuses, c.statuses) {
+				t.Errorf("case %d: expected statuses %v, got %v", i+1, c.statuses, statuses)
+			}
+		}
+
+		for id, expected := range c.channels {
+			d, _ := devices.One(id)
+			if !sameIDs(d.Channels, expected) {
+				t.Errorf("case %d: expected device %s channels %v, got %v", i+1, id, expected, d.Channels)
+			}
+		}
+
+		for id, expected := range c.devices {
+			ch, _ := channels.One(id)
+			if !sameIDs(ch.Devices, expected) {
+				t.Errorf("case %d: expected channel %s devices %v, got %v", i+1, id, expected, ch.Devices)
+			}
+		}
+	}
+}
+
+func TestDeleteCascades(t *testing.T) {
+	devices.Save(models.Device{ID: "cascD", Channels: []string{"cascC1", "cascC2"}, Revision: 1})
+	channels.Save(models.Channel{ID: "cascC1", Devices: []string{"cascD"}, Revision: 1})
+	channels.Save(models.Channel{ID: "cascC2", Devices: []string{"cascD"}, Revision: 1})
+
+	// Rejected removal leaves the relations intact
+	req, _ := http.NewRequest("DELETE", ts.URL+"/devices/cascD", nil)
+	req.Header.Set("If-Match", `"1"`)
+	devices.Plug("cascD", "cascC1")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d, got %d", http.StatusPreconditionFailed, res.StatusCode)
+	}
+
+	for _, cid := range []string{"cascC1", "cascC2"} {
+		c, _ := channels.One(cid)
+		if !sameIDs(c.Devices, []string{"cascD"}) {
+			t.Errorf("expected channel %s devices [cascD], got %v", cid, c.Devices)
+		}
+	}
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/channels/cascC1", nil)
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err.Error())
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	d, _ := devices.One("cascD")
+	if !sameIDs(d.Channels, []string{"cascC2"}) {
+		t.Errorf("expected device channels [cascC2], got %v", d.Channels)
+	}
+}
+
+// sameIDs compares ID lists regardless of their order.
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
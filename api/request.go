@@ -0,0 +1,35 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// readBody reads the whole request body.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errBadRequest("cannot read body")
+	}
+
+	return data, nil
+}
+
+// decodeIDs decodes plug and unplug request bodies, i.e. JSON arrays of IDs.
+func decodeIDs(data []byte) ([]string, error) {
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, errBadRequest("body must be an array of IDs")
+	}
+
+	return ids, nil
+}
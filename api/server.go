@@ -11,35 +11,69 @@ package api
 import (
 	"net/http"
 
+	"github.com/mainflux/mainflux-core/metrics"
+	"github.com/mainflux/mainflux-core/models"
+
 	"github.com/codegangsta/negroni"
 	"github.com/go-zoo/bone"
 )
 
+var (
+	deviceRepo  models.DeviceRepository
+	channelRepo models.ChannelRepository
+	messageRepo models.MessageRepository
+	tenantRepo  models.TenantRepository
+	apiKeyRepo  models.APIKeyRepository
+	outboxRepo  models.OutboxRepository
+)
+
 // HTTPServer function
-func HTTPServer() http.Handler {
+// Builds HTTP API handler backed by the given repositories.
+// Repositories are shared with the NATS message handler,
+// so HTTPServer must be called before NatsInit. Events and
+// re-published messages are stored in the outbox.
+func HTTPServer(dr models.DeviceRepository, cr models.ChannelRepository,
+	mr models.MessageRepository, tr models.TenantRepository,
+	kr models.APIKeyRepository, or models.OutboxRepository) http.Handler {
+	deviceRepo, channelRepo, messageRepo = dr, cr, mr
+	tenantRepo, apiKeyRepo, outboxRepo = tr, kr, or
+
 	mux := bone.New()
 
 	// Status
 	mux.Get("/status", http.HandlerFunc(getStatus))
 
+	// Metrics
+	mux.Get("/metrics", metrics.Handler())
+
+	// Health
+	mux.Get("/health/live", http.HandlerFunc(getLiveness))
+	mux.Get("/health/ready", http.HandlerFunc(getReadiness))
+
 	// Devices
 	mux.Post("/devices", http.HandlerFunc(createDevice))
 	mux.Get("/devices", http.HandlerFunc(getDevices))
 
 	mux.Get("/devices/:device_id", http.HandlerFunc(getDevice))
 	mux.Put("/devices/:device_id", http.HandlerFunc(updateDevice))
+	mux.Patch("/devices/:device_id", http.HandlerFunc(patchDevice))
 
 	mux.Delete("/devices/:device_id", http.HandlerFunc(deleteDevice))
 
 	mux.Post("/devices/:device_id/plug", http.HandlerFunc(plugDevice))
 	mux.Post("/devices/:device_id/unplug", http.HandlerFunc(unplugDevice))
 
+	mux.Get("/devices/:device_id/keys", http.HandlerFunc(getDeviceKeys))
+	mux.Post("/devices/:device_id/keys", http.HandlerFunc(rotateDeviceKey))
+	mux.Delete("/devices/:device_id/keys/:key_id", http.HandlerFunc(revokeDeviceKey))
+
 	// Channels
 	mux.Post("/channels", http.HandlerFunc(createChannel))
 	mux.Get("/channels", http.HandlerFunc(getChannels))
 
 	mux.Get("/channels/:channel_id", http.HandlerFunc(getChannel))
 	mux.Put("/channels/:channel_id", http.HandlerFunc(updateChannel))
+	mux.Patch("/channels/:channel_id", http.HandlerFunc(patchChannel))
 
 	mux.Delete("/channels/:channel_id", http.HandlerFunc(deleteChannel))
 
@@ -49,7 +83,26 @@ func HTTPServer() http.Handler {
 	mux.Post("/channels/:channel_id/msg", http.HandlerFunc(sendMessage))
 	mux.Get("/channels/:channel_id/msg", http.HandlerFunc(getMessage))
 
-	n := negroni.Classic()
+	// Admin
+	mux.Get("/admin/check", admin(checkIntegrity))
+	mux.Post("/admin/check", admin(checkIntegrity))
+
+	mux.Post("/admin/tenants", admin(createTenant))
+	mux.Get("/admin/tenants", admin(getTenants))
+	mux.Get("/admin/tenants/:tenant_id", admin(getTenant))
+	mux.Delete("/admin/tenants/:tenant_id", admin(deleteTenant))
+
+	// Recovery comes right after the request ID, so that panics of the
+	// other middleware are recovered too, and tagged by the ID. Requests
+	// are logged next, including the ones rejected by the middleware.
+	n := negroni.New(instrument(mux),
+		negroni.HandlerFunc(withRequestID),
+		negroni.HandlerFunc(recovery),
+		negroni.HandlerFunc(logRequests),
+		negroni.HandlerFunc(withClientCert),
+		negroni.HandlerFunc(withDeviceKey),
+		negroni.HandlerFunc(authenticate),
+		negroni.HandlerFunc(withTenant))
 	n.UseHandler(mux)
 	return n
 }
@@ -9,13 +9,23 @@
 package api
 
 import (
-	"io"
 	"net/http"
+
+	"github.com/mainflux/mainflux-core/wal"
 )
 
+// statusResponse reports the backlog of the write-ahead log, if enabled.
+type statusResponse struct {
+	Running bool       `json:"running"`
+	Buffer  *wal.Depth `json:"buffer,omitempty"`
+}
+
 func getStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	str := `{"running": true}`
-	io.WriteString(w, str)
+	res := statusResponse{Running: true}
+	if b := currentBuffer(); b != nil {
+		d := b.Depth()
+		res.Buffer = &d
+	}
+
+	writeJSON(w, http.StatusOK, res)
 }
@@ -19,7 +19,7 @@ func TestGetStatus(t *testing.T) {
 		body string
 		code int
 	}{
-		{`{"running": true}`, 200},
+		{`{"running":true}`, 200},
 	}
 
 	url := ts.URL + "/status"
@@ -0,0 +1,275 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/go-zoo/bone"
+)
+
+// tenantHeader selects the tenant of admin principals.
+const tenantHeader = "X-Tenant"
+
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type (
+	// tenantList is the body of tenant listings.
+	tenantList struct {
+		Total int             `json:"total"`
+		Items []models.Tenant `json:"items"`
+	}
+
+	// tenantRemoval reports resources removed with the tenant.
+	tenantRemoval struct {
+		Response string `json:"response"`
+		ID       string `json:"id"`
+		Devices  int    `json:"devices"`
+		Channels int    `json:"channels"`
+		Messages int    `json:"messages"`
+		APIKeys  int    `json:"apikeys"`
+	}
+)
+
+// withTenant middleware resolves the tenant the request acts on.
+// Principals confined to a tenant act on that tenant, and devices
+// authenticated as such on their own tenant. Admins pick the tenant by
+// the X-Tenant header, and everyone acts on the default tenant without it.
+func withTenant(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if publicPaths[r.URL.Path] {
+		next(w, r)
+		return
+	}
+
+	t, err := resolveTenant(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	next(w, r.WithContext(context.WithValue(r.Context(), tenantKey, t)))
+}
+
+// resolveTenant function
+// Principals confined to a tenant are checked first, so that a device
+// identified by the request acts on its tenant only when the request
+// is authenticated as that device.
+func resolveTenant(r *http.Request) (string, error) {
+	hdr := r.Header.Get(tenantHeader)
+	p, authenticated := principal(r)
+
+	if authenticated && p.Kind != auth.KindDevice && len(p.Tenant) > 0 {
+		if len(hdr) > 0 && hdr != p.Tenant {
+			return "", errForbidden("not permitted on tenant " + hdr)
+		}
+		return p.Tenant, nil
+	}
+
+	id := authDevice(r)
+	asDevice := authenticated && p.Kind == auth.KindDevice && p.ID == id ||
+		!authenticated && isPublish(r)
+	if len(id) > 0 && asDevice {
+		d, err := deviceRepo.One(id)
+		if err == nil {
+			if len(hdr) > 0 && hdr != d.Tenant {
+				return "", errForbidden("not permitted on tenant " + hdr)
+			}
+			return d.Tenant, nil
+		}
+		if err != models.ErrNotFound {
+			return "", err
+		}
+	}
+
+	if len(hdr) == 0 {
+		return "", nil
+	}
+
+	if authenticated && !p.Admin {
+		return "", errForbidden("not permitted on tenant " + hdr)
+	}
+
+	if _, err := tenantRepo.One(hdr); err != nil {
+		return "", notFound(err, hdr)
+	}
+
+	return hdr, nil
+}
+
+// tenant returns ID of the tenant the request acts on.
+func tenant(r *http.Request) string {
+	t, _ := r.Context().Value(tenantKey).(string)
+	return t
+}
+
+// oneDevice function
+// Fetches the device, which is reported missing unless it belongs
+// to the request tenant.
+func oneDevice(r *http.Request, id string) (models.Device, error) {
+	d, err := deviceRepo.One(id)
+	if err != nil {
+		return d, err
+	}
+	if d.Tenant != tenant(r) {
+		return models.Device{}, models.ErrNotFound
+	}
+
+	return d, nil
+}
+
+// oneChannel function
+// Fetches the channel, which is reported missing unless it belongs
+// to the request tenant.
+func oneChannel(r *http.Request, id string) (models.Channel, error) {
+	c, err := channelRepo.One(id)
+	if err != nil {
+		return c, err
+	}
+	if c.Tenant != tenant(r) {
+		return models.Channel{}, models.ErrNotFound
+	}
+
+	return c, nil
+}
+
+// requireAdmin function
+// Only admin principals of the default tenant pass. Without the admin
+// claim, an empty tenant is the default tenant and grants nothing.
+func requireAdmin(r *http.Request) error {
+	if p, ok := principal(r); ok && (!p.Admin || len(p.Tenant) > 0) {
+		return errForbidden("administrators only")
+	}
+
+	return nil
+}
+
+// admin wraps handler of the /admin endpoints.
+func admin(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := requireAdmin(r); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// createTenant function
+func createTenant(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var t models.Tenant
+	if err := json.Unmarshal(data, &t); err != nil {
+		writeError(w, r, errBadRequest("cannot decode body"))
+		return
+	}
+
+	if !tenantID.MatchString(t.ID) {
+		writeError(w, r, &apiError{
+			Code:    codeValidationFailed,
+			Message: "invalid tenant",
+			Details: []fieldError{{
+				Field:   "id",
+				Message: "must be lowercase letters, digits and dashes",
+			}},
+		})
+		return
+	}
+
+	t.Created = time.Now().UTC().Format(time.RFC3339)
+
+	if err := tenantRepo.Save(t); err != nil {
+		if err == models.ErrConflict {
+			writeError(w, r, &apiError{Code: codeConflict, Message: "already exists", ID: t.ID})
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/admin/tenants/"+t.ID)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// getTenants function
+func getTenants(w http.ResponseWriter, r *http.Request) {
+	items, err := tenantRepo.All()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tenantList{Total: len(items), Items: items})
+}
+
+// getTenant function
+func getTenant(w http.ResponseWriter, r *http.Request) {
+	id := bone.GetValue(r, "tenant_id")
+
+	t, err := tenantRepo.One(id)
+	if err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+// deleteTenant function
+// Removes the tenant along with its devices, channels, messages and
+// API keys. The tenant goes last, so that a failed removal is retried
+// by repeating the request.
+func deleteTenant(w http.ResponseWriter, r *http.Request) {
+	id := bone.GetValue(r, "tenant_id")
+
+	if _, err := tenantRepo.One(id); err != nil {
+		writeError(w, r, notFound(err, id))
+		return
+	}
+
+	res := tenantRemoval{Response: "deleted", ID: id}
+
+	var err error
+	if res.Channels, err = channelRepo.RemoveByTenant(id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if res.Devices, err = deviceRepo.RemoveByTenant(id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if res.Messages, err = messageRepo.RemoveByTenant(id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if apiKeyRepo != nil {
+		if res.APIKeys, err = apiKeyRepo.RemoveByTenant(id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	if err := tenantRepo.Remove(id); err != nil && err != models.ErrNotFound {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
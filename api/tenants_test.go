@@ -0,0 +1,279 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// acmeKey creates API key confined to the acme tenant.
+func acmeKey(t *testing.T) string {
+	k, value, err := auth.NewAPIKey("bob", "acme", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeys.Save(k)
+
+	return value
+}
+
+func TestTenantIsolation(t *testing.T) {
+	_, disable := enableAuth(t)
+	defer disable()
+
+	tenants.Save(models.Tenant{ID: "acme"})
+	tenants.Save(models.Tenant{ID: "initech"})
+	channels.Save(models.Channel{ID: "tenC1", Tenant: "acme", Revision: 1})
+	channels.Save(models.Channel{ID: "tenC2", Tenant: "initech", Revision: 1})
+	channels.Save(models.Channel{ID: "tenC3", Revision: 1})
+	devices.Save(models.Device{ID: "tenD1", Tenant: "acme", Revision: 1})
+
+	admin := "Bearer " + hs256Claims(`{"sub":"alice","admin":true}`)
+	msg := `[{"n":"temp","v":21}]`
+
+	cases := []struct {
+		method string
+		path   string
+		tenant string
+		body   string
+		code   int
+	}{
+		// Resources of other tenants are missing
+		{"GET", "/channels/tenC1", "acme", "", http.StatusOK},
+		{"GET", "/channels/tenC2", "acme", "", http.StatusNotFound},
+		{"GET", "/channels/tenC3", "acme", "", http.StatusNotFound},
+		{"GET", "/channels/tenC1", "", "", http.StatusNotFound},
+		{"GET", "/channels/tenC3", "", "", http.StatusOK},
+		{"GET", "/devices/tenD1", "initech", "", http.StatusNotFound},
+		{"POST", "/channels/tenC2/msg", "acme", msg, http.StatusNotFound},
+		{"GET", "/channels/tenC2/msg", "acme", "", http.StatusNotFound},
+		// Relations can not cross tenants
+		{"POST", "/devices/tenD1/plug", "acme", `["tenC1"]`, http.StatusOK},
+		{"POST", "/devices/tenD1/plug", "acme", `["tenC2"]`, http.StatusNotFound},
+		{"POST", "/channels/tenC2/plug", "initech", `["tenD1"]`, http.StatusNotFound},
+		// Unknown tenants
+		{"GET", "/channels", "unknown", "", http.StatusNotFound},
+		// Tenant is read-only
+		{"PATCH", "/channels/tenC1", "acme", `{"tenant": "initech"}`, http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", admin)
+		if len(c.tenant) > 0 {
+			req.Header.Set("X-Tenant", c.tenant)
+		}
+		if c.method == "PATCH" {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func TestTenantPrincipal(t *testing.T) {
+	_, disable := enableAuth(t)
+	defer disable()
+
+	tenants.Save(models.Tenant{ID: "acme"})
+	key := "Bearer " + acmeKey(t)
+
+	cases := []struct {
+		method string
+		path   string
+		tenant string
+		code   int
+	}{
+		{"POST", "/channels", "", http.StatusCreated},
+		{"POST", "/channels", "acme", http.StatusCreated},
+		{"POST", "/channels", "initech", http.StatusForbidden},
+		{"GET", "/admin/check", "", http.StatusForbidden},
+		{"GET", "/admin/tenants", "", http.StatusForbidden},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, nil)
+		req.Header.Set("Authorization", key)
+		if len(c.tenant) > 0 {
+			req.Header.Set("X-Tenant", c.tenant)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+			continue
+		}
+
+		if res.StatusCode == http.StatusCreated {
+			id := strings.TrimPrefix(res.Header.Get("Location"), "/channels/")
+			ch, err := channels.One(id)
+			if err != nil {
+				t.Errorf("case %d: %s", i+1, err.Error())
+				continue
+			}
+			if ch.Tenant != "acme" {
+				t.Errorf("case %d: expected tenant acme got %q", i+1, ch.Tenant)
+			}
+		}
+	}
+}
+
+func TestTenantAdmin(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"POST", "/admin/tenants", `{"id": "globex", "name": "Globex"}`, http.StatusCreated},
+		{"POST", "/admin/tenants", `{"id": "globex"}`, http.StatusConflict},
+		{"POST", "/admin/tenants", `{"id": "Globex Corp"}`, http.StatusBadRequest},
+		{"POST", "/admin/tenants", `{"name": "nameless"}`, http.StatusBadRequest},
+		{"GET", "/admin/tenants/globex", "", http.StatusOK},
+		{"GET", "/admin/tenants/unknown", "", http.StatusNotFound},
+		{"DELETE", "/admin/tenants/unknown", "", http.StatusNotFound},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	key, disable := enableAuth(t)
+	defer disable()
+
+	tenants.Save(models.Tenant{ID: "acme"})
+	k, adminKey, err := auth.NewAPIKey("root", "", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Admin = true
+	apiKeys.Save(k)
+
+	cases := []struct {
+		token  string
+		path   string
+		tenant string
+		code   int
+	}{
+		// Principals without tenant are not administrators
+		{key, "/admin/tenants", "", http.StatusForbidden},
+		{key, "/admin/check", "", http.StatusForbidden},
+		{key, "/channels", "acme", http.StatusForbidden},
+		{key, "/channels", "", http.StatusOK},
+		{hs256("carol"), "/admin/tenants", "", http.StatusForbidden},
+		{hs256("carol"), "/channels", "acme", http.StatusForbidden},
+		// Admin claim of a tenant principal is ignored
+		{hs256Claims(`{"sub":"carol","tenant":"acme","admin":true}`), "/admin/tenants", "", http.StatusForbidden},
+		{hs256Claims(`{"sub":"carol","admin":true}`), "/admin/tenants", "", http.StatusOK},
+		{hs256Claims(`{"sub":"carol","admin":true}`), "/channels", "acme", http.StatusOK},
+		{adminKey, "/admin/tenants", "", http.StatusOK},
+		{adminKey, "/channels", "acme", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+c.path, nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		if len(c.tenant) > 0 {
+			req.Header.Set("X-Tenant", c.tenant)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != c.code {
+			t.Errorf("case %d: expected status %d got %d", i+1, c.code, res.StatusCode)
+		}
+	}
+}
+
+func TestDeleteTenant(t *testing.T) {
+	tenants.Save(models.Tenant{ID: "hooli"})
+	channels.Save(models.Channel{ID: "delC1", Tenant: "hooli", Revision: 1})
+	devices.Save(models.Device{ID: "delD1", Tenant: "hooli", Revision: 1})
+	devices.Save(models.Device{ID: "delD2", Revision: 1})
+	messages.Save(models.Message{Channel: "delC1", Tenant: "hooli", Key: "delM1/0"})
+	k, _, _ := auth.NewAPIKey("bob", "hooli", "")
+	apiKeys.Save(k)
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/admin/tenants/hooli", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d got %d", http.StatusOK, res.StatusCode)
+	}
+
+	var body struct {
+		Devices  int `json:"devices"`
+		Channels int `json:"channels"`
+		Messages int `json:"messages"`
+		APIKeys  int `json:"apikeys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Devices != 1 || body.Channels != 1 || body.Messages != 1 || body.APIKeys != 1 {
+		t.Errorf("expected one of each removed got %+v", body)
+	}
+
+	if _, err := tenants.One("hooli"); err != models.ErrNotFound {
+		t.Errorf("expected tenant to be removed got %v", err)
+	}
+	if _, err := devices.One("delD1"); err != models.ErrNotFound {
+		t.Errorf("expected device delD1 to be removed got %v", err)
+	}
+	if _, err := devices.One("delD2"); err != nil {
+		t.Errorf("expected device delD2 to be kept got %v", err)
+	}
+	// Keys of the removed messages are removed too
+	if dups, err := messages.SaveBatch(models.Message{Channel: "delC1", Tenant: "hooli", Key: "delM1/0"}); err != nil || dups[0] {
+		t.Errorf("expected message delM1/0 to be written again got %v %v", dups, err)
+	}
+}
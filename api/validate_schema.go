@@ -9,12 +9,13 @@
 package api
 
 import (
-	"fmt"
-	"log"
 	"os"
-	"reflect"
+	"sort"
 
 	"encoding/json"
+
+	"github.com/mainflux/mainflux-core/logging"
+
 	"github.com/xeipuuv/gojsonschema"
 )
 
@@ -31,99 +32,137 @@ func validateJSONSchema(model string, body map[string]interface{}) bool {
 	bodyLoader := gojsonschema.NewGoLoader(body)
 	result, err := gojsonschema.Validate(schemaLoader, bodyLoader)
 	if err != nil {
-		log.Print(err.Error())
+		logging.Error("cannot validate JSON schema", "model", model, "err", err)
+		return false
 	}
 
 	if !result.Valid() {
-		fmt.Printf("The document is not valid. See errors :\n")
 		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
+			logging.Debug("invalid document", "model", model, "reason", desc.String())
 		}
 		return false
 	}
 
-	fmt.Printf("The document is valid\n")
 	return true
 }
 
-func validateGeneralSchema(body map[string]interface{}) (bool, string) {
-	for k := range body {
+// deviceReadOnly and channelReadOnly are the fields maintained by the core
+var (
+	deviceReadOnly = []string{"id", "created", "updated", "revision",
+		"channels", "online", "connected_at", "disconnected_at", "tenant",
+		"keys"}
+	channelReadOnly = []string{"id", "created", "updated", "revision",
+		"devices", "owner", "tenant"}
+)
+
+// validateGeneralSchema validates fields common to devices and channels,
+// and reports all the invalid ones.
+func validateGeneralSchema(model string, body map[string]interface{},
+	readOnly []string) []fieldError {
+	errs := []fieldError{}
+
+	for k, v := range body {
+		if contains(readOnly, k) {
+			errs = append(errs, fieldError{k, "is read-only"})
+			continue
+		}
+
 		switch k {
-			case "id", "updated", "created":
-				str := `{"response": "invalid request: ` + k + ` is read-only"}`
-				return true, str
-			case "name":
-				if (len(body[k].(string)) > 32) {
-					str := `{"response": "max name size 32"}`
-					return true, str
-				}
-				break
-			case "description":
-				if reflect.ValueOf(body[k]).Kind() != reflect.String  {
-					str := `{"response": "` + k +
-					       ` parameter is of type string"}`
-					return true, str
-				}
-				if (len(body[k].(string)) > 256) {
-					str := `{"response": "max description size 256"}`
-					return true, str
-				}
+		case "name":
+			if s, ok := v.(string); !ok {
+				errs = append(errs, fieldError{k, "must be a string"})
+			} else if len(s) > 32 {
+				errs = append(errs, fieldError{k, "max size is 32"})
+			}
+		case "description":
+			if s, ok := v.(string); !ok {
+				errs = append(errs, fieldError{k, "must be a string"})
+			} else if len(s) > 256 {
+				errs = append(errs, fieldError{k, "max size is 256"})
+			}
+		case "metadata":
+			if _, ok := v.(map[string]interface{}); !ok {
+				errs = append(errs, fieldError{k, "must be an object"})
+			}
+		case "tags":
+			errs = append(errs, validateStrings(k, v)...)
+		case "visibility":
+			if model != "channel" {
+				errs = append(errs, fieldError{k, "is not a " + model + " parameter"})
 				break
-			case "metadata":
-				if reflect.ValueOf(body[k]).Kind() != reflect.Map  {
-					str := `{"response": "parameter metadata is of type object"}`
-					return true, str
-				}
+			}
+			switch v {
+			case visibilityPrivate, visibilityProtected, visibilityPublic:
+			default:
+				errs = append(errs, fieldError{k, "must be one of private, protected, public"})
+			}
+		case "shared":
+			if model != "channel" {
+				errs = append(errs, fieldError{k, "is not a " + model + " parameter"})
 				break
-			default :
-				str := `{"response": "invalid request: ` + k +
-					   ` is not a device parameter"}`
-				return true, str
+			}
+			errs = append(errs, validateStrings(k, v)...)
+		default:
+			errs = append(errs, fieldError{k, "is not a " + model + " parameter"})
 		}
 	}
 
-	return false, ""
-}
+	// Map iteration order is random, keep the report stable
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
 
-func validateDeviceSchema(data []byte) (bool, string) {
-	var body map[string]interface{}
+	return errs
+}
 
-	if err := json.Unmarshal(data, &body); err != nil {
-		str := `{"response": "cannot decode body"}`
-		return true, str
+// validateStrings checks that the field is an array of strings.
+func validateStrings(field string, v interface{}) []fieldError {
+	items, ok := v.([]interface{})
+	if !ok {
+		return []fieldError{{field, "must be an array"}}
 	}
 
-	for k := range body {
-		switch k {
-			case "channels", "connected_at", "disconnected_at", "online":
-				str := `{"response": "invalid request: ` + k + `is read-only"}`
-				return true, str
-			default :
-				return validateGeneralSchema(body)
+	for _, item := range items {
+		if _, ok := item.(string); !ok {
+			return []fieldError{{field, "must contain strings only"}}
 		}
 	}
 
-	return false, ""
+	return nil
 }
 
-func validateChannelSchema(data []byte) (bool, string) {
+func validateSchema(model string, data []byte, readOnly []string) error {
 	var body map[string]interface{}
 
 	if err := json.Unmarshal(data, &body); err != nil {
-		str := `{"response": "cannot decode body"}`
-		return true, str
+		return errBadRequest("cannot decode body")
 	}
 
-	for k := range body {
-		switch k {
-			case "devices", "visibility", "owner":
-				str := `{"response": "invalid request: ` + k + ` is read-only"}`
-				return true, str
+	if errs := validateGeneralSchema(model, body, readOnly); len(errs) > 0 {
+		return &apiError{
+			Code:    codeValidationFailed,
+			Message: "invalid " + model,
+			Details: errs,
+		}
+	}
+
+	return nil
+}
+
+func validateDeviceSchema(data []byte) error {
+	return validateSchema("device", data, deviceReadOnly)
+}
+
+func validateChannelSchema(data []byte) error {
+	return validateSchema("channel", data, channelReadOnly)
+}
 
-			default :
-				return validateGeneralSchema(body)
+func contains(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
 		}
 	}
 
-	return false, ""
+	return false
 }
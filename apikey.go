@@ -0,0 +1,94 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+const apiKeyUsage = "usage: mainflux-core apikey create [-tenant <id> | -admin] <owner> [name] | list | revoke <id>"
+
+// apiKey runs the `apikey` commands and returns the exit status.
+func apiKey(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+
+	keys := db.NewAPIKeyRepository()
+
+	var tenant string
+	var admin bool
+	if args[0] == "create" {
+		fs := flag.NewFlagSet("apikey create", flag.ExitOnError)
+		fs.StringVar(&tenant, "tenant", "", "Confine the key to the tenant.")
+		fs.BoolVar(&admin, "admin", false, "Grant access to all the tenants.")
+		fs.Parse(args[1:])
+		args = append(args[:1], fs.Args()...)
+
+		if admin && len(tenant) > 0 {
+			fmt.Fprintln(os.Stderr, "administrator keys can not be confined to a tenant")
+			return 2
+		}
+	}
+
+	switch {
+	case args[0] == "create" && (len(args) == 2 || len(args) == 3):
+		name := ""
+		if len(args) == 3 {
+			name = args[2]
+		}
+
+		k, value, err := auth.NewAPIKey(args[1], tenant, name)
+		if err == nil {
+			k.Admin = admin
+			err = keys.Save(k)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+
+		// The value can not be recovered from the stored hash
+		fmt.Printf("Created API key %s for %s:\n%s\n", k.ID, k.Owner, value)
+	case args[0] == "list" && len(args) == 1:
+		all, err := keys.All()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		for _, k := range all {
+			tenant := k.Tenant
+			if k.Admin {
+				tenant = "*"
+			}
+			fmt.Printf("%s  %-20s  %-20s  %-20s  %s\n", k.ID, k.Created, k.Owner, tenant, k.Name)
+		}
+	case args[0] == "revoke" && len(args) == 2:
+		if err := keys.Remove(args[1]); err != nil {
+			if err == models.ErrNotFound {
+				err = fmt.Errorf("API key %s not found", args[1])
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		fmt.Printf("Revoked API key %s\n", args[1])
+	default:
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,123 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package auth authenticates API clients by JWTs or API keys.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/satori/go.uuid"
+)
+
+// ErrUnauthorized is returned for missing, malformed, expired or
+// unknown credentials. Reasons are not told apart, so that clients
+// learn nothing about the valid ones.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Kinds of principals
+const (
+	KindUser   = "user"
+	KindAPIKey = "apikey"
+	KindDevice = "device"
+)
+
+// Principal is the authenticated client.
+type Principal struct {
+	// ID is JWT subject, API key owner or device ID.
+	ID   string
+	Kind string
+	// Tenant the principal is confined to, empty for the default tenant.
+	Tenant string
+	// Admin principals of the default tenant manage the tenants and
+	// may act on behalf of any of them.
+	Admin bool
+}
+
+// Authenticator authenticates bearer tokens, which are either JWTs
+// or API keys.
+type Authenticator struct {
+	jwt  *Verifier
+	keys models.APIKeyRepository
+}
+
+// NewAuthenticator creates authenticator. Either of the arguments may
+// be nil, in which case tokens of that type are rejected.
+func NewAuthenticator(jwt *Verifier, keys models.APIKeyRepository) *Authenticator {
+	return &Authenticator{jwt: jwt, keys: keys}
+}
+
+// Authenticate returns principal authenticated by the token.
+func (a *Authenticator) Authenticate(token string) (Principal, error) {
+	if len(token) == 0 {
+		return Principal{}, ErrUnauthorized
+	}
+
+	// JWTs are three dot-separated parts, API keys have no dots
+	if strings.Count(token, ".") == 2 {
+		if a.jwt == nil {
+			return Principal{}, ErrUnauthorized
+		}
+
+		c, err := a.jwt.Verify(token)
+		if err != nil {
+			return Principal{}, err
+		}
+
+		return Principal{ID: c.Subject, Kind: KindUser, Tenant: c.Tenant, Admin: c.Admin}, nil
+	}
+
+	if a.keys == nil {
+		return Principal{}, ErrUnauthorized
+	}
+
+	k, err := a.keys.ByHash(HashKey(token))
+	if err == models.ErrNotFound {
+		return Principal{}, ErrUnauthorized
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{ID: k.Owner, Kind: KindAPIKey, Tenant: k.Tenant, Admin: k.Admin}, nil
+}
+
+// NewAPIKey generates a key for the owner of the tenant. The returned
+// key value is shown once, as only its hash is stored.
+func NewAPIKey(owner, tenant, name string) (models.APIKey, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.APIKey{}, "", err
+	}
+	value := "mf_" + hex.EncodeToString(b)
+
+	k := models.APIKey{
+		ID:      uuid.NewV4().String(),
+		Name:    name,
+		Owner:   owner,
+		Tenant:  tenant,
+		Hash:    HashKey(value),
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return k, value, nil
+}
+
+// HashKey hashes API key value. Keys are random, so unsalted SHA-256
+// is enough to keep them secret.
+func HashKey(value string) string {
+	h := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,252 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package auth_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/memory"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+const secret = "test-secret"
+
+var b64 = base64.RawURLEncoding
+
+// sign creates JWT signed with HMAC secret or RSA private key.
+func sign(t *testing.T, header, claims map[string]interface{}, key interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func writeFile(t *testing.T, content []byte) string {
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemFile := writeFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	defer os.Remove(pemFile)
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   b64.EncodeToString(jwksKey.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(jwksKey.E)).Bytes()),
+		}},
+	})
+	jwksFile := writeFile(t, jwks)
+	defer os.Remove(jwksFile)
+
+	v, err := auth.NewVerifier(auth.JWTConfig{
+		Secret:        secret,
+		PublicKeyFile: pemFile,
+		JWKSFile:      jwksFile,
+		Issuer:        "mainflux",
+		Audience:      "core",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "typ": "JWT"}
+	rsKid := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+	claims := func(kv ...interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "mainflux", "aud": "core", "exp": now + 60}
+		for i := 0; i < len(kv); i += 2 {
+			if kv[i+1] == nil {
+				delete(c, kv[i].(string))
+				continue
+			}
+			c[kv[i].(string)] = kv[i+1]
+		}
+		return c
+	}
+
+	cases := []struct {
+		token string
+		err   bool
+	}{
+		{sign(t, hs, claims(), []byte(secret)), false},
+		{sign(t, rs, claims(), rsaKey), false},
+		{sign(t, rsKid, claims(), jwksKey), false},
+		{sign(t, hs, claims("aud", []string{"other", "core"}), []byte(secret)), false},
+		{sign(t, hs, claims("exp", nil), []byte(secret)), false},
+		// Invalid signatures
+		{sign(t, hs, claims(), []byte("wrong")), true},
+		{sign(t, rs, claims(), jwksKey), true},
+		{sign(t, map[string]interface{}{"alg": "RS256", "kid": "unknown"}, claims(), jwksKey), true},
+		// Public key used as HMAC secret
+		{sign(t, hs, claims(), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), true},
+		{sign(t, map[string]interface{}{"alg": "none"}, claims(), []byte{}), true},
+		// Invalid claims
+		{sign(t, hs, claims("exp", now-120), []byte(secret)), true},
+		{sign(t, hs, claims("nbf", now+120), []byte(secret)), true},
+		{sign(t, hs, claims("iss", "other"), []byte(secret)), true},
+		{sign(t, hs, claims("aud", "other"), []byte(secret)), true},
+		{sign(t, hs, claims("sub", nil), []byte(secret)), true},
+		// Malformed tokens
+		{"a.b.c", true},
+		{"token", true},
+	}
+
+	for i, c := range cases {
+		claims, err := v.Verify(c.token)
+		if (err != nil) != c.err {
+			t.Errorf("case %d: expected error %t got %v", i+1, c.err, err)
+			continue
+		}
+		if err == nil && claims.Subject != "alice" {
+			t.Errorf("case %d: expected subject alice got %s", i+1, claims.Subject)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	v, err := auth.NewVerifier(auth.JWTConfig{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := memory.NewAPIKeyRepository()
+	k, value, err := auth.NewAPIKey("bob", "acme", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Save(k)
+
+	revoked, revokedValue, _ := auth.NewAPIKey("carol", "", "")
+	keys.Save(revoked)
+	keys.Remove(revoked.ID)
+
+	jwt := sign(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"sub": "alice"}, []byte(secret))
+	adminJWT := sign(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"sub": "alice", "admin": true}, []byte(secret))
+
+	cases := []struct {
+		a         *auth.Authenticator
+		token     string
+		principal auth.Principal
+		err       error
+	}{
+		{auth.NewAuthenticator(v, keys), jwt, auth.Principal{ID: "alice", Kind: auth.KindUser}, nil},
+		{auth.NewAuthenticator(v, keys), adminJWT, auth.Principal{ID: "alice", Kind: auth.KindUser, Admin: true}, nil},
+		{auth.NewAuthenticator(v, keys), value, auth.Principal{ID: "bob", Kind: auth.KindAPIKey, Tenant: "acme"}, nil},
+		{auth.NewAuthenticator(v, keys), revokedValue, auth.Principal{}, auth.ErrUnauthorized},
+		{auth.NewAuthenticator(v, keys), "", auth.Principal{}, auth.ErrUnauthorized},
+		{auth.NewAuthenticator(nil, keys), jwt, auth.Principal{}, auth.ErrUnauthorized},
+		{auth.NewAuthenticator(v, nil), value, auth.Principal{}, auth.ErrUnauthorized},
+	}
+
+	for i, c := range cases {
+		p, err := c.a.Authenticate(c.token)
+		if err != c.err {
+			t.Errorf("case %d: expected error %v got %v", i+1, c.err, err)
+		}
+		if p != c.principal {
+			t.Errorf("case %d: expected principal %+v got %+v", i+1, c.principal, p)
+		}
+	}
+
+	if k.Hash == value || k.Hash != auth.HashKey(value) {
+		t.Errorf("expected key to be stored hashed")
+	}
+}
+
+func TestValidDeviceKey(t *testing.T) {
+	k, value, err := auth.NewDeviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expiring := k
+	expiring.Expires = now.Add(time.Minute).Format(time.RFC3339)
+	malformed := k
+	malformed.Expires = "tomorrow"
+
+	cases := []struct {
+		keys  []models.DeviceKey
+		value string
+		now   time.Time
+		valid bool
+	}{
+		{[]models.DeviceKey{k}, value, now, true},
+		{[]models.DeviceKey{expiring}, value, now, true},
+		{[]models.DeviceKey{expiring}, value, now.Add(time.Hour), false},
+		{[]models.DeviceKey{malformed}, value, now, false},
+		{[]models.DeviceKey{k}, value + "x", now, false},
+		{[]models.DeviceKey{k}, "", now, false},
+		{nil, value, now, false},
+	}
+
+	for i, c := range cases {
+		if valid := auth.ValidDeviceKey(models.Device{Keys: c.keys}, c.value, c.now); valid != c.valid {
+			t.Errorf("case %d: expected valid %t got %t", i+1, c.valid, valid)
+		}
+	}
+
+	if k.Hash == value || k.Hash != auth.HashKey(value) {
+		t.Errorf("expected key to be stored hashed")
+	}
+}
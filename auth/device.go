@@ -0,0 +1,67 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/satori/go.uuid"
+)
+
+// NewDeviceKey generates a device key. The returned key value is shown
+// once, as only its hash is stored.
+func NewDeviceKey() (models.DeviceKey, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.DeviceKey{}, "", err
+	}
+	value := "mfd_" + hex.EncodeToString(b)
+
+	k := models.DeviceKey{
+		ID:      uuid.NewV4().String(),
+		Hash:    HashKey(value),
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return k, value, nil
+}
+
+// ValidDeviceKey reports whether the value is one of the device keys,
+// unexpired at the given time.
+func ValidDeviceKey(d models.Device, value string, now time.Time) bool {
+	if len(value) == 0 {
+		return false
+	}
+
+	h := []byte(HashKey(value))
+	for _, k := range d.Keys {
+		if subtle.ConstantTimeCompare(h, []byte(k.Hash)) != 1 {
+			continue
+		}
+		return !Expired(k, now)
+	}
+
+	return false
+}
+
+// Expired reports whether the key is expired at the given time. Keys
+// with malformed expiry are treated as expired.
+func Expired(k models.DeviceKey, now time.Time) bool {
+	if len(k.Expires) == 0 {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339, k.Expires)
+	return err != nil || !now.Before(t)
+}
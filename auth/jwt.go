@@ -0,0 +1,296 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway tolerates clock skew when checking token times.
+const leeway = time.Minute
+
+type (
+	// JWTConfig specifies keys and expected claims of JWTs. At least
+	// one key must be given.
+	JWTConfig struct {
+		// Secret verifies HS256 tokens.
+		Secret string
+		// PublicKeyFile holds PEM encoded RSA key verifying RS256 tokens.
+		PublicKeyFile string
+		// JWKSFile holds JSON Web Key Set, whose RSA keys verify RS256
+		// tokens by their key ID, and symmetric keys HS256 tokens.
+		JWKSFile string
+		// Issuer and Audience are checked if set.
+		Issuer   string
+		Audience string
+	}
+
+	// Claims are the registered claims of a verified token, and the
+	// private `tenant` and `admin` claims.
+	Claims struct {
+		Subject   string      `json:"sub"`
+		Tenant    string      `json:"tenant"`
+		Admin     bool        `json:"admin"`
+		Issuer    string      `json:"iss"`
+		Audience  interface{} `json:"aud"`
+		ExpiresAt *int64      `json:"exp"`
+		NotBefore *int64      `json:"nbf"`
+	}
+
+	// Verifier verifies JWT signatures and claims.
+	Verifier struct {
+		cfg     JWTConfig
+		secrets [][]byte
+		rsaKeys map[string]*rsa.PublicKey
+	}
+
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		K   string `json:"k"`
+	}
+)
+
+// NewVerifier loads the keys.
+func NewVerifier(cfg JWTConfig) (*Verifier, error) {
+	v := &Verifier{cfg: cfg, rsaKeys: make(map[string]*rsa.PublicKey)}
+
+	if len(cfg.Secret) > 0 {
+		v.secrets = append(v.secrets, []byte(cfg.Secret))
+	}
+
+	if len(cfg.PublicKeyFile) > 0 {
+		key, err := loadPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		// Tokens without key ID are verified by this key
+		v.rsaKeys[""] = key
+	}
+
+	if len(cfg.JWKSFile) > 0 {
+		if err := v.loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(v.secrets) == 0 && len(v.rsaKeys) == 0 {
+		return nil, errors.New("no JWT keys configured")
+	}
+
+	return v, nil
+}
+
+func loadPublicKey(file string) (*rsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded key", file)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	rk, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", file)
+	}
+
+	return rk, nil
+}
+
+func (v *Verifier) loadJWKS(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+
+	for i, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return fmt.Errorf("%s: key %d: invalid RSA key", file, i)
+			}
+			v.rsaKeys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return fmt.Errorf("%s: key %d: invalid symmetric key", file, i)
+			}
+			v.secrets = append(v.secrets, secret)
+		}
+	}
+
+	return nil
+}
+
+// Verify checks token signature and claims, and returns the claims.
+// Only HS256 and RS256 tokens are accepted, and the algorithm must
+// match the type of the key, so that RSA public keys can not be
+// abused as HMAC secrets.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrUnauthorized
+	}
+
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return Claims{}, ErrUnauthorized
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrUnauthorized
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch h.Alg {
+	case "HS256":
+		if !v.verifyHMAC(signed, sig) {
+			return Claims{}, ErrUnauthorized
+		}
+	case "RS256":
+		key, ok := v.rsaKeys[h.Kid]
+		if !ok {
+			return Claims{}, ErrUnauthorized
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return Claims{}, ErrUnauthorized
+		}
+	default:
+		return Claims{}, ErrUnauthorized
+	}
+
+	var c Claims
+	if err := decodePart(parts[1], &c); err != nil {
+		return Claims{}, ErrUnauthorized
+	}
+
+	if err := v.validate(c); err != nil {
+		return Claims{}, err
+	}
+
+	return c, nil
+}
+
+func (v *Verifier) verifyHMAC(signed, sig []byte) bool {
+	for _, s := range v.secrets {
+		mac := hmac.New(sha256.New, s)
+		mac.Write(signed)
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) validate(c Claims) error {
+	t := time.Now()
+
+	if len(c.Subject) == 0 {
+		return ErrUnauthorized
+	}
+	if c.ExpiresAt != nil && t.After(time.Unix(*c.ExpiresAt, 0).Add(leeway)) {
+		return ErrUnauthorized
+	}
+	if c.NotBefore != nil && t.Before(time.Unix(*c.NotBefore, 0).Add(-leeway)) {
+		return ErrUnauthorized
+	}
+	if len(v.cfg.Issuer) > 0 && c.Issuer != v.cfg.Issuer {
+		return ErrUnauthorized
+	}
+	if len(v.cfg.Audience) > 0 && !c.hasAudience(v.cfg.Audience) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// hasAudience checks `aud` claim, which is either a string or
+// an array of strings.
+func (c Claims) hasAudience(aud string) bool {
+	switch a := c.Audience.(type) {
+	case string:
+		return a == aud
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == aud {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodePart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,200 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package certs serves TLS certificates, reloading them from files
+// when they change.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/logging"
+)
+
+// Config specifies server certificate, and optionally the CA which
+// signs client certificates.
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds PEM encoded CA certificates. If set, client
+	// certificates are verified against them.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+}
+
+// Reloader holds the certificates loaded from the files.
+type Reloader struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modified map[string]time.Time
+}
+
+// NewReloader loads the certificates.
+func NewReloader(cfg Config) (*Reloader, error) {
+	if cfg.ClientAuth >= tls.VerifyClientCertIfGiven && len(cfg.ClientCAFile) == 0 {
+		return nil, errors.New("client CA is required to verify client certificates")
+	}
+
+	rl := &Reloader{cfg: cfg}
+	if err := rl.load(); err != nil {
+		return nil, err
+	}
+
+	return rl, nil
+}
+
+// TLSConfig returns server configuration, which always uses the most
+// recently loaded certificates.
+func (rl *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: rl.configForClient,
+	}
+}
+
+func (rl *Reloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*rl.cert},
+		ClientAuth:   rl.cfg.ClientAuth,
+		ClientCAs:    rl.clientCA,
+	}, nil
+}
+
+// Reload reloads the certificates if any of the files has changed
+// since they were loaded. It reports whether they were reloaded. On
+// failure, the certificates loaded before are kept.
+func (rl *Reloader) Reload() (bool, error) {
+	changed, err := rl.changed()
+	if err != nil || !changed {
+		return false, err
+	}
+
+	if err := rl.load(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Watch reloads the certificates on change, checking the files every
+// interval until stop is closed.
+func (rl *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		reloaded, err := rl.Reload()
+		if err != nil {
+			logging.Warn("cannot reload certificates", "err", err)
+			continue
+		}
+		if reloaded {
+			logging.Info("certificates reloaded", "cert", rl.cfg.CertFile)
+		}
+	}
+}
+
+func (rl *Reloader) files() []string {
+	files := []string{rl.cfg.CertFile, rl.cfg.KeyFile}
+	if len(rl.cfg.ClientCAFile) > 0 {
+		files = append(files, rl.cfg.ClientCAFile)
+	}
+
+	return files
+}
+
+// changed reports whether modification time of any file differs
+// from the loaded one.
+func (rl *Reloader) changed() (bool, error) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	for _, f := range rl.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(rl.modified[f]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (rl *Reloader) load() error {
+	// Modification times are taken first, so that files changed
+	// while being loaded are loaded again.
+	modified := make(map[string]time.Time)
+	for _, f := range rl.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modified[f] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(rl.cfg.CertFile, rl.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if len(rl.cfg.ClientCAFile) > 0 {
+		b, err := ioutil.ReadFile(rl.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("%s: no PEM encoded certificates", rl.cfg.ClientCAFile)
+		}
+	}
+
+	rl.mu.Lock()
+	rl.cert, rl.clientCA, rl.modified = &cert, pool, modified
+	rl.mu.Unlock()
+
+	return nil
+}
+
+// ClientAuth parses client authentication mode, which is one of none,
+// optional (certificates are verified if given) and require.
+func ClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q", mode)
+}
@@ -0,0 +1,161 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/certs"
+)
+
+// writeCert writes self-signed certificate with the common name, and
+// its key, into the directory.
+func writeCert(t *testing.T, dir, cn string, modified time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "core.crt"), filepath.Join(dir, "core.key")
+	files := map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDer},
+	}
+	for f, b := range files {
+		if err := ioutil.WriteFile(f, pem.EncodeToMemory(b), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Modification time is set explicitly, as writes within
+		// the same clock tick would not be noticed
+		if err := os.Chtimes(f, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, rl *certs.Reloader) string {
+	cfg, err := rl.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now().Add(-time.Minute)
+	certFile, keyFile := writeCert(t, dir, "first", start)
+
+	rl, err := certs.NewReloader(certs.Config{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		write    func()
+		reloaded bool
+		err      bool
+		cn       string
+	}{
+		{func() {}, false, false, "first"},
+		{func() { writeCert(t, dir, "second", start.Add(time.Second)) }, true, false, "second"},
+		{func() {}, false, false, "second"},
+		{func() {
+			ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+			os.Chtimes(keyFile, start.Add(2*time.Second), start.Add(2*time.Second))
+		}, false, true, "second"},
+		{func() { writeCert(t, dir, "third", start.Add(3*time.Second)) }, true, false, "third"},
+	}
+
+	for i, c := range cases {
+		c.write()
+
+		reloaded, err := rl.Reload()
+		if reloaded != c.reloaded {
+			t.Errorf("case %d: expected reloaded %t got %t", i+1, c.reloaded, reloaded)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("case %d: expected error %t got %v", i+1, c.err, err)
+		}
+		if cn := commonName(t, rl); cn != c.cn {
+			t.Errorf("case %d: expected certificate %s got %s", i+1, c.cn, cn)
+		}
+	}
+}
+
+func TestNewReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "core", time.Now())
+
+	cases := []struct {
+		cfg certs.Config
+		err bool
+	}{
+		{certs.Config{CertFile: certFile, KeyFile: keyFile}, false},
+		{certs.Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile,
+			ClientAuth: tls.RequireAndVerifyClientCert}, false},
+		{certs.Config{CertFile: certFile, KeyFile: keyFile,
+			ClientAuth: tls.RequireAndVerifyClientCert}, true},
+		{certs.Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile,
+			ClientAuth: tls.VerifyClientCertIfGiven}, true},
+		{certs.Config{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}, true},
+	}
+
+	for i, c := range cases {
+		_, err := certs.NewReloader(c.cfg)
+		if (err != nil) != c.err {
+			t.Errorf("case %d: expected error %t got %v", i+1, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/integrity"
+)
+
+// check runs the `check` command and returns the exit status:
+// 0 if there are no (unrepaired) issues, 1 if there are some,
+// and 2 if the check itself failed.
+func check(args []string) int {
+	var opts integrity.Options
+	var jsonReport bool
+
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.BoolVar(&opts.Repair, "repair", false, "Repair the issues found, with -dry-run=false.")
+	fs.BoolVar(&opts.DryRun, "dry-run", true, "Only report what would be repaired.")
+	fs.BoolVar(&jsonReport, "json", false, "Print JSON report.")
+	fs.Parse(args)
+
+	c := integrity.NewChecker(db.NewDeviceRepository(), db.NewChannelRepository(),
+		db.NewMessageRepository())
+
+	report, err := c.Check(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check failed: %s\n", err)
+		return 2
+	}
+
+	if jsonReport {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check failed: %s\n", err)
+			return 2
+		}
+		fmt.Println(string(b))
+	} else {
+		printReport(report)
+	}
+
+	if report.Unrepaired() > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// printReport prints human readable report.
+func printReport(r integrity.Report) {
+	fmt.Printf("Scanned %d devices, %d channels and %d messages\n",
+		r.Devices, r.Channels, r.Messages)
+
+	for _, i := range r.Issues {
+		switch i.Kind {
+		case integrity.OrphanedMessages:
+			fmt.Printf("%-18s %s (%d messages)", i.Kind, i.ID, i.Count)
+		default:
+			fmt.Printf("%-18s %s -> %s", i.Kind, i.ID, i.Ref)
+		}
+
+		switch {
+		case i.Repaired:
+			fmt.Printf(": repaired (%s)\n", i.Repair)
+		case len(i.Error) > 0:
+			fmt.Printf(": repair failed (%s)\n", i.Error)
+		case i.Obsolete:
+			fmt.Printf(": gone in the meantime\n")
+		case r.Repair && len(i.Repair) > 0:
+			fmt.Printf(": would %s\n", i.Repair)
+		default:
+			fmt.Println()
+		}
+	}
+
+	fmt.Printf("Found %d issues, %d unrepaired\n", len(r.Issues), r.Unrepaired())
+}
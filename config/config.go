@@ -9,53 +9,385 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"github.com/BurntSushi/toml"
+	"io"
+	"net"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
 )
 
+// redacted replaces secrets in printed config.
+const redacted = "******"
+
 // Config struct
+// Every field can be set in the TOML file under its `toml` key, and
+// with the environment variable named by its `env` tag. Fields tagged
+// as `secret` are redacted when config is printed.
 type Config struct {
 	// HTTP
-	HTTPHost string
-	HTTPPort int
+	HTTPHost string `toml:"httpHost" env:"MF_HTTP_HOST"`
+	HTTPPort int    `toml:"httpPort" env:"MF_HTTP_PORT"`
+
+	// TLS is served if certificate and key are set. Client
+	// certificates are verified against the CA, and devices are
+	// identified by their subject common name.
+	TLSCert       string `toml:"tlsCert" env:"MF_TLS_CERT"`
+	TLSKey        string `toml:"tlsKey" env:"MF_TLS_KEY"`
+	TLSClientCA   string `toml:"tlsClientCA" env:"MF_TLS_CLIENT_CA"`
+	TLSClientAuth string `toml:"tlsClientAuth" env:"MF_TLS_CLIENT_AUTH"`
+
+	// Auth rejects API calls without valid JWT or API key if enabled.
+	// JWTs are verified by the secret (HS256), or by the public key
+	// or JWKS file (RS256).
+	AuthEnabled  bool   `toml:"authEnabled" env:"MF_AUTH_ENABLED"`
+	JWTSecret    string `toml:"jwtSecret" env:"MF_JWT_SECRET" secret:"true"`
+	JWTPublicKey string `toml:"jwtPublicKey" env:"MF_JWT_PUBLIC_KEY"`
+	JWTJWKS      string `toml:"jwtJWKS" env:"MF_JWT_JWKS"`
+	JWTIssuer    string `toml:"jwtIssuer" env:"MF_JWT_ISSUER"`
+	JWTAudience  string `toml:"jwtAudience" env:"MF_JWT_AUDIENCE"`
 
 	// Mongo
-	MongoHost     string
-	MongoPort     int
-	MongoDatabase string
+	MongoHost     string `toml:"mongoHost" env:"MF_MONGO_HOST"`
+	MongoPort     int    `toml:"mongoPort" env:"MF_MONGO_PORT"`
+	MongoDatabase string `toml:"mongoDatabase" env:"MF_MONGO_DATABASE"`
+	MongoUser     string `toml:"mongoUser" env:"MF_MONGO_USER"`
+	MongoPassword string `toml:"mongoPassword" env:"MF_MONGO_PASSWORD" secret:"true"`
 
 	// MQTT
-	MQTTHost string
-	MQTTPort int
+	MQTTHost string `toml:"mqttHost" env:"MF_MQTT_HOST"`
+	MQTTPort int    `toml:"mqttPort" env:"MF_MQTT_PORT"`
 
 	// NATS
-	NatsHost string
-	NatsPort int
+	NatsHost string `toml:"natsHost" env:"MF_NATS_HOST"`
+	NatsPort int    `toml:"natsPort" env:"MF_NATS_PORT"`
+	// NatsEventsSubject prefixes subjects of provisioning events,
+	// e.g. `<prefix>.device.created`.
+	NatsEventsSubject string `toml:"natsEventsSubject" env:"MF_NATS_EVENTS_SUBJECT"`
+	// NatsQueueGroup is the queue group of the instances sharing
+	// ingestion, so that each message is handled by one of them.
+	// Empty disables queueing, i.e. every instance handles every message.
+	NatsQueueGroup string `toml:"natsQueueGroup" env:"MF_NATS_QUEUE_GROUP"`
+
+	// Ingestion
+	IngestWorkers int `toml:"ingestWorkers" env:"MF_INGEST_WORKERS"`
+	// IngestQueueSize is the number of messages waiting per worker,
+	// beyond which NATS buffers and eventually drops the messages.
+	IngestQueueSize int `toml:"ingestQueueSize" env:"MF_INGEST_QUEUE_SIZE"`
+	// IngestBatchSize is the number of records written at once.
+	IngestBatchSize int `toml:"ingestBatchSize" env:"MF_INGEST_BATCH_SIZE"`
+	// IngestFlushInterval is the number of milliseconds after which
+	// the records are written, even if the batch did not fill up.
+	IngestFlushInterval int `toml:"ingestFlushInterval" env:"MF_INGEST_FLUSH_INTERVAL"`
+
+	// Write-ahead log, which buffers messages while MongoDB is down.
+	// Empty directory disables it.
+	WALDir string `toml:"walDir" env:"MF_WAL_DIR"`
+	// WALMaxSize bounds the size of the log, in megabytes.
+	WALMaxSize int `toml:"walMaxSize" env:"MF_WAL_MAX_SIZE"`
+	// WALPolicy applies to the messages buffered in the full log,
+	// which are either rejected, or make room by dropping the oldest.
+	WALPolicy string `toml:"walPolicy" env:"MF_WAL_POLICY"`
 
 	// Influx
-	InfluxHost     string
-	InfluxPort     int
-	InfluxDatabase string
+	InfluxHost     string `toml:"influxHost" env:"MF_INFLUX_HOST"`
+	InfluxPort     int    `toml:"influxPort" env:"MF_INFLUX_PORT"`
+	InfluxDatabase string `toml:"influxDatabase" env:"MF_INFLUX_DATABASE"`
+
+	// Logging
+	LogLevel  string `toml:"logLevel" env:"MF_LOG_LEVEL"`
+	LogFormat string `toml:"logFormat" env:"MF_LOG_FORMAT"`
+	LogFile   string `toml:"logFile" env:"MF_LOG_FILE"`
+	LogTime   bool   `toml:"logTime" env:"MF_LOG_TIME"`
+
+	// Process
+	PidFile string `toml:"pidFile" env:"MF_PID_FILE"`
+	// ShutdownTimeout is the number of seconds given to in-flight
+	// requests and messages to complete on shutdown.
+	ShutdownTimeout int `toml:"shutdownTimeout" env:"MF_SHUTDOWN_TIMEOUT"`
 }
 
-// Parse TOML config
-func (cfg *Config) Parse() {
+// Default returns the configuration used when nothing else is set.
+func Default() Config {
+	return Config{
+		HTTPHost:            "0.0.0.0",
+		HTTPPort:            7070,
+		TLSClientAuth:       "none",
+		MongoHost:           "localhost",
+		MongoPort:           27017,
+		MongoDatabase:       "mainflux",
+		MQTTHost:            "localhost",
+		MQTTPort:            1883,
+		NatsHost:            "localhost",
+		NatsPort:            4222,
+		NatsEventsSubject:   "mainflux.core.events",
+		NatsQueueGroup:      "mainflux-core",
+		IngestWorkers:       4,
+		IngestQueueSize:     1024,
+		IngestBatchSize:     500,
+		IngestFlushInterval: 100,
+		WALMaxSize:          256,
+		WALPolicy:           "reject",
+		InfluxHost:          "localhost",
+		InfluxPort:          8086,
+		InfluxDatabase:      "mainflux",
+		LogLevel:            "info",
+		LogFormat:           "text",
+		LogTime:             true,
+		ShutdownTimeout:     30,
+	}
+}
+
+// Load function
+// Builds configuration from the defaults, overridden by the file
+// (if given), then by MF_* environment variables and finally by the
+// overrides, which map config keys onto values (e.g. from CLI flags).
+// The result is validated.
+func Load(file string, overrides map[string]string) (Config, error) {
+	cfg := Default()
+
+	if len(file) > 0 {
+		if err := cfg.LoadFile(file); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := cfg.LoadEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
 
-	var confFile string
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	testEnv := os.Getenv("TEST_ENV")
-	if testEnv == "" && len(os.Args) > 1 {
-		// We are not in the TEST_ENV (where different args are provided)
-		// and provided config file as an argument
-		confFile = os.Args[1]
-	} else {
-		// default cfg path to source dir, as we keep cfg.yml there
-		confFile = os.Getenv("GOPATH") + "/src/github.com/mainflux/mainflux-core/config/config.toml"
+	for _, k := range keys {
+		if err := cfg.Set(k, overrides[k]); err != nil {
+			return cfg, err
+		}
 	}
 
-	if _, err := toml.DecodeFile(confFile, &cfg); err != nil {
-		// handle error
-		fmt.Println("Error parsing Toml")
+	return cfg, cfg.Validate()
+}
+
+// LoadFile overrides configuration with the values from TOML file.
+// Unknown keys are rejected, so that typos do not go unnoticed.
+func (cfg *Config) LoadFile(file string) error {
+	md, err := toml.DecodeFile(file, cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
 	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return fmt.Errorf("%s: unknown keys %s", file, strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+// LoadEnv overrides configuration with the environment variables
+// found by lookup.
+func (cfg *Config) LoadEnv(lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), s); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Set sets the field with the given TOML key.
+func (cfg *Config) Set(key, value string) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == key {
+			if err := setField(v.Field(i), value); err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown config key %s", key)
+}
+
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		f.SetBool(b)
+	}
+
+	return nil
+}
+
+var hostname = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// subject matches NATS subjects without wildcards.
+var subject = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`)
+
+// Validate checks all the values, and reports all the invalid ones.
+func (cfg Config) Validate() error {
+	var errs []string
+
+	hosts := []struct {
+		key  string
+		host string
+	}{
+		{"httpHost", cfg.HTTPHost},
+		{"mongoHost", cfg.MongoHost},
+		{"mqttHost", cfg.MQTTHost},
+		{"natsHost", cfg.NatsHost},
+		{"influxHost", cfg.InfluxHost},
+	}
+	for _, h := range hosts {
+		if net.ParseIP(h.host) == nil && !hostname.MatchString(h.host) {
+			errs = append(errs, fmt.Sprintf("%s: %q is not a valid host", h.key, h.host))
+		}
+	}
+
+	ports := []struct {
+		key  string
+		port int
+	}{
+		{"httpPort", cfg.HTTPPort},
+		{"mongoPort", cfg.MongoPort},
+		{"mqttPort", cfg.MQTTPort},
+		{"natsPort", cfg.NatsPort},
+		{"influxPort", cfg.InfluxPort},
+	}
+	for _, p := range ports {
+		if p.port < 1 || p.port > 65535 {
+			errs = append(errs, fmt.Sprintf("%s: %d is not between 1 and 65535", p.key, p.port))
+		}
+	}
+
+	if !subject.MatchString(cfg.NatsEventsSubject) {
+		errs = append(errs, fmt.Sprintf("natsEventsSubject: %q is not a valid NATS subject", cfg.NatsEventsSubject))
+	}
+
+	if len(cfg.NatsQueueGroup) > 0 && !subject.MatchString(cfg.NatsQueueGroup) {
+		errs = append(errs, fmt.Sprintf("natsQueueGroup: %q is not a valid NATS queue group", cfg.NatsQueueGroup))
+	}
+
+	counts := []struct {
+		key   string
+		count int
+	}{
+		{"ingestWorkers", cfg.IngestWorkers},
+		{"ingestQueueSize", cfg.IngestQueueSize},
+		{"ingestBatchSize", cfg.IngestBatchSize},
+		{"ingestFlushInterval", cfg.IngestFlushInterval},
+		{"walMaxSize", cfg.WALMaxSize},
+	}
+	for _, c := range counts {
+		if c.count < 1 {
+			errs = append(errs, fmt.Sprintf("%s: %d must be positive", c.key, c.count))
+		}
+	}
+
+	if cfg.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Sprintf("shutdownTimeout: %d must not be negative", cfg.ShutdownTimeout))
+	}
+
+	if (len(cfg.TLSCert) > 0) != (len(cfg.TLSKey) > 0) {
+		errs = append(errs, "tlsCert: must be set together with tlsKey")
+	}
+
+	switch cfg.TLSClientAuth {
+	case "none":
+	case "optional", "require":
+		if len(cfg.TLSCert) == 0 {
+			errs = append(errs, fmt.Sprintf("tlsClientAuth: %q requires tlsCert and tlsKey", cfg.TLSClientAuth))
+		}
+		if len(cfg.TLSClientCA) == 0 {
+			errs = append(errs, fmt.Sprintf("tlsClientAuth: %q requires tlsClientCA", cfg.TLSClientAuth))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("tlsClientAuth: %q is not one of none, optional, require", cfg.TLSClientAuth))
+	}
+
+	if cfg.WALPolicy != "reject" && cfg.WALPolicy != "drop-oldest" {
+		errs = append(errs, fmt.Sprintf("walPolicy: %q is not one of reject, drop-oldest", cfg.WALPolicy))
+	}
+
+	if len(cfg.MongoDatabase) == 0 {
+		errs = append(errs, "mongoDatabase: must not be empty")
+	}
+
+	if len(cfg.MongoPassword) > 0 && len(cfg.MongoUser) == 0 {
+		errs = append(errs, "mongoUser: must be set together with mongoPassword")
+	}
+
+	switch strings.ToLower(cfg.LogLevel) {
+	case "trace", "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("logLevel: %q is not one of trace, debug, info, warn, error", cfg.LogLevel))
+	}
+
+	switch cfg.LogFormat {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Sprintf("logFormat: %q is not one of text, json", cfg.LogFormat))
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// Redacted returns copy of the configuration with secrets replaced.
+func (cfg Config) Redacted() Config {
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if t.Field(i).Tag.Get("secret") == "true" && f.Len() > 0 {
+			f.SetString(redacted)
+		}
+	}
+
+	return cfg
+}
+
+// Print writes the configuration as TOML, with secrets redacted.
+func (cfg Config) Print(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(cfg.Redacted())
 }
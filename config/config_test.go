@@ -0,0 +1,151 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package config_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/config"
+)
+
+func writeFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return f.Name()
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, `
+httpPort = 8080
+mongoHost = "mongo"
+natsHost = "nats"
+`)
+	defer os.Remove(file)
+
+	unknown := writeFile(t, `httpPrt = 8080`)
+	defer os.Remove(unknown)
+
+	cases := []struct {
+		file      string
+		env       map[string]string
+		overrides map[string]string
+		check     func(c config.Config) bool
+		err       string
+	}{
+		{"", nil, nil, func(c config.Config) bool {
+			return c.HTTPPort == 7070 && c.MongoHost == "localhost"
+		}, ""},
+		{file, nil, nil, func(c config.Config) bool {
+			return c.HTTPPort == 8080 && c.MongoHost == "mongo" && c.MongoPort == 27017
+		}, ""},
+		{file, map[string]string{"MF_HTTP_PORT": "9090", "MF_LOG_TIME": "false"}, nil, func(c config.Config) bool {
+			return c.HTTPPort == 9090 && !c.LogTime && c.NatsHost == "nats"
+		}, ""},
+		{file, map[string]string{"MF_HTTP_PORT": "9090"}, map[string]string{"httpPort": "9191"}, func(c config.Config) bool {
+			return c.HTTPPort == 9191
+		}, ""},
+		{"missing.toml", nil, nil, nil, "missing.toml"},
+		{unknown, nil, nil, nil, "unknown keys httpPrt"},
+		{"", map[string]string{"MF_HTTP_PORT": "http"}, nil, nil, `MF_HTTP_PORT: "http" is not an integer`},
+		{"", nil, map[string]string{"httpPort": "70000"}, nil, "httpPort: 70000 is not between 1 and 65535"},
+		{"", nil, map[string]string{"mongoHost": "mongo host"}, nil, `mongoHost: "mongo host" is not a valid host`},
+		{"", nil, map[string]string{"logLevel": "verbose"}, nil, "logLevel"},
+		{"", nil, map[string]string{"mongoPassword": "secret"}, nil, "mongoUser"},
+		{"", nil, map[string]string{"tlsCert": "core.crt"}, nil, "tlsCert: must be set together with tlsKey"},
+		{"", nil, map[string]string{"tlsCert": "core.crt", "tlsKey": "core.key", "tlsClientAuth": "require"}, nil, "requires tlsClientCA"},
+		{"", nil, map[string]string{"tlsClientAuth": "always"}, nil, "tlsClientAuth"},
+		{"", nil, map[string]string{"natsEventsSubject": "events.>"}, nil, "natsEventsSubject"},
+		{"", nil, nil, func(c config.Config) bool {
+			return c.NatsEventsSubject == "mainflux.core.events"
+		}, ""},
+		{"", nil, map[string]string{"natsQueueGroup": "core group"}, nil, "natsQueueGroup"},
+		{"", nil, map[string]string{"ingestWorkers": "0"}, nil, "ingestWorkers: 0 must be positive"},
+		{"", nil, map[string]string{"walPolicy": "drop-newest"}, nil, "walPolicy"},
+		{"", map[string]string{"MF_WAL_DIR": "/var/lib/mainflux/wal"}, map[string]string{"walPolicy": "drop-oldest"}, func(c config.Config) bool {
+			return c.WALDir == "/var/lib/mainflux/wal" && c.WALMaxSize == 256 && c.WALPolicy == "drop-oldest"
+		}, ""},
+		{"", map[string]string{"MF_INGEST_BATCH_SIZE": "1000"}, nil, func(c config.Config) bool {
+			return c.IngestBatchSize == 1000 && c.IngestWorkers == 4 && c.IngestFlushInterval == 100
+		}, ""},
+		{"", nil, nil, func(c config.Config) bool {
+			return c.NatsQueueGroup == "mainflux-core"
+		}, ""},
+		{"", map[string]string{"MF_NATS_QUEUE_GROUP": ""}, nil, func(c config.Config) bool {
+			return len(c.NatsQueueGroup) == 0
+		}, ""},
+		{"", map[string]string{"MF_TLS_CERT": "core.crt", "MF_TLS_KEY": "core.key"}, map[string]string{"tlsClientCA": "ca.crt", "tlsClientAuth": "optional"}, func(c config.Config) bool {
+			return c.TLSCert == "core.crt" && c.TLSClientAuth == "optional"
+		}, ""},
+		{"", nil, map[string]string{"unknown": "x"}, nil, "unknown config key unknown"},
+	}
+
+	for i, c := range cases {
+		for k, v := range c.env {
+			os.Setenv(k, v)
+		}
+
+		cfg, err := config.Load(c.file, c.overrides)
+
+		for k := range c.env {
+			os.Unsetenv(k)
+		}
+
+		if len(c.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("case %d: expected error containing %q, got %v", i+1, c.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("case %d: unexpected error %s", i+1, err)
+			continue
+		}
+
+		if !c.check(cfg) {
+			t.Errorf("case %d: unexpected config %+v", i+1, cfg)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := config.Default()
+	cfg.MongoUser, cfg.MongoPassword = "core", "secret"
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("expected password to be redacted, got\n%s", buf.String())
+	}
+
+	for _, s := range []string{`mongoUser = "core"`, `mongoPassword = "******"`, "httpPort = 7070"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected printed config to contain %s, got\n%s", s, buf.String())
+		}
+	}
+
+	if cfg.MongoPassword != "secret" {
+		t.Errorf("expected config to be left intact")
+	}
+}
@@ -38,6 +38,24 @@ const (
 	// AcceptMaxSleep is the maximum acceptable sleep times on temporary errors
 	AcceptMaxSleep = 1 * time.Second
 
+	// DefaultLogMaxSize is the size in bytes at which log file is rotated.
+	DefaultLogMaxSize = 100 * 1024 * 1024
+
+	// DefaultLogBackups is the number of rotated log files kept.
+	DefaultLogBackups = 5
+
+	// CertsReloadInterval is how often certificate files are checked
+	// for changes.
+	CertsReloadInterval = 10 * time.Second
+
+	// OutboxRelayInterval is how often the outbox is polled, in case
+	// records are appended by another process.
+	OutboxRelayInterval = time.Second
+
+	// WALReplayInterval is how often the write-ahead log is replayed,
+	// unless replay fails.
+	WALReplayInterval = time.Second
+
 	// EmptyString is empty string
 	EmptyString = ""
 )
@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const apiKeysCollection = "apikeys"
+
+type apiKeyRepository struct{}
+
+var _ models.APIKeyRepository = (*apiKeyRepository)(nil)
+
+// NewAPIKeyRepository instantiates MongoDB backed API key repository.
+func NewAPIKeyRepository() models.APIKeyRepository {
+	return instrumentedAPIKeys{&apiKeyRepository{}}
+}
+
+func (kr *apiKeyRepository) Save(k models.APIKey) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	return Db.C(apiKeysCollection).Insert(k)
+}
+
+func (kr *apiKeyRepository) ByHash(hash string) (models.APIKey, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	k := models.APIKey{}
+	err := Db.C(apiKeysCollection).Find(bson.M{"hash": hash}).One(&k)
+	return k, translateError(err)
+}
+
+func (kr *apiKeyRepository) All() ([]models.APIKey, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	results := []models.APIKey{}
+	err := Db.C(apiKeysCollection).Find(nil).Sort("created", "id").All(&results)
+	return results, err
+}
+
+func (kr *apiKeyRepository) Remove(id string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(apiKeysCollection).Remove(bson.M{"id": id})
+	return translateError(err)
+}
+
+func (kr *apiKeyRepository) RemoveByTenant(tenant string) (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	info, err := Db.C(apiKeysCollection).RemoveAll(bson.M{"tenant": tenant})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
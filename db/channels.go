@@ -0,0 +1,130 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const channelsCollection = "channels"
+
+type channelRepository struct{}
+
+var _ models.ChannelRepository = (*channelRepository)(nil)
+
+// NewChannelRepository instantiates MongoDB backed channel repository.
+func NewChannelRepository() models.ChannelRepository {
+	return instrumentedChannels{&channelRepository{}}
+}
+
+func (cr *channelRepository) Save(c models.Channel) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	return Db.C(channelsCollection).Insert(c)
+}
+
+func (cr *channelRepository) One(id string) (models.Channel, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	c := models.Channel{}
+	err := Db.C(channelsCollection).Find(bson.M{"id": id}).One(&c)
+	return c, translateError(err)
+}
+
+func (cr *channelRepository) All(q models.Query) ([]models.Channel, int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	query, total, err := paginate(Db.C(channelsCollection), filterQuery(q.Filter), q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := []models.Channel{}
+	if err := query.All(&results); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+func (cr *channelRepository) Update(c models.Channel) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	sel := revisionQuery(c.ID, c.Revision)
+	c.Revision++
+
+	err := Db.C(channelsCollection).Update(sel, c)
+	return revisionError(Db.C(channelsCollection), c.ID, err)
+}
+
+func (cr *channelRepository) Remove(id string, revision int64) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(channelsCollection).Remove(revisionQuery(id, revision))
+	return revisionError(Db.C(channelsCollection), id, err)
+}
+
+func (cr *channelRepository) Plug(id string, devices ...string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	t := time.Now().UTC().Format(time.RFC3339)
+	change := bson.M{
+		"$addToSet": bson.M{"devices": bson.M{"$each": devices}},
+		"$set":      bson.M{"updated": t},
+		"$inc":      bson.M{"revision": 1},
+	}
+
+	err := Db.C(channelsCollection).Update(bson.M{"id": id}, change)
+	return translateError(err)
+}
+
+func (cr *channelRepository) Unplug(id string, devices ...string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	t := time.Now().UTC().Format(time.RFC3339)
+	change := bson.M{
+		"$pull": bson.M{"devices": bson.M{"$in": devices}},
+		"$set":  bson.M{"updated": t},
+		"$inc":  bson.M{"revision": 1},
+	}
+
+	err := Db.C(channelsCollection).Update(bson.M{"id": id}, change)
+	return translateError(err)
+}
+
+func (cr *channelRepository) RemoveByTenant(tenant string) (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	info, err := Db.C(channelsCollection).RemoveAll(bson.M{"tenant": tenant})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
@@ -0,0 +1,130 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const devicesCollection = "devices"
+
+type deviceRepository struct{}
+
+var _ models.DeviceRepository = (*deviceRepository)(nil)
+
+// NewDeviceRepository instantiates MongoDB backed device repository.
+func NewDeviceRepository() models.DeviceRepository {
+	return instrumentedDevices{&deviceRepository{}}
+}
+
+func (dr *deviceRepository) Save(d models.Device) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	return Db.C(devicesCollection).Insert(d)
+}
+
+func (dr *deviceRepository) One(id string) (models.Device, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	d := models.Device{}
+	err := Db.C(devicesCollection).Find(bson.M{"id": id}).One(&d)
+	return d, translateError(err)
+}
+
+func (dr *deviceRepository) All(q models.Query) ([]models.Device, int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	query, total, err := paginate(Db.C(devicesCollection), filterQuery(q.Filter), q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := []models.Device{}
+	if err := query.All(&results); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+func (dr *deviceRepository) Update(d models.Device) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	sel := revisionQuery(d.ID, d.Revision)
+	d.Revision++
+
+	err := Db.C(devicesCollection).Update(sel, d)
+	return revisionError(Db.C(devicesCollection), d.ID, err)
+}
+
+func (dr *deviceRepository) Remove(id string, revision int64) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(devicesCollection).Remove(revisionQuery(id, revision))
+	return revisionError(Db.C(devicesCollection), id, err)
+}
+
+func (dr *deviceRepository) Plug(id string, channels ...string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	t := time.Now().UTC().Format(time.RFC3339)
+	change := bson.M{
+		"$addToSet": bson.M{"channels": bson.M{"$each": channels}},
+		"$set":      bson.M{"updated": t},
+		"$inc":      bson.M{"revision": 1},
+	}
+
+	err := Db.C(devicesCollection).Update(bson.M{"id": id}, change)
+	return translateError(err)
+}
+
+func (dr *deviceRepository) Unplug(id string, channels ...string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	t := time.Now().UTC().Format(time.RFC3339)
+	change := bson.M{
+		"$pull": bson.M{"channels": bson.M{"$in": channels}},
+		"$set":  bson.M{"updated": t},
+		"$inc":  bson.M{"revision": 1},
+	}
+
+	err := Db.C(devicesCollection).Update(bson.M{"id": id}, change)
+	return translateError(err)
+}
+
+func (dr *deviceRepository) RemoveByTenant(tenant string) (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	info, err := Db.C(devicesCollection).RemoveAll(bson.M{"tenant": tenant})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
@@ -0,0 +1,87 @@
+//go:build integration
+// +build integration
+
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/logging"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/ory-am/dockertest.v3"
+)
+
+// testDb is the database the suite runs against, dropped by reset.
+const testDb = "mainflux_test"
+
+var session *mgo.Session
+
+// TestMain runs the suite against MongoDB started in docker. The suite
+// is built with the integration tag only:
+//
+//	go test -tags integration ./db
+func TestMain(m *testing.M) {
+	// Keep the test output readable
+	logging.Configure(logging.Config{Level: logging.ErrorLevel})
+
+	// uses a sensible default on windows (tcp/http) and linux/osx (socket)
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		log.Fatalf("Could not connect to docker: %s", err)
+	}
+
+	resource, err := pool.Run("mongo", "3.4", nil)
+	if err != nil {
+		log.Fatalf("Could not start resource: %s", err)
+	}
+
+	// exponential backoff-retry, because the application in the container might not be ready to accept connections yet
+	if err := pool.Retry(func() error {
+		var err error
+		session, err = mgo.Dial(fmt.Sprintf("localhost:%s", resource.GetPort("27017/tcp")))
+		if err != nil {
+			return err
+		}
+
+		return session.Ping()
+	}); err != nil {
+		log.Fatalf("Could not connect to docker: %s", err)
+	}
+
+	db.SetMainSession(session)
+	db.SetMainDb(testDb)
+
+	code := m.Run()
+
+	// You can't defer this because os.Exit doesn't care for defer
+	session.Close()
+	if err := pool.Purge(resource); err != nil {
+		log.Fatalf("Could not purge resource: %s", err)
+	}
+
+	os.Exit(code)
+}
+
+// reset drops the test database, and migrates it afresh.
+func reset(t *testing.T) {
+	if err := session.DB(testDb).DropDatabase(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
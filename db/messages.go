@@ -0,0 +1,144 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const messagesCollection = "messages"
+
+type messageRepository struct{}
+
+var _ models.MessageRepository = (*messageRepository)(nil)
+
+// NewMessageRepository instantiates MongoDB backed message repository.
+func NewMessageRepository() models.MessageRepository {
+	return instrumentedMessages{&messageRepository{}}
+}
+
+func (mr *messageRepository) Save(msgs ...models.Message) error {
+	dups, err := mr.SaveBatch(msgs...)
+	if err != nil {
+		return err
+	}
+
+	for _, dup := range dups {
+		if !dup {
+			return nil
+		}
+	}
+	if len(dups) > 0 {
+		return models.ErrConflict
+	}
+
+	return nil
+}
+
+func (mr *messageRepository) SaveBatch(msgs ...models.Message) ([]bool, error) {
+	dups := make([]bool, len(msgs))
+	if len(msgs) == 0 {
+		return dups, nil
+	}
+
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	// Unordered bulk inserts all the messages but the duplicates
+	bulk := Db.C(messagesCollection).Bulk()
+	bulk.Unordered()
+	for _, m := range msgs {
+		bulk.Insert(m)
+	}
+
+	_, err := bulk.Run()
+	berr, ok := err.(*mgo.BulkError)
+	if !ok {
+		return dups, err
+	}
+
+	for _, c := range berr.Cases() {
+		if c.Index < 0 || !mgo.IsDup(c.Err) {
+			return dups, err
+		}
+		dups[c.Index] = true
+	}
+
+	return dups, nil
+}
+
+func (mr *messageRepository) ByChannel(id string, start, end float64) ([]models.Message, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	q := bson.M{"channel": id, "time": bson.M{"$gt": start, "$lt": end}}
+
+	results := []models.Message{}
+	if err := Db.C(messagesCollection).Find(q).All(&results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (mr *messageRepository) CountByChannel() (map[string]int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$channel", "count": bson.M{"$sum": 1}}},
+	}
+
+	var groups []struct {
+		Channel string `bson:"_id"`
+		Count   int    `bson:"count"`
+	}
+	if err := Db.C(messagesCollection).Pipe(pipeline).All(&groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, g := range groups {
+		counts[g.Channel] = g.Count
+	}
+
+	return counts, nil
+}
+
+func (mr *messageRepository) RemoveByChannel(id string) (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	info, err := Db.C(messagesCollection).RemoveAll(bson.M{"channel": id})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
+
+func (mr *messageRepository) RemoveByTenant(tenant string) (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	info, err := Db.C(messagesCollection).RemoveAll(bson.M{"tenant": tenant})
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Removed, nil
+}
@@ -0,0 +1,65 @@
+//go:build integration
+// +build integration
+
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+func TestSaveBatch(t *testing.T) {
+	reset(t)
+
+	repo := db.NewMessageRepository()
+	if _, err := repo.SaveBatch(
+		models.Message{Channel: "c1", Time: 1, Key: "m1/0"},
+		models.Message{Channel: "c1", Time: 2, Key: "m1/1"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicates are skipped, the rest of the batch is written
+	dups, err := repo.SaveBatch(
+		models.Message{Channel: "c1", Time: 1, Key: "m1/0"},
+		models.Message{Channel: "c1", Time: 3, Key: "m2/0"},
+		models.Message{Channel: "c1", Time: 2, Key: "m1/1"},
+		models.Message{Channel: "c1", Time: 4},
+		models.Message{Channel: "c1", Time: 5},
+	)
+	if err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+
+	expected := []bool{true, false, true, false, false}
+	for i := range expected {
+		if i >= len(dups) || dups[i] != expected[i] {
+			t.Errorf("expected duplicates %v got %v", expected, dups)
+			break
+		}
+	}
+
+	msgs, err := repo.ByChannel("c1", 0, math.MaxFloat64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 5 {
+		t.Errorf("expected 5 messages got %d", len(msgs))
+	}
+
+	// Batch of duplicates only is reported as conflict
+	if err := repo.Save(models.Message{Channel: "c1", Time: 1, Key: "m1/0"}); err != models.ErrConflict {
+		t.Errorf("expected error %v got %v", models.ErrConflict, err)
+	}
+}
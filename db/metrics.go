@@ -0,0 +1,347 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"time"
+
+	"github.com/mainflux/mainflux-core/metrics"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+var (
+	opDuration = metrics.NewHistogramVec("mainflux_mongo_operation_duration_seconds",
+		"MongoDB operation latency in seconds.", nil, "collection", "operation")
+	opErrors = metrics.NewCounterVec("mainflux_mongo_operation_errors_total",
+		"Number of failed MongoDB operations.", "collection", "operation")
+)
+
+// observe records operation latency and failure. Missing resources and
+// revision conflicts are regular outcomes, and are not counted as errors.
+func observe(collection, op string, start time.Time, err error) {
+	opDuration.ObserveSince(start, collection, op)
+	if err != nil && err != models.ErrNotFound && err != models.ErrConflict {
+		opErrors.Inc(collection, op)
+	}
+}
+
+// Repositories returned by the constructors are wrapped
+// in the decorators below, which record operation metrics.
+type (
+	instrumentedDevices struct {
+		repo models.DeviceRepository
+	}
+
+	instrumentedChannels struct {
+		repo models.ChannelRepository
+	}
+
+	instrumentedMessages struct {
+		repo models.MessageRepository
+	}
+
+	instrumentedAPIKeys struct {
+		repo models.APIKeyRepository
+	}
+
+	instrumentedTenants struct {
+		repo models.TenantRepository
+	}
+
+	instrumentedOutbox struct {
+		repo models.OutboxRepository
+	}
+)
+
+func (ir instrumentedDevices) Save(d models.Device) error {
+	start := time.Now()
+	err := ir.repo.Save(d)
+	observe(devicesCollection, "save", start, err)
+	return err
+}
+
+func (ir instrumentedDevices) One(id string) (models.Device, error) {
+	start := time.Now()
+	r0, err := ir.repo.One(id)
+	observe(devicesCollection, "one", start, err)
+	return r0, err
+}
+
+func (ir instrumentedDevices) All(q models.Query) ([]models.Device, int, error) {
+	start := time.Now()
+	r0, r1, err := ir.repo.All(q)
+	observe(devicesCollection, "all", start, err)
+	return r0, r1, err
+}
+
+func (ir instrumentedDevices) Update(d models.Device) error {
+	start := time.Now()
+	err := ir.repo.Update(d)
+	observe(devicesCollection, "update", start, err)
+	return err
+}
+
+func (ir instrumentedDevices) Remove(id string, revision int64) error {
+	start := time.Now()
+	err := ir.repo.Remove(id, revision)
+	observe(devicesCollection, "remove", start, err)
+	return err
+}
+
+func (ir instrumentedDevices) Plug(id string, ids ...string) error {
+	start := time.Now()
+	err := ir.repo.Plug(id, ids...)
+	observe(devicesCollection, "plug", start, err)
+	return err
+}
+
+func (ir instrumentedDevices) Unplug(id string, ids ...string) error {
+	start := time.Now()
+	err := ir.repo.Unplug(id, ids...)
+	observe(devicesCollection, "unplug", start, err)
+	return err
+}
+
+func (ir instrumentedChannels) Save(c models.Channel) error {
+	start := time.Now()
+	err := ir.repo.Save(c)
+	observe(channelsCollection, "save", start, err)
+	return err
+}
+
+func (ir instrumentedChannels) One(id string) (models.Channel, error) {
+	start := time.Now()
+	r0, err := ir.repo.One(id)
+	observe(channelsCollection, "one", start, err)
+	return r0, err
+}
+
+func (ir instrumentedChannels) All(q models.Query) ([]models.Channel, int, error) {
+	start := time.Now()
+	r0, r1, err := ir.repo.All(q)
+	observe(channelsCollection, "all", start, err)
+	return r0, r1, err
+}
+
+func (ir instrumentedChannels) Update(c models.Channel) error {
+	start := time.Now()
+	err := ir.repo.Update(c)
+	observe(channelsCollection, "update", start, err)
+	return err
+}
+
+func (ir instrumentedChannels) Remove(id string, revision int64) error {
+	start := time.Now()
+	err := ir.repo.Remove(id, revision)
+	observe(channelsCollection, "remove", start, err)
+	return err
+}
+
+func (ir instrumentedChannels) Plug(id string, ids ...string) error {
+	start := time.Now()
+	err := ir.repo.Plug(id, ids...)
+	observe(channelsCollection, "plug", start, err)
+	return err
+}
+
+func (ir instrumentedChannels) Unplug(id string, ids ...string) error {
+	start := time.Now()
+	err := ir.repo.Unplug(id, ids...)
+	observe(channelsCollection, "unplug", start, err)
+	return err
+}
+
+func (ir instrumentedMessages) Save(msgs ...models.Message) error {
+	start := time.Now()
+	err := ir.repo.Save(msgs...)
+	observe(messagesCollection, "save", start, err)
+	return err
+}
+
+func (ir instrumentedMessages) SaveBatch(msgs ...models.Message) ([]bool, error) {
+	start := time.Now()
+	r0, err := ir.repo.SaveBatch(msgs...)
+	observe(messagesCollection, "save_batch", start, err)
+	return r0, err
+}
+
+func (ir instrumentedMessages) ByChannel(id string, from, to float64) ([]models.Message, error) {
+	start := time.Now()
+	r0, err := ir.repo.ByChannel(id, from, to)
+	observe(messagesCollection, "by_channel", start, err)
+	return r0, err
+}
+
+func (ir instrumentedMessages) CountByChannel() (map[string]int, error) {
+	start := time.Now()
+	r0, err := ir.repo.CountByChannel()
+	observe(messagesCollection, "count_by_channel", start, err)
+	return r0, err
+}
+
+func (ir instrumentedMessages) RemoveByChannel(id string) (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.RemoveByChannel(id)
+	observe(messagesCollection, "remove_by_channel", start, err)
+	return r0, err
+}
+
+func (ir instrumentedAPIKeys) Save(k models.APIKey) error {
+	start := time.Now()
+	err := ir.repo.Save(k)
+	observe(apiKeysCollection, "save", start, err)
+	return err
+}
+
+func (ir instrumentedAPIKeys) ByHash(hash string) (models.APIKey, error) {
+	start := time.Now()
+	r0, err := ir.repo.ByHash(hash)
+	observe(apiKeysCollection, "by_hash", start, err)
+	return r0, err
+}
+
+func (ir instrumentedAPIKeys) All() ([]models.APIKey, error) {
+	start := time.Now()
+	r0, err := ir.repo.All()
+	observe(apiKeysCollection, "all", start, err)
+	return r0, err
+}
+
+func (ir instrumentedAPIKeys) Remove(id string) error {
+	start := time.Now()
+	err := ir.repo.Remove(id)
+	observe(apiKeysCollection, "remove", start, err)
+	return err
+}
+
+func (ir instrumentedDevices) RemoveByTenant(tenant string) (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.RemoveByTenant(tenant)
+	observe(devicesCollection, "remove_by_tenant", start, err)
+	return r0, err
+}
+
+func (ir instrumentedChannels) RemoveByTenant(tenant string) (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.RemoveByTenant(tenant)
+	observe(channelsCollection, "remove_by_tenant", start, err)
+	return r0, err
+}
+
+func (ir instrumentedMessages) RemoveByTenant(tenant string) (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.RemoveByTenant(tenant)
+	observe(messagesCollection, "remove_by_tenant", start, err)
+	return r0, err
+}
+
+func (ir instrumentedAPIKeys) RemoveByTenant(tenant string) (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.RemoveByTenant(tenant)
+	observe(apiKeysCollection, "remove_by_tenant", start, err)
+	return r0, err
+}
+
+func (ir instrumentedTenants) Save(t models.Tenant) error {
+	start := time.Now()
+	err := ir.repo.Save(t)
+	observe(tenantsCollection, "save", start, err)
+	return err
+}
+
+func (ir instrumentedTenants) One(id string) (models.Tenant, error) {
+	start := time.Now()
+	r0, err := ir.repo.One(id)
+	observe(tenantsCollection, "one", start, err)
+	return r0, err
+}
+
+func (ir instrumentedTenants) All() ([]models.Tenant, error) {
+	start := time.Now()
+	r0, err := ir.repo.All()
+	observe(tenantsCollection, "all", start, err)
+	return r0, err
+}
+
+func (ir instrumentedTenants) Remove(id string) error {
+	start := time.Now()
+	err := ir.repo.Remove(id)
+	observe(tenantsCollection, "remove", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Append(records ...models.OutboxRecord) error {
+	start := time.Now()
+	err := ir.repo.Append(records...)
+	observe(outboxCollection, "append", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Stage(rec models.OutboxRecord) (int64, error) {
+	start := time.Now()
+	r0, err := ir.repo.Stage(rec)
+	observe(outboxCollection, "stage", start, err)
+	return r0, err
+}
+
+func (ir instrumentedOutbox) Resolve(seq int64, rec models.OutboxRecord) error {
+	start := time.Now()
+	err := ir.repo.Resolve(seq, rec)
+	observe(outboxCollection, "resolve", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Pending(limit int) ([]models.OutboxRecord, error) {
+	start := time.Now()
+	r0, err := ir.repo.Pending(limit)
+	observe(outboxCollection, "pending", start, err)
+	return r0, err
+}
+
+func (ir instrumentedOutbox) Failed(seq int64) error {
+	start := time.Now()
+	err := ir.repo.Failed(seq)
+	observe(outboxCollection, "failed", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Remove(seqs ...int64) error {
+	start := time.Now()
+	err := ir.repo.Remove(seqs...)
+	observe(outboxCollection, "remove", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Dead(seq int64) error {
+	start := time.Now()
+	err := ir.repo.Dead(seq)
+	observe(outboxCollection, "dead", start, err)
+	return err
+}
+
+func (ir instrumentedOutbox) Count() (int, error) {
+	start := time.Now()
+	r0, err := ir.repo.Count()
+	observe(outboxCollection, "count", start, err)
+	return r0, err
+}
+
+func (ir instrumentedOutbox) Lease(owner string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	r0, err := ir.repo.Lease(owner, ttl)
+	observe(leasesCollection, "lease", start, err)
+	return r0, err
+}
+
+func (ir instrumentedOutbox) Release(owner string) error {
+	start := time.Now()
+	err := ir.repo.Release(owner)
+	observe(leasesCollection, "release", start, err)
+	return err
+}
@@ -0,0 +1,286 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const migrationsCollection = "migrations"
+
+type (
+	// Migration is a versioned change of the database schema or data.
+	// Migrations must be idempotent, as several instances starting at
+	// the same time may apply the same migration concurrently.
+	Migration struct {
+		Version     int
+		Description string
+		Up          func(db *mgo.Database) error
+	}
+
+	// MigrationStatus describes a migration and when it was applied.
+	MigrationStatus struct {
+		Version     int
+		Description string
+		// Applied is zero for pending migrations.
+		Applied time.Time
+	}
+
+	// migrationRecord is stored for every applied migration.
+	migrationRecord struct {
+		Version     int       `bson:"version"`
+		Description string    `bson:"description"`
+		Applied     time.Time `bson:"applied"`
+	}
+)
+
+// migrations lists all the migrations, ordered by version.
+// Append new migrations to the end, and never change applied ones.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create unique id indexes",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				migrationsCollection: {{Key: []string{"version"}, Unique: true}},
+				devicesCollection:    {{Key: []string{"id"}, Unique: true}},
+				channelsCollection:   {{Key: []string{"id"}, Unique: true}},
+			})
+		},
+	},
+	{
+		Version:     2,
+		Description: "create messages channel and time index",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				messagesCollection: {{Key: []string{"channel", "time"}}},
+			})
+		},
+	},
+	{
+		Version:     3,
+		Description: "create device and channel listing indexes",
+		Up: func(db *mgo.Database) error {
+			// Listings are sorted by (field, id), see paginate
+			listing := []mgo.Index{
+				{Key: []string{"created", "id"}},
+				{Key: []string{"updated", "id"}},
+				{Key: []string{"name", "id"}},
+				{Key: []string{"tags"}},
+			}
+
+			return ensureIndexes(db, map[string][]mgo.Index{
+				devicesCollection:  listing,
+				channelsCollection: listing,
+			})
+		},
+	},
+	{
+		Version:     4,
+		Description: "backfill revisions and tags",
+		Up: func(db *mgo.Database) error {
+			// Documents created before revisions were introduced are
+			// at revision 0, which keeps their ETags valid.
+			for _, c := range []string{devicesCollection, channelsCollection} {
+				if _, err := db.C(c).UpdateAll(
+					bson.M{"revision": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"revision": 0}}); err != nil {
+					return err
+				}
+
+				if _, err := db.C(c).UpdateAll(
+					bson.M{"tags": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"tags": []string{}}}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "create API key indexes",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				apiKeysCollection: {
+					{Key: []string{"id"}, Unique: true},
+					{Key: []string{"hash"}, Unique: true},
+				},
+			})
+		},
+	},
+	{
+		Version:     6,
+		Description: "backfill channel visibility, create access indexes",
+		Up: func(db *mgo.Database) error {
+			if _, err := db.C(channelsCollection).UpdateAll(
+				bson.M{"visibility": bson.M{"$in": []interface{}{"", nil}}},
+				bson.M{"$set": bson.M{"visibility": "private"}}); err != nil {
+				return err
+			}
+
+			return ensureIndexes(db, map[string][]mgo.Index{
+				channelsCollection: {
+					{Key: []string{"owner"}},
+					{Key: []string{"shared"}},
+				},
+			})
+		},
+	},
+	{
+		Version:     7,
+		Description: "backfill default tenant, create tenant indexes",
+		Up: func(db *mgo.Database) error {
+			collections := []string{devicesCollection, channelsCollection,
+				messagesCollection, apiKeysCollection}
+			for _, c := range collections {
+				if _, err := db.C(c).UpdateAll(
+					bson.M{"tenant": bson.M{"$exists": false}},
+					bson.M{"$set": bson.M{"tenant": ""}}); err != nil {
+					return err
+				}
+			}
+
+			tenant := []mgo.Index{{Key: []string{"tenant"}}}
+			return ensureIndexes(db, map[string][]mgo.Index{
+				tenantsCollection:  {{Key: []string{"id"}, Unique: true}},
+				devicesCollection:  tenant,
+				channelsCollection: tenant,
+				messagesCollection: tenant,
+				apiKeysCollection:  tenant,
+			})
+		},
+	},
+	{
+		Version:     8,
+		Description: "create outbox indexes",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				outboxCollection: {{Key: []string{"seq"}, Unique: true}},
+			})
+		},
+	},
+	{
+		Version:     9,
+		Description: "create message idempotency key index",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				messagesCollection: {{Key: []string{"key"}, Unique: true, Sparse: true}},
+			})
+		},
+	},
+	{
+		Version:     10,
+		Description: "create outbox dead letters index",
+		Up: func(db *mgo.Database) error {
+			return ensureIndexes(db, map[string][]mgo.Index{
+				deadLettersCollection: {{Key: []string{"seq"}, Unique: true}},
+			})
+		},
+	},
+}
+
+// ensureIndexes creates the indexes that do not exist yet.
+func ensureIndexes(db *mgo.Database, indexes map[string][]mgo.Index) error {
+	for c, idx := range indexes {
+		for _, i := range idx {
+			i.Background = true
+			if err := db.C(c).EnsureIndex(i); err != nil {
+				return fmt.Errorf("cannot index %s on %v: %s", c, i.Key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Migrate function
+// Applies all the pending migrations in order, and returns
+// versions of the applied ones.
+func Migrate() ([]int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	applied, err := appliedMigrations(Db.Db)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []int{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		if err := m.Up(Db.Db); err != nil {
+			return versions, fmt.Errorf("migration %d (%s) failed: %s",
+				m.Version, m.Description, err)
+		}
+
+		rec := migrationRecord{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     time.Now().UTC(),
+		}
+		// Concurrently started instance may have recorded it already
+		if err := Db.C(migrationsCollection).Insert(rec); err != nil && !mgo.IsDup(err) {
+			return versions, err
+		}
+
+		versions = append(versions, m.Version)
+	}
+
+	return versions, nil
+}
+
+// MigrationsStatus function
+// Lists all the known migrations and when they were applied.
+func MigrationsStatus() ([]MigrationStatus, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	applied, err := appliedMigrations(Db.Db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+			Applied:     applied[m.Version],
+		}
+	}
+
+	return status, nil
+}
+
+// appliedMigrations maps versions of the applied migrations
+// onto the time they were applied.
+func appliedMigrations(db *mgo.Database) (map[int]time.Time, error) {
+	var records []migrationRecord
+	if err := db.C(migrationsCollection).Find(nil).All(&records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.Applied
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,41 @@
+//go:build integration
+// +build integration
+
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/mainflux/mainflux-core/db"
+)
+
+func TestMigrate(t *testing.T) {
+	reset(t)
+
+	status, err := db.MigrationsStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.Applied.IsZero() {
+			t.Errorf("expected migration %d applied", s.Version)
+		}
+	}
+
+	// Applied migrations are not applied again
+	versions, err := db.Migrate()
+	if err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("expected no migrations applied got %v", versions)
+	}
+}
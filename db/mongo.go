@@ -9,10 +9,20 @@
 package db
 
 import (
-	"gopkg.in/mgo.v2"
+	"errors"
+	"net"
 	"strconv"
+	"time"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2"
 )
 
+// dialTimeout bounds the time spent connecting to MongoDB.
+const dialTimeout = 10 * time.Second
+
 var (
 	mainSession *mgo.Session
 	mainDb      *mgo.Database
@@ -28,21 +38,55 @@ type MgoDb struct {
 }
 
 // InitMongo function
-func InitMongo(host string, port int, db string) error {
-	var err error
-	if mainSession == nil {
-		mainSession, err = mgo.Dial("mongodb://" + host + ":" + strconv.Itoa(port))
+// Connects to MongoDB, authenticating against the database
+// if user is given.
+func InitMongo(host string, port int, db, user, password string) error {
+	if mainSession != nil {
+		return nil
+	}
 
-		if err != nil {
-			panic(err)
-		}
+	info := &mgo.DialInfo{
+		Addrs:    []string{net.JoinHostPort(host, strconv.Itoa(port))},
+		Database: db,
+		Username: user,
+		Password: password,
+		Timeout:  dialTimeout,
+	}
 
-		mainSession.SetMode(mgo.Monotonic, true)
-		mainDb = mainSession.DB(db)
-		DbName = db
+	s, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return err
 	}
 
-	return err
+	mainSession = s
+	mainSession.SetMode(mgo.Monotonic, true)
+	mainDb = mainSession.DB(db)
+	DbName = db
+
+	return nil
+}
+
+// CloseMongo function
+// Closes the connection to MongoDB. Repositories must not be used
+// afterwards, so it is called only once all the work is done.
+func CloseMongo() {
+	if mainSession != nil {
+		mainSession.Close()
+		mainSession = nil
+	}
+}
+
+// Ping function
+// Checks that MongoDB server is reachable.
+func Ping() error {
+	if mainSession == nil {
+		return errors.New("not connected")
+	}
+
+	s := mainSession.Copy()
+	defer s.Close()
+
+	return s.Ping()
 }
 
 // SetMainSession function
@@ -104,7 +148,7 @@ func (mdb *MgoDb) Index(collection string, keys []string) bool {
 	}
 	err := mdb.Db.C(collection).EnsureIndex(index)
 	if err != nil {
-		println(err)
+		logging.Error("cannot create index", "collection", collection, "err", err)
 		return false
 	}
 
@@ -119,3 +163,12 @@ func (mdb *MgoDb) IsDup(err error) bool {
 
 	return false
 }
+
+// translateError maps mgo errors onto repository errors.
+func translateError(err error) error {
+	if err == mgo.ErrNotFound {
+		return models.ErrNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,194 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	outboxCollection      = "outbox"
+	deadLettersCollection = "outbox_dead"
+	countersCollection    = "counters"
+	leasesCollection      = "leases"
+)
+
+type outboxRepository struct{}
+
+var _ models.OutboxRepository = (*outboxRepository)(nil)
+
+// NewOutboxRepository instantiates MongoDB backed outbox repository.
+func NewOutboxRepository() models.OutboxRepository {
+	return instrumentedOutbox{&outboxRepository{}}
+}
+
+func (or *outboxRepository) Append(records ...models.OutboxRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	_, err := insertRecords(Db.C(countersCollection), Db.C(outboxCollection), records)
+	return err
+}
+
+func (or *outboxRepository) Stage(rec models.OutboxRecord) (int64, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	rec.Staged = true
+	return insertRecords(Db.C(countersCollection), Db.C(outboxCollection), []models.OutboxRecord{rec})
+}
+
+func (or *outboxRepository) Resolve(seq int64, rec models.OutboxRecord) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	rec.Seq, rec.Staged = seq, false
+	err := Db.C(outboxCollection).Update(bson.M{"seq": seq, "staged": true}, rec)
+	return translateError(err)
+}
+
+func (or *outboxRepository) Pending(limit int) ([]models.OutboxRecord, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	results := []models.OutboxRecord{}
+	err := Db.C(outboxCollection).Find(nil).Sort("seq").Limit(limit).All(&results)
+	return results, err
+}
+
+func (or *outboxRepository) Failed(seq int64) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(outboxCollection).Update(bson.M{"seq": seq}, bson.M{"$inc": bson.M{"attempts": 1}})
+	return translateError(err)
+}
+
+func (or *outboxRepository) Remove(seqs ...int64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	_, err := Db.C(outboxCollection).RemoveAll(bson.M{"seq": bson.M{"$in": seqs}})
+	return err
+}
+
+// insertRecords function
+// Reserves sequence numbers at once for all the records, and inserts
+// them. Returns sequence number of the last record.
+func insertRecords(counters, outbox *mgo.Collection, records []models.OutboxRecord) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": len(records)}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	if _, err := counters.FindId(outboxCollection).Apply(change, &counter); err != nil {
+		return 0, err
+	}
+
+	docs := make([]interface{}, len(records))
+	first := counter.Seq - int64(len(records)) + 1
+	for i, r := range records {
+		r.Seq = first + int64(i)
+		docs[i] = r
+	}
+
+	return counter.Seq, outbox.Insert(docs...)
+}
+
+func (or *outboxRepository) Dead(seq int64) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	var rec models.OutboxRecord
+	if err := Db.C(outboxCollection).Find(bson.M{"seq": seq}).One(&rec); err != nil {
+		return translateError(err)
+	}
+
+	// Dead letter inserted twice by a retry is harmless
+	if _, err := Db.C(deadLettersCollection).Upsert(bson.M{"seq": seq}, rec); err != nil {
+		return err
+	}
+
+	return translateError(Db.C(outboxCollection).Remove(bson.M{"seq": seq}))
+}
+
+func (or *outboxRepository) Count() (int, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	return Db.C(outboxCollection).Count()
+}
+
+// Lease function
+// Takes the lease over if it is held by the owner already, or expired.
+// The upsert of the lease held by another owner fails on the duplicate
+// key.
+func (or *outboxRepository) Lease(owner string, ttl time.Duration) (bool, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	now := time.Now()
+	query := bson.M{
+		"_id": outboxCollection,
+		"$or": []bson.M{
+			{"owner": owner},
+			{"expires": bson.M{"$lt": now}},
+		},
+	}
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{"owner": owner, "expires": now.Add(ttl)}},
+		Upsert: true,
+	}
+
+	_, err := Db.C(leasesCollection).Find(query).Apply(change, nil)
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (or *outboxRepository) Release(owner string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(leasesCollection).Update(bson.M{"_id": outboxCollection, "owner": owner},
+		bson.M{"$set": bson.M{"expires": time.Time{}}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,115 @@
+//go:build integration
+// +build integration
+
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+func TestOutboxSeq(t *testing.T) {
+	reset(t)
+
+	repo := db.NewOutboxRepository()
+	repo.Append(models.OutboxRecord{ID: "1"}, models.OutboxRecord{ID: "2"})
+
+	seq, err := repo.Stage(models.OutboxRecord{ID: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 3 {
+		t.Errorf("expected staged record seq 3 got %d", seq)
+	}
+
+	// Concurrent appends reserve distinct sequence numbers
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo.Append(models.OutboxRecord{}, models.OutboxRecord{})
+		}()
+	}
+	wg.Wait()
+
+	records, err := repo.Pending(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 23 {
+		t.Fatalf("expected 23 records got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != int64(i+1) {
+			t.Errorf("expected seq %d got %d", i+1, r.Seq)
+		}
+	}
+	if !records[2].Staged {
+		t.Errorf("expected record 3 staged")
+	}
+
+	// Sequence numbers are not reused once the records are removed
+	repo.Remove(22, 23)
+	repo.Append(models.OutboxRecord{ID: "24"})
+	if records, _ := repo.Pending(100); records[len(records)-1].Seq != 24 {
+		t.Errorf("expected seq 24 got %d", records[len(records)-1].Seq)
+	}
+}
+
+func TestOutboxLease(t *testing.T) {
+	reset(t)
+
+	repo := db.NewOutboxRepository()
+
+	cases := []struct {
+		op     string
+		owner  string
+		leader bool
+	}{
+		{"lease", "r1", true},
+		{"lease", "r2", false},
+		{"lease", "r1", true},
+		{"release", "r2", false},
+		{"lease", "r2", false},
+		{"release", "r1", false},
+		{"lease", "r2", true},
+		{"lease", "r1", false},
+	}
+
+	for i, c := range cases {
+		if c.op == "release" {
+			if err := repo.Release(c.owner); err != nil {
+				t.Errorf("case %d: expected no error got %v", i+1, err)
+			}
+			continue
+		}
+
+		leader, err := repo.Lease(c.owner, time.Minute)
+		if err != nil {
+			t.Errorf("case %d: expected no error got %v", i+1, err)
+		}
+		if leader != c.leader {
+			t.Errorf("case %d: expected leader %v got %v", i+1, c.leader, leader)
+		}
+	}
+
+	// Expired lease is taken over
+	repo.Lease("r2", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if leader, _ := repo.Lease("r1", time.Minute); !leader {
+		t.Errorf("expected expired lease to be taken over")
+	}
+}
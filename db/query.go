@@ -0,0 +1,171 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"regexp"
+
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// paginate builds MongoDB query for a page of the collection described
+// by q, limited to the documents matching the filter. It also returns
+// the total number of matching documents.
+func paginate(c *mgo.Collection, filter bson.M, q models.Query) (*mgo.Query, int, error) {
+	total, err := c.Find(filter).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	field := sortField(q.Sort)
+
+	op, prefix := "$gt", ""
+	if q.Desc {
+		op, prefix = "$lt", "-"
+	}
+
+	sel := filter
+	if q.Cursor != nil {
+		// Keyset pagination: continue right after the last seen
+		// (field, id) pair, so that pages do not shift on inserts.
+		after := bson.M{"$or": []bson.M{
+			{field: bson.M{op: q.Cursor.Value}},
+			{field: q.Cursor.Value, "id": bson.M{op: q.Cursor.ID}},
+		}}
+		sel = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	query := c.Find(sel).Sort(prefix+field, prefix+"id")
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	return query, total, nil
+}
+
+func sortField(sort string) string {
+	switch sort {
+	case models.SortUpdated, models.SortName:
+		return sort
+	default:
+		return models.SortCreated
+	}
+}
+
+// filterQuery translates the filter into MongoDB selector.
+func filterQuery(f models.Filter) bson.M {
+	conds := []bson.M{}
+
+	if len(f.Name) > 0 {
+		conds = append(conds, bson.M{"name": f.Name})
+	}
+
+	if len(f.NameContains) > 0 {
+		re := bson.RegEx{Pattern: regexp.QuoteMeta(f.NameContains), Options: "i"}
+		conds = append(conds, bson.M{"name": re})
+	}
+
+	if len(f.Tags) > 0 {
+		conds = append(conds, bson.M{"tags": bson.M{"$all": f.Tags}})
+	}
+
+	if f.Online != nil {
+		conds = append(conds, bson.M{"online": *f.Online})
+	}
+
+	if f.Tenant != nil {
+		conds = append(conds, bson.M{"tenant": *f.Tenant})
+	}
+
+	if len(f.VisibleTo) > 0 {
+		conds = append(conds, bson.M{"$or": []bson.M{
+			{"owner": bson.M{"$in": []string{"", f.VisibleTo}}},
+			{"visibility": "public"},
+			{"visibility": "protected", "shared": f.VisibleTo},
+		}})
+	}
+
+	for path, value := range f.Metadata {
+		values := models.MetadataValues(value)
+		conds = append(conds, bson.M{"metadata." + path: bson.M{"$in": values}})
+	}
+
+	if c := timeRange(f.CreatedAfter, f.CreatedBefore); c != nil {
+		conds = append(conds, bson.M{"created": c})
+	}
+
+	if c := timeRange(f.UpdatedAfter, f.UpdatedBefore); c != nil {
+		conds = append(conds, bson.M{"updated": c})
+	}
+
+	switch len(conds) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conds[0]
+	default:
+		return bson.M{"$and": conds}
+	}
+}
+
+func timeRange(after, before string) bson.M {
+	r := bson.M{}
+
+	if len(after) > 0 {
+		r["$gt"] = after
+	}
+
+	if len(before) > 0 {
+		r["$lt"] = before
+	}
+
+	if len(r) == 0 {
+		return nil
+	}
+
+	return r
+}
+
+// revisionQuery selects the document with the given ID and revision.
+// Documents created before revisions were introduced have none,
+// and match revision 0.
+func revisionQuery(id string, revision int64) bson.M {
+	q := bson.M{"id": id}
+
+	switch {
+	case revision == 0:
+		q["revision"] = bson.M{"$in": []interface{}{0, nil}}
+	case revision > 0:
+		q["revision"] = revision
+	}
+
+	return q
+}
+
+// revisionError tells apart missing documents from the stale ones,
+// when a revision-conditional write did not match any document.
+func revisionError(c *mgo.Collection, id string, err error) error {
+	if err != mgo.ErrNotFound {
+		return err
+	}
+
+	n, err := c.Find(bson.M{"id": id}).Count()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return models.ErrConflict
+	}
+
+	return models.ErrNotFound
+}
@@ -0,0 +1,125 @@
+//go:build integration
+// +build integration
+
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPagination(t *testing.T) {
+	reset(t)
+
+	repo := db.NewDeviceRepository()
+	seed := []models.Device{
+		{ID: "d1", Name: "b", Created: "2017-01-01T00:00:00Z"},
+		{ID: "d2", Name: "a", Created: "2017-01-02T00:00:00Z"},
+		{ID: "d3", Name: "c", Created: "2017-01-02T00:00:00Z"},
+		{ID: "d4", Name: "a", Created: "2017-01-03T00:00:00Z"},
+		{ID: "d5", Name: "d", Created: "2017-01-04T00:00:00Z"},
+	}
+	for _, d := range seed {
+		if err := repo.Save(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		sort  string
+		desc  bool
+		pages string
+	}{
+		// Devices created at the same time are ordered by ID
+		{models.SortCreated, false, "d1d2,d3d4,d5"},
+		{models.SortCreated, true, "d5d4,d3d2,d1"},
+		{models.SortName, false, "d2d4,d1d3,d5"},
+		{models.SortName, true, "d5d3,d1d4,d2"},
+	}
+
+	for i, c := range cases {
+		q := models.Query{Limit: 2, Sort: c.sort, Desc: c.desc}
+		pages := []string{}
+		for {
+			devices, total, err := repo.All(q)
+			if err != nil {
+				t.Fatalf("case %d: %s", i+1, err.Error())
+			}
+			if total != len(seed) {
+				t.Errorf("case %d: expected total %d got %d", i+1, len(seed), total)
+			}
+			if len(devices) == 0 {
+				break
+			}
+
+			page := ""
+			for _, d := range devices {
+				page += d.ID
+			}
+			pages = append(pages, page)
+
+			last := devices[len(devices)-1]
+			q.Cursor = &models.Cursor{Value: last.SortValue(c.sort), ID: last.ID}
+		}
+
+		if got := strings.Join(pages, ","); got != c.pages {
+			t.Errorf("case %d: expected pages %s got %s", i+1, c.pages, got)
+		}
+	}
+}
+
+func TestRevision(t *testing.T) {
+	reset(t)
+
+	repo := db.NewChannelRepository()
+	repo.Save(models.Channel{ID: "c1", Revision: 1})
+
+	// Channel saved before revisions were introduced has none
+	if err := session.DB(testDb).C("channels").Insert(bson.M{"id": "c2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		id       string
+		revision int64
+		err      error
+	}{
+		{"c1", 1, nil},
+		{"c1", 1, models.ErrConflict},
+		{"c1", 2, nil},
+		{"c2", 0, nil},
+		{"c2", 0, models.ErrConflict},
+		{"c3", 0, models.ErrNotFound},
+	}
+
+	for i, c := range cases {
+		err := repo.Update(models.Channel{ID: c.id, Revision: c.revision})
+		if err != c.err {
+			t.Errorf("case %d: expected error %v got %v", i+1, c.err, err)
+		}
+	}
+
+	if ch, _ := repo.One("c1"); ch.Revision != 3 {
+		t.Errorf("expected revision 3 got %d", ch.Revision)
+	}
+
+	if err := repo.Remove("c2", 0); err != models.ErrConflict {
+		t.Errorf("expected error %v got %v", models.ErrConflict, err)
+	}
+	if err := repo.Remove("c2", 1); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+}
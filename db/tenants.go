@@ -0,0 +1,69 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package db
+
+import (
+	"github.com/mainflux/mainflux-core/models"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const tenantsCollection = "tenants"
+
+type tenantRepository struct{}
+
+var _ models.TenantRepository = (*tenantRepository)(nil)
+
+// NewTenantRepository instantiates MongoDB backed tenant repository.
+func NewTenantRepository() models.TenantRepository {
+	return instrumentedTenants{&tenantRepository{}}
+}
+
+func (tr *tenantRepository) Save(t models.Tenant) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(tenantsCollection).Insert(t)
+	if mgo.IsDup(err) {
+		return models.ErrConflict
+	}
+
+	return err
+}
+
+func (tr *tenantRepository) One(id string) (models.Tenant, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	t := models.Tenant{}
+	err := Db.C(tenantsCollection).Find(bson.M{"id": id}).One(&t)
+	return t, translateError(err)
+}
+
+func (tr *tenantRepository) All() ([]models.Tenant, error) {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	results := []models.Tenant{}
+	err := Db.C(tenantsCollection).Find(nil).Sort("id").All(&results)
+	return results, err
+}
+
+func (tr *tenantRepository) Remove(id string) error {
+	Db := MgoDb{}
+	Db.Init()
+	defer Db.Close()
+
+	err := Db.C(tenantsCollection).Remove(bson.M{"id": id})
+	return translateError(err)
+}
@@ -0,0 +1,98 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package events defines provisioning events the core publishes on NATS
+// when devices and channels change. Other services import it to decode
+// the events.
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Version of the event schema. It is incremented on incompatible
+// changes only, while new fields may be added within a version.
+const Version = 1
+
+// DefaultSubject is the default prefix of event subjects.
+const DefaultSubject = "mainflux.core.events"
+
+// Resources events are published for
+const (
+	ResourceDevice  = "device"
+	ResourceChannel = "channel"
+)
+
+// Event types
+const (
+	Created   = "created"
+	Updated   = "updated"
+	Deleted   = "deleted"
+	Plugged   = "plugged"
+	Unplugged = "unplugged"
+)
+
+// Event is the envelope of all the events. Before and After hold the
+// JSON representation of the resource (i.e. models.Device or
+// models.Channel) before and after the change. Before is empty for
+// created resources, and After for deleted ones.
+type Event struct {
+	Version  int    `json:"version"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Resource string `json:"resource"`
+	// ResourceID is ID of the device or channel
+	ResourceID string `json:"resource_id"`
+	Tenant     string `json:"tenant,omitempty"`
+	// Actor is ID of the authenticated principal that made the change,
+	// empty without authentication
+	Actor     string `json:"actor,omitempty"`
+	Timestamp string `json:"timestamp"`
+
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// New creates event of the type about the resource, with the states
+// of the resource before and after the change, either of which may be
+// nil.
+func New(typ, resource, id string, before, after interface{}) (Event, error) {
+	e := Event{
+		Version:    Version,
+		ID:         uuid.NewV4().String(),
+		Type:       typ,
+		Resource:   resource,
+		ResourceID: id,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	var err error
+	if before != nil {
+		if e.Before, err = json.Marshal(before); err != nil {
+			return e, err
+		}
+	}
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+// Subject returns NATS subject the event is published on, e.g.
+// `mainflux.core.events.device.updated` for the default prefix.
+// Subscribers use wildcards, e.g. `mainflux.core.events.device.*`
+// or `mainflux.core.events.>`.
+func Subject(prefix string, e Event) string {
+	return prefix + "." + e.Resource + "." + e.Type
+}
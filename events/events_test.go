@@ -0,0 +1,67 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package events_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/events"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+func TestNew(t *testing.T) {
+	before := models.Device{ID: "d1", Name: "old"}
+	after := models.Device{ID: "d1", Name: "new"}
+
+	cases := []struct {
+		typ     string
+		before  interface{}
+		after   interface{}
+		subject string
+	}{
+		{events.Created, nil, after, "mainflux.core.events.device.created"},
+		{events.Updated, before, after, "mainflux.core.events.device.updated"},
+		{events.Deleted, before, nil, "mainflux.core.events.device.deleted"},
+	}
+
+	for i, c := range cases {
+		e, err := events.New(c.typ, events.ResourceDevice, "d1", c.before, c.after)
+		if err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+
+		if s := events.Subject(events.DefaultSubject, e); s != c.subject {
+			t.Errorf("case %d: expected subject %s got %s", i+1, c.subject, s)
+		}
+
+		// Envelope survives the round trip
+		b, _ := json.Marshal(e)
+		var decoded events.Event
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Errorf("case %d: %s", i+1, err.Error())
+			continue
+		}
+		if decoded.Version != events.Version || decoded.ResourceID != "d1" || len(decoded.ID) == 0 {
+			t.Errorf("case %d: unexpected envelope %+v", i+1, decoded)
+		}
+		if (c.before == nil) != (len(decoded.Before) == 0) || (c.after == nil) != (len(decoded.After) == 0) {
+			t.Errorf("case %d: unexpected before/after %s/%s", i+1, decoded.Before, decoded.After)
+		}
+
+		if c.after != nil {
+			var d models.Device
+			json.Unmarshal(decoded.After, &d)
+			if d.Name != "new" {
+				t.Errorf("case %d: expected name new got %s", i+1, d.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package ingest writes the received messages by a pool of workers,
+// which batch the records of many messages into bulk inserts.
+package ingest
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mainflux/mainflux-core/metrics"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Reasons of batch flushes, used as `reason` label values
+const (
+	reasonSize     = "size"
+	reasonInterval = "interval"
+	reasonClose    = "close"
+)
+
+var (
+	batches = metrics.NewCounterVec("mainflux_ingest_batches_total",
+		"Number of batches written, by flush reason.", "reason")
+	batchRecords = metrics.NewHistogramVec("mainflux_ingest_batch_records",
+		"Number of records per written batch.", []float64{1, 10, 50, 100, 250, 500, 1000})
+	blocked = metrics.NewCounterVec("mainflux_ingest_blocked_total",
+		"Number of messages submitted while the worker queue was full.")
+
+	// queued is updated on submit and on receipt by the workers
+	queued int64
+
+	_ = metrics.NewGaugeFunc("mainflux_ingest_queued",
+		"Number of messages waiting for the workers.",
+		func() float64 { return float64(atomic.LoadInt64(&queued)) })
+)
+
+// Config sizes the pool.
+type Config struct {
+	// Workers is the number of workers.
+	Workers int
+	// QueueSize is the number of messages waiting per worker, beyond
+	// which Submit blocks.
+	QueueSize int
+	// BatchSize is the number of records which flushes the batch.
+	BatchSize int
+	// FlushInterval flushes the batches which did not fill up.
+	FlushInterval time.Duration
+}
+
+// SaveFunc stores the records in bulk, and flags the ones skipped as
+// duplicates.
+type SaveFunc func(...models.Message) ([]bool, error)
+
+// FlushedFunc is called by the worker once all the messages of its
+// batch are done, e.g. to complete in bulk what they left behind.
+type FlushedFunc func()
+
+// Message is a received message.
+type Message struct {
+	// Key orders the messages. Messages of the same key are handled
+	// by the same worker, in order of submission.
+	Key string
+	// Prepare turns the message into the records to be written.
+	// Messages failing to prepare are dropped, so Prepare reports
+	// the failure itself.
+	Prepare func() ([]models.Message, error)
+	// Done is called once the records are written, or failed to. dup
+	// tells that all of them were skipped as duplicates.
+	Done func(dup bool, err error)
+}
+
+// Pool writes the submitted messages by the workers, each batching the
+// records of its messages until the batch fills up or the flush
+// interval elapses.
+type Pool struct {
+	cfg     Config
+	save    SaveFunc
+	flushed FlushedFunc
+	queues  []chan Message
+
+	wg    sync.WaitGroup
+	close sync.Once
+	done  chan struct{}
+}
+
+// NewPool creates pool and starts its workers. flushed may be nil.
+func NewPool(cfg Config, save SaveFunc, flushed FlushedFunc) *Pool {
+	p := &Pool{
+		cfg:     cfg,
+		save:    save,
+		flushed: flushed,
+		queues:  make([]chan Message, cfg.Workers),
+		done:    make(chan struct{}),
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan Message, cfg.QueueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+
+	go func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+
+	return p
+}
+
+// Submit queues the message for its worker. Submit blocks while the
+// queue is full, which pushes back on the message source.
+func (p *Pool) Submit(m Message) {
+	q := p.queues[p.shard(m.Key)]
+	atomic.AddInt64(&queued, 1)
+
+	select {
+	case q <- m:
+		return
+	default:
+	}
+
+	blocked.Inc()
+	q <- m
+}
+
+// Close stops the workers once they write the queued messages, and
+// waits for them until ctx is done. Messages must not be submitted
+// after Close.
+func (p *Pool) Close(ctx context.Context) error {
+	p.close.Do(func() {
+		for _, q := range p.queues {
+			close(q)
+		}
+	})
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shard returns index of the worker handling the key.
+func (p *Pool) shard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// batch holds the messages of a worker waiting to be written, and
+// their records. Records of the message i start at offsets[i].
+type batch struct {
+	msgs    []Message
+	offsets []int
+	records []models.Message
+}
+
+func (p *Pool) work(q <-chan Message) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	b := &batch{}
+	for {
+		select {
+		case m, ok := <-q:
+			if !ok {
+				p.flush(b, reasonClose)
+				return
+			}
+			atomic.AddInt64(&queued, -1)
+
+			records, err := m.Prepare()
+			if err != nil {
+				continue
+			}
+
+			b.msgs = append(b.msgs, m)
+			b.offsets = append(b.offsets, len(b.records))
+			b.records = append(b.records, records...)
+
+			if len(b.records) >= p.cfg.BatchSize {
+				p.flush(b, reasonSize)
+			}
+		case <-ticker.C:
+			p.flush(b, reasonInterval)
+		}
+	}
+}
+
+// flush writes the batch and tells its messages the outcome.
+func (p *Pool) flush(b *batch, reason string) {
+	if len(b.msgs) == 0 {
+		return
+	}
+
+	var dups []bool
+	var err error
+	if len(b.records) > 0 {
+		dups, err = p.save(b.records...)
+		batches.Inc(reason)
+		batchRecords.Observe(float64(len(b.records)))
+	}
+
+	for i, m := range b.msgs {
+		if m.Done == nil {
+			continue
+		}
+
+		end := len(b.records)
+		if i+1 < len(b.offsets) {
+			end = b.offsets[i+1]
+		}
+
+		dup := err == nil && end > b.offsets[i]
+		for j := b.offsets[i]; dup && j < end; j++ {
+			dup = dups[j]
+		}
+
+		m.Done(dup, err)
+	}
+
+	if p.flushed != nil {
+		p.flushed()
+	}
+
+	b.msgs = b.msgs[:0]
+	b.offsets = b.offsets[:0]
+	b.records = b.records[:0]
+}
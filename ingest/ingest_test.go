@@ -0,0 +1,160 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package ingest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/ingest"
+	"github.com/mainflux/mainflux-core/memory"
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// recorder stores the records in memory, counting the bulk inserts.
+type recorder struct {
+	mu    sync.Mutex
+	repo  models.MessageRepository
+	saves int
+	delay time.Duration
+}
+
+func newRecorder(delay time.Duration) *recorder {
+	return &recorder{repo: memory.NewMessageRepository(), delay: delay}
+}
+
+func (r *recorder) save(msgs ...models.Message) ([]bool, error) {
+	r.mu.Lock()
+	r.saves++
+	r.mu.Unlock()
+
+	// Round trip to the database
+	time.Sleep(r.delay)
+	return r.repo.SaveBatch(msgs...)
+}
+
+// message creates message of records named after the keys, which
+// reports the outcome on done.
+func message(done chan<- string, id string, keys ...string) ingest.Message {
+	return ingest.Message{
+		Key: "channel",
+		Prepare: func() ([]models.Message, error) {
+			if len(keys) == 0 {
+				return nil, errors.New("invalid message")
+			}
+			records := []models.Message{}
+			for _, k := range keys {
+				records = append(records, models.Message{Channel: "channel", Name: k, Key: k})
+			}
+			return records, nil
+		},
+		Done: func(dup bool, err error) {
+			done <- fmt.Sprintf("%s:%t:%v", id, dup, err)
+		},
+	}
+}
+
+func TestPool(t *testing.T) {
+	r := newRecorder(0)
+	done := make(chan string, 10)
+	flushed := func() { done <- "flushed" }
+	p := ingest.NewPool(ingest.Config{Workers: 1, QueueSize: 10, BatchSize: 3, FlushInterval: time.Hour}, r.save, flushed)
+
+	p.Submit(message(done, "m1", "a1", "a2"))
+	// Fills the batch up
+	p.Submit(message(done, "m2", "a3"))
+	// Redelivered
+	p.Submit(message(done, "m3", "a1"))
+	// Failing to prepare
+	p.Submit(message(done, "m4"))
+	p.Submit(message(done, "m5", "a2", "a4"))
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	close(done)
+
+	results := []string{}
+	for res := range done {
+		results = append(results, res)
+	}
+	expected := "m1:false:<nil>,m2:false:<nil>,flushed,m3:true:<nil>,m5:false:<nil>,flushed"
+	if got := strings.Join(results, ","); got != expected {
+		t.Errorf("expected %s got %s", expected, got)
+	}
+
+	if r.saves != 2 {
+		t.Errorf("expected 2 bulk inserts got %d", r.saves)
+	}
+
+	msgs, _ := r.repo.ByChannel("channel", -1, math.MaxFloat64)
+	names := []string{}
+	for _, m := range msgs {
+		names = append(names, m.Name)
+	}
+	if got := strings.Join(names, ","); got != "a1,a2,a3,a4" {
+		t.Errorf("expected a1,a2,a3,a4 written got %s", got)
+	}
+}
+
+func TestPoolFlushInterval(t *testing.T) {
+	r := newRecorder(0)
+	p := ingest.NewPool(ingest.Config{Workers: 2, QueueSize: 10, BatchSize: 100, FlushInterval: 10 * time.Millisecond}, r.save, nil)
+	defer p.Close(context.Background())
+
+	done := make(chan string, 1)
+	p.Submit(message(done, "m1", "a1"))
+
+	select {
+	case res := <-done:
+		if res != "m1:false:<nil>" {
+			t.Errorf("expected m1 written got %s", res)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected batch to be flushed by interval")
+	}
+}
+
+// BenchmarkPool writes messages of 10 records each with a simulated
+// database round trip of 200µs per insert. One worker writing every
+// message at once is how NATS messages were written synchronously.
+func BenchmarkPool(b *testing.B) {
+	configs := []struct {
+		name string
+		cfg  ingest.Config
+	}{
+		{"workers=1/batch=1", ingest.Config{Workers: 1, QueueSize: 1024, BatchSize: 1, FlushInterval: time.Second}},
+		{"workers=4/batch=1", ingest.Config{Workers: 4, QueueSize: 1024, BatchSize: 1, FlushInterval: time.Second}},
+		{"workers=1/batch=500", ingest.Config{Workers: 1, QueueSize: 1024, BatchSize: 500, FlushInterval: 100 * time.Millisecond}},
+		{"workers=4/batch=500", ingest.Config{Workers: 4, QueueSize: 1024, BatchSize: 500, FlushInterval: 100 * time.Millisecond}},
+	}
+
+	records := make([]models.Message, 10)
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			r := newRecorder(200 * time.Microsecond)
+			p := ingest.NewPool(c.cfg, r.save, nil)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Submit(ingest.Message{
+					Key:     fmt.Sprintf("channel%d", i%16),
+					Prepare: func() ([]models.Message, error) { return records, nil },
+				})
+			}
+			p.Close(context.Background())
+		})
+	}
+}
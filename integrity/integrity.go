@@ -0,0 +1,395 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package integrity checks and repairs references between devices,
+// channels and messages, which are stored denormalized.
+package integrity
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Kinds of integrity issues
+const (
+	// DanglingChannel is a device referencing a channel that does not exist.
+	DanglingChannel = "dangling_channel"
+	// DanglingDevice is a channel referencing a device that does not exist.
+	DanglingDevice = "dangling_device"
+	// OneSidedDevice is a device referencing a channel that does not
+	// reference the device back.
+	OneSidedDevice = "one_sided_device"
+	// OneSidedChannel is a channel referencing a device that does not
+	// reference the channel back.
+	OneSidedChannel = "one_sided_channel"
+	// OrphanedMessages are messages published on a deleted channel.
+	OrphanedMessages = "orphaned_messages"
+	// CrossTenantLink is a device linked with a channel of another
+	// tenant. It is left to be fixed manually.
+	CrossTenantLink = "cross_tenant_link"
+)
+
+// pageSize is the number of resources fetched at once while scanning.
+const pageSize = 1000
+
+var (
+	// errObsolete is returned by repairs of the issues gone since
+	// the scan.
+	errObsolete = errors.New("issue gone in the meantime")
+	// errCrossTenant is returned by repairs that would link resources
+	// of different tenants.
+	errCrossTenant = errors.New("link crosses tenants")
+)
+
+type (
+	// Options specifies what the checker does with the issues it finds.
+	Options struct {
+		// Repair fixes the issues found.
+		Repair bool
+		// DryRun only reports what Repair would do.
+		DryRun bool
+	}
+
+	// Issue is a single integrity violation.
+	Issue struct {
+		Kind string `json:"kind"`
+		// ID of the device or channel holding the broken reference,
+		// or of the deleted channel the messages were published on.
+		ID string `json:"id"`
+		// Ref is the referenced ID.
+		Ref string `json:"ref,omitempty"`
+		// Count is the number of orphaned messages.
+		Count int `json:"count,omitempty"`
+		// Repair describes the action taken (or planned, in dry run).
+		Repair string `json:"repair,omitempty"`
+		// Repaired is set once the repair succeeded.
+		Repaired bool `json:"repaired"`
+		// Obsolete is set if the issue was gone by the time of repair,
+		// e.g. fixed by a concurrent change.
+		Obsolete bool `json:"obsolete,omitempty"`
+		// Error reports failed repair.
+		Error string `json:"error,omitempty"`
+	}
+
+	// Report is the outcome of an integrity check.
+	Report struct {
+		Devices  int     `json:"devices"`
+		Channels int     `json:"channels"`
+		Messages int     `json:"messages"`
+		Repair   bool    `json:"repair"`
+		DryRun   bool    `json:"dry_run"`
+		Issues   []Issue `json:"issues"`
+	}
+
+	// node is a device or channel, with the IDs it is linked with.
+	node struct {
+		tenant string
+		links  []string
+	}
+
+	// Checker checks integrity of the data held by the repositories.
+	Checker struct {
+		devices  models.DeviceRepository
+		channels models.ChannelRepository
+		messages models.MessageRepository
+	}
+)
+
+// NewChecker instantiates integrity checker.
+func NewChecker(dr models.DeviceRepository, cr models.ChannelRepository,
+	mr models.MessageRepository) *Checker {
+	return &Checker{devices: dr, channels: cr, messages: mr}
+}
+
+// Unrepaired returns the number of issues that are not repaired.
+func (r Report) Unrepaired() int {
+	n := 0
+	for _, i := range r.Issues {
+		if !i.Repaired && !i.Obsolete {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Check scans devices, channels and messages and reports broken
+// references. With Repair option, dangling references are removed,
+// one-sided links are completed and orphaned messages are removed.
+//
+// Data is not locked while scanning, so changes made concurrently
+// with the check may show up as false positives. Messages are counted
+// before channels are scanned, so that channels created in between are
+// not taken for deleted ones, and every issue is verified once more
+// right before it is repaired.
+func (c *Checker) Check(opts Options) (Report, error) {
+	report := Report{Repair: opts.Repair, DryRun: opts.DryRun, Issues: []Issue{}}
+
+	counts, err := c.messages.CountByChannel()
+	if err != nil {
+		return report, err
+	}
+
+	devices, err := c.deviceLinks()
+	if err != nil {
+		return report, err
+	}
+
+	channels, err := c.channelLinks()
+	if err != nil {
+		return report, err
+	}
+
+	report.Devices, report.Channels = len(devices), len(channels)
+
+	for _, did := range sortedKeys(devices) {
+		d := devices[did]
+		for _, cid := range d.links {
+			ch, ok := channels[cid]
+			switch {
+			case !ok:
+				report.Issues = append(report.Issues, Issue{
+					Kind: DanglingChannel, ID: did, Ref: cid,
+					Repair: "unplug channel from device",
+				})
+			case ch.tenant != d.tenant:
+				report.Issues = append(report.Issues, Issue{
+					Kind: CrossTenantLink, ID: did, Ref: cid,
+				})
+			case !contains(ch.links, did):
+				report.Issues = append(report.Issues, Issue{
+					Kind: OneSidedDevice, ID: did, Ref: cid,
+					Repair: "plug device into channel",
+				})
+			}
+		}
+	}
+
+	for _, cid := range sortedKeys(channels) {
+		ch := channels[cid]
+		for _, did := range ch.links {
+			d, ok := devices[did]
+			switch {
+			case !ok:
+				report.Issues = append(report.Issues, Issue{
+					Kind: DanglingDevice, ID: cid, Ref: did,
+					Repair: "unplug device from channel",
+				})
+			case d.tenant != ch.tenant:
+				// Reported from the device side, unless one-sided
+				if !contains(d.links, cid) {
+					report.Issues = append(report.Issues, Issue{
+						Kind: CrossTenantLink, ID: did, Ref: cid,
+					})
+				}
+			case !contains(d.links, cid):
+				report.Issues = append(report.Issues, Issue{
+					Kind: OneSidedChannel, ID: cid, Ref: did,
+					Repair: "plug channel into device",
+				})
+			}
+		}
+	}
+
+	channelIDs := make([]string, 0, len(counts))
+	for cid, n := range counts {
+		report.Messages += n
+		channelIDs = append(channelIDs, cid)
+	}
+	sort.Strings(channelIDs)
+
+	for _, cid := range channelIDs {
+		if _, ok := channels[cid]; !ok {
+			report.Issues = append(report.Issues, Issue{
+				Kind: OrphanedMessages, ID: cid, Count: counts[cid],
+				Repair: "remove messages",
+			})
+		}
+	}
+
+	if opts.Repair && !opts.DryRun {
+		for i := range report.Issues {
+			issue := &report.Issues[i]
+			if len(issue.Repair) == 0 {
+				continue
+			}
+
+			switch err := c.repair(*issue); err {
+			case nil:
+				issue.Repaired = true
+			case errObsolete:
+				issue.Obsolete = true
+			default:
+				issue.Error = err.Error()
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// repair fixes a single issue, once verified against the current
+// data. All the repairs are idempotent, but removals and links made
+// on stale data could destroy or cross-link live resources.
+func (c *Checker) repair(i Issue) error {
+	var err error
+	switch i.Kind {
+	case DanglingChannel:
+		if err = c.missingChannel(i.Ref); err == nil {
+			err = c.devices.Unplug(i.ID, i.Ref)
+		}
+	case DanglingDevice:
+		if err = c.missingDevice(i.Ref); err == nil {
+			err = c.channels.Unplug(i.ID, i.Ref)
+		}
+	case OneSidedDevice:
+		if err = c.oneSided(i.ID, i.Ref, true); err == nil {
+			err = c.channels.Plug(i.Ref, i.ID)
+		}
+	case OneSidedChannel:
+		if err = c.oneSided(i.Ref, i.ID, false); err == nil {
+			err = c.devices.Plug(i.Ref, i.ID)
+		}
+	case OrphanedMessages:
+		if err = c.missingChannel(i.ID); err == nil {
+			_, err = c.messages.RemoveByChannel(i.ID)
+		}
+	}
+
+	return err
+}
+
+// missingChannel verifies that the channel does not exist.
+func (c *Checker) missingChannel(id string) error {
+	_, err := c.channels.One(id)
+	return missing(err)
+}
+
+// missingDevice verifies that the device does not exist.
+func (c *Checker) missingDevice(id string) error {
+	_, err := c.devices.One(id)
+	return missing(err)
+}
+
+// missing maps the error of fetching a resource onto nil if it is
+// missing, and errObsolete if it exists.
+func missing(err error) error {
+	switch err {
+	case models.ErrNotFound:
+		return nil
+	case nil:
+		return errObsolete
+	}
+
+	return err
+}
+
+// oneSided function
+// Verifies that the device and the channel of the same tenant are
+// linked one-sidedly, by the device if byDevice is set, and by the
+// channel otherwise.
+func (c *Checker) oneSided(did, cid string, byDevice bool) error {
+	d, err := c.devices.One(did)
+	if err != nil {
+		return obsolete(err)
+	}
+
+	ch, err := c.channels.One(cid)
+	if err != nil {
+		return obsolete(err)
+	}
+
+	if contains(d.Channels, cid) != byDevice || contains(ch.Devices, did) == byDevice {
+		return errObsolete
+	}
+	if d.Tenant != ch.Tenant {
+		return errCrossTenant
+	}
+
+	return nil
+}
+
+// obsolete maps the error of fetching a resource that has to exist
+// onto errObsolete if it is missing.
+func obsolete(err error) error {
+	if err == models.ErrNotFound {
+		return errObsolete
+	}
+
+	return err
+}
+
+// deviceLinks maps all the device IDs onto their tenants and channels.
+func (c *Checker) deviceLinks() (map[string]node, error) {
+	links := make(map[string]node)
+	q := models.Query{Limit: pageSize, Sort: models.SortCreated}
+
+	for {
+		page, _, err := c.devices.All(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range page {
+			links[d.ID] = node{tenant: d.Tenant, links: d.Channels}
+		}
+
+		if len(page) < pageSize {
+			return links, nil
+		}
+
+		last := page[len(page)-1]
+		q.Cursor = &models.Cursor{Value: last.SortValue(q.Sort), ID: last.ID}
+	}
+}
+
+// channelLinks maps all the channel IDs onto their tenants and devices.
+func (c *Checker) channelLinks() (map[string]node, error) {
+	links := make(map[string]node)
+	q := models.Query{Limit: pageSize, Sort: models.SortCreated}
+
+	for {
+		page, _, err := c.channels.All(q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ch := range page {
+			links[ch.ID] = node{tenant: ch.Tenant, links: ch.Devices}
+		}
+
+		if len(page) < pageSize {
+			return links, nil
+		}
+
+		last := page[len(page)-1]
+		q.Cursor = &models.Cursor{Value: last.SortValue(q.Sort), ID: last.ID}
+	}
+}
+
+func sortedKeys(m map[string]node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func contains(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a log file rotated once it grows over the maximal size.
+// Rotated files are named <path>.1, <path>.2 and so on, <path>.1
+// being the most recent one.
+type File struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+// OpenFile opens (or creates) the log file for appending. Zero maxSize
+// disables rotation.
+func OpenFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	lf := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	lf.f, lf.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p does not fit.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Close()
+}
+
+func (lf *File) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+
+	if lf.maxBackups > 0 {
+		// The oldest backup is overwritten
+		for i := lf.maxBackups - 1; i > 0; i-- {
+			os.Rename(backupName(lf.path, i), backupName(lf.path, i+1))
+		}
+		if err := os.Rename(lf.path, backupName(lf.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(lf.path); err != nil {
+		return err
+	}
+
+	return lf.open()
+}
+
+func backupName(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
@@ -0,0 +1,274 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package logging implements leveled logging of structured entries,
+// written either as text or as JSON lines.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels, from the most verbose one
+const (
+	TraceLevel Level = iota
+	DebugLevel
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < TraceLevel || l > ErrorLevel {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+
+	return levelNames[l]
+}
+
+// ParseLevel parses level name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+type (
+	// Config specifies logger behaviour.
+	Config struct {
+		Level Level
+		// JSON switches output from text to JSON lines.
+		JSON bool
+		// Timestamps prepends entries with time.
+		Timestamps bool
+		// File is written instead of stderr, if set.
+		File string
+		// MaxSize is the size in bytes at which the file is rotated.
+		// Zero disables rotation.
+		MaxSize int64
+		// MaxBackups is the number of rotated files kept.
+		MaxBackups int
+	}
+
+	// Logger writes entries of at least its level to the output.
+	Logger struct {
+		out    *output
+		fields []interface{}
+	}
+
+	// output is shared by logger and all the loggers derived from it.
+	output struct {
+		mu         sync.Mutex
+		w          io.Writer
+		level      Level
+		json       bool
+		timestamps bool
+	}
+)
+
+var std = &Logger{out: &output{w: os.Stderr, level: InfoLevel, timestamps: true}}
+
+// Configure sets up the default logger.
+func Configure(cfg Config) error {
+	var w io.Writer = os.Stderr
+	if len(cfg.File) > 0 {
+		f, err := OpenFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	std.out.mu.Lock()
+	defer std.out.mu.Unlock()
+
+	if c, ok := std.out.w.(io.Closer); ok && std.out.w != os.Stderr {
+		c.Close()
+	}
+
+	std.out.w = w
+	std.out.level = cfg.Level
+	std.out.json = cfg.JSON
+	std.out.timestamps = cfg.Timestamps
+	return nil
+}
+
+// New creates logger writing to w.
+func New(w io.Writer, cfg Config) *Logger {
+	return &Logger{out: &output{
+		w:          w,
+		level:      cfg.Level,
+		json:       cfg.JSON,
+		timestamps: cfg.Timestamps,
+	}}
+}
+
+// Default returns the default logger.
+func Default() *Logger {
+	return std
+}
+
+// With returns logger which adds the key-value pairs to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled reports whether entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	return level >= l.out.level
+}
+
+// Trace logs entry at trace level.
+func (l *Logger) Trace(msg string, kv ...interface{}) { l.log(TraceLevel, msg, kv) }
+
+// Debug logs entry at debug level.
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(DebugLevel, msg, kv) }
+
+// Info logs entry at info level.
+func (l *Logger) Info(msg string, kv ...interface{}) { l.log(InfoLevel, msg, kv) }
+
+// Warn logs entry at warn level.
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.log(WarnLevel, msg, kv) }
+
+// Error logs entry at error level.
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(ErrorLevel, msg, kv) }
+
+// With returns default logger which adds the key-value pairs to every entry.
+func With(kv ...interface{}) *Logger { return std.With(kv...) }
+
+// Enabled reports whether the default logger writes entries of the level.
+func Enabled(level Level) bool { return std.Enabled(level) }
+
+// Trace logs entry at trace level with the default logger.
+func Trace(msg string, kv ...interface{}) { std.log(TraceLevel, msg, kv) }
+
+// Debug logs entry at debug level with the default logger.
+func Debug(msg string, kv ...interface{}) { std.log(DebugLevel, msg, kv) }
+
+// Info logs entry at info level with the default logger.
+func Info(msg string, kv ...interface{}) { std.log(InfoLevel, msg, kv) }
+
+// Warn logs entry at warn level with the default logger.
+func Warn(msg string, kv ...interface{}) { std.log(WarnLevel, msg, kv) }
+
+// Error logs entry at error level with the default logger.
+func Error(msg string, kv ...interface{}) { std.log(ErrorLevel, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	o := l.out
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if level < o.level {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	// Odd key-value pair list gets its last value without a key
+	if len(fields)%2 != 0 {
+		fields = append(fields[:len(fields)-1], "!BADKEY", fields[len(fields)-1])
+	}
+
+	var buf bytes.Buffer
+	if o.json {
+		writeJSON(&buf, o.timestamps, level, msg, fields)
+	} else {
+		writeText(&buf, o.timestamps, level, msg, fields)
+	}
+
+	o.w.Write(buf.Bytes())
+}
+
+func writeText(buf *bytes.Buffer, ts bool, level Level, msg string, fields []interface{}) {
+	if ts {
+		buf.WriteString(time.Now().UTC().Format(time.RFC3339Nano))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(strings.ToUpper(level.String()))
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteByte(' ')
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+
+		v := value(fields[i+1])
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		if len(s) == 0 || strings.ContainsAny(s, " \"=\n\t") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+}
+
+func writeJSON(buf *bytes.Buffer, ts bool, level Level, msg string, fields []interface{}) {
+	entry := make(map[string]interface{}, len(fields)/2+3)
+	for i := 0; i < len(fields); i += 2 {
+		entry[fmt.Sprint(fields[i])] = value(fields[i+1])
+	}
+	if ts {
+		entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"level": level.String(),
+			"msg":   msg,
+			"error": "cannot encode log entry: " + err.Error(),
+		})
+	}
+	buf.Write(b)
+	buf.WriteByte('\n')
+}
+
+// value converts errors and byte slices, which are not
+// encoded meaningfully by default, into strings.
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case []byte:
+		return string(t)
+	case time.Duration:
+		return t.String()
+	}
+
+	return v
+}
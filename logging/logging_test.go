@@ -0,0 +1,121 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/logging"
+)
+
+func TestLogger(t *testing.T) {
+	cases := []struct {
+		cfg      logging.Config
+		log      func(l *logging.Logger)
+		expected string
+	}{
+		{
+			logging.Config{Level: logging.InfoLevel},
+			func(l *logging.Logger) { l.Info("started", "port", 9090) },
+			"INFO started port=9090\n",
+		},
+		{
+			logging.Config{Level: logging.InfoLevel},
+			func(l *logging.Logger) { l.Debug("hidden") },
+			"",
+		},
+		{
+			logging.Config{Level: logging.TraceLevel},
+			func(l *logging.Logger) { l.Trace("payload", "data", []byte(`{"v": 1}`)) },
+			`TRACE payload data="{\"v\": 1}"` + "\n",
+		},
+		{
+			logging.Config{Level: logging.DebugLevel},
+			func(l *logging.Logger) { l.With("request_id", "r1").Error("failed", "err", errors.New("boom")) },
+			"ERROR failed request_id=r1 err=boom\n",
+		},
+		{
+			logging.Config{Level: logging.InfoLevel, JSON: true},
+			func(l *logging.Logger) { l.Warn("slow", "ms", 12) },
+			`{"level":"warn","ms":12,"msg":"slow"}` + "\n",
+		},
+	}
+
+	for i, c := range cases {
+		var buf bytes.Buffer
+		c.log(logging.New(&buf, c.cfg))
+
+		if buf.String() != c.expected {
+			t.Errorf("case %d: expected %q, got %q", i+1, c.expected, buf.String())
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := []struct {
+		name  string
+		level logging.Level
+		err   bool
+	}{
+		{"trace", logging.TraceLevel, false},
+		{"DEBUG", logging.DebugLevel, false},
+		{"error", logging.ErrorLevel, false},
+		{"verbose", logging.InfoLevel, true},
+	}
+
+	for i, c := range cases {
+		level, err := logging.ParseLevel(c.name)
+		if level != c.level || (err != nil) != c.err {
+			t.Errorf("case %d: expected %s (error %t), got %s (%v)", i+1, c.level, c.err, level, err)
+		}
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "core.log")
+	f, err := logging.OpenFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	cases := []struct {
+		file     string
+		expected string
+	}{
+		{path, "fourth\n"},
+		{path + ".1", "third\n"},
+		{path + ".2", "second\n"},
+		{path + ".3", ""},
+	}
+
+	for i, c := range cases {
+		b, _ := ioutil.ReadFile(c.file)
+		if string(b) != c.expected {
+			t.Errorf("case %d: expected %s to contain %q, got %q", i+1, c.file, c.expected, string(b))
+		}
+	}
+}
@@ -9,27 +9,52 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/auth"
+	"github.com/mainflux/mainflux-core/certs"
 	"github.com/mainflux/mainflux-core/config"
 	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/ingest"
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/outbox"
+	"github.com/mainflux/mainflux-core/wal"
 )
 
 var usageStr = `
-Usage: mainflux [options]
+Usage: mainflux [options] [command]
+Commands:
+    check [-repair] [-dry-run] [-json]
+                                     Check (and repair) references between
+                                     devices, channels and messages
+    migrate up|status                Apply pending database migrations,
+                                     or list migrations and their status
+    config print                     Print effective configuration
+    apikey create <owner> [name]|list|revoke <id>
+                                     Manage API keys
 Server Options:
     -a, --addr <host>                Bind to host address (default: 0.0.0.0)
-    -p, --port <port>                Use port for clients (default: 4222)
+    -p, --port <port>                Use port for clients (default: 7070)
     -P, --pid <file>                 File to store PID
     -c, --config <file>              Configuration file
+TLS Options:
+        --tlscert <file>             Server certificate file, enables HTTPS
+        --tlskey <file>              Private key for server certificate
+        --tlscacert <file>           CA of client certificates
+        --tlsclientauth <mode>       Client certificates: none, optional
+                                     or require (default: none)
 Logging Options:
     -l, --log <file>                 File to redirect log output
+                                     (rotated at 100MB, 5 files kept)
+        --logformat <format>         Log format: text or json (default: text)
     -T, --logtime                    Timestamp log entries (default: true)
     -D, --debug                      Enable debugging output
     -V, --trace                      Trace the raw protocol
@@ -37,8 +62,36 @@ Logging Options:
 Common Options:
     -h, --help                       Show this message
     -v, --version                    Show version
+
+Configuration is read from the file, then overridden by MF_* environment
+variables (e.g. MF_HTTP_PORT, MF_MONGO_HOST), and finally by the options.
 `
 
+// flagKeys maps options onto the configuration keys they override.
+var flagKeys = map[string]string{
+	"a":         "httpHost",
+	"addr":      "httpHost",
+	"host":      "httpHost",
+	"net":       "httpHost",
+	"p":         "httpPort",
+	"port":      "httpPort",
+	"P":         "pidFile",
+	"pid":       "pidFile",
+	"l":         "logFile",
+	"log":       "logFile",
+	"logformat": "logFormat",
+	"T":         "logTime",
+	"logtime":   "logTime",
+
+	"tlscert":       "tlsCert",
+	"tlskey":        "tlsKey",
+	"tlscacert":     "tlsClientCA",
+	"tlsclientauth": "tlsClientAuth",
+}
+
+// commands are the non-flag arguments that are not a config file.
+var commands = map[string]bool{"check": true, "migrate": true, "config": true, "apikey": true}
+
 // usage will print out the flag options for the server.
 func usage() {
 	fmt.Printf("%s\n", usageStr)
@@ -52,35 +105,36 @@ func PrintServerAndExit() {
 }
 
 func main() {
-	// Server Options
-	opts := Options{}
-
 	var showVersion bool
-	var debugAndTrace bool
+	var debug, trace, debugAndTrace bool
 	var configFile string
 
-	// Parse flags
-	flag.IntVar(&opts.Port, "port", 0, "Port to listen on.")
-	flag.IntVar(&opts.Port, "p", 0, "Port to listen on.")
-	flag.StringVar(&opts.Host, "host", "", "Network host to listen on.")
-	flag.StringVar(&opts.Host, "h", "", "Network host to listen on.")
-	flag.StringVar(&opts.Host, "net", "", "Network host to listen on.")
-	flag.BoolVar(&opts.Debug, "D", false, "Enable Debug logging.")
-	flag.BoolVar(&opts.Debug, "debug", false, "Enable Debug logging.")
-	flag.BoolVar(&opts.Trace, "V", false, "Enable Trace logging.")
-	flag.BoolVar(&opts.Trace, "trace", false, "Enable Trace logging.")
+	// Parse flags, their values are applied to the config
+	// only if they are given explicitly
+	flag.String("addr", "", "Network host to listen on.")
+	flag.String("a", "", "Network host to listen on.")
+	flag.String("host", "", "Network host to listen on.")
+	flag.String("net", "", "Network host to listen on.")
+	flag.Int("port", 0, "Port to listen on.")
+	flag.Int("p", 0, "Port to listen on.")
+	flag.BoolVar(&debug, "D", false, "Enable Debug logging.")
+	flag.BoolVar(&debug, "debug", false, "Enable Debug logging.")
+	flag.BoolVar(&trace, "V", false, "Enable Trace logging.")
+	flag.BoolVar(&trace, "trace", false, "Enable Trace logging.")
 	flag.BoolVar(&debugAndTrace, "DV", false, "Enable Debug and Trace logging.")
-	flag.BoolVar(&opts.Logtime, "T", true, "Timestamp log entries.")
-	flag.BoolVar(&opts.Logtime, "logtime", true, "Timestamp log entries.")
-	flag.StringVar(&opts.Username, "user", "", "Username required for connection.")
-	flag.StringVar(&opts.Password, "pass", "", "Password required for connection.")
-	flag.StringVar(&opts.Authorization, "auth", "", "Authorization token required for connection.")
+	flag.Bool("T", true, "Timestamp log entries.")
+	flag.Bool("logtime", true, "Timestamp log entries.")
 	flag.StringVar(&configFile, "c", "", "Configuration file.")
 	flag.StringVar(&configFile, "config", "", "Configuration file.")
-	flag.StringVar(&opts.PidFile, "P", "", "File to store process pid.")
-	flag.StringVar(&opts.PidFile, "pid", "", "File to store process pid.")
-	flag.StringVar(&opts.LogFile, "l", "", "File to store logging output.")
-	flag.StringVar(&opts.LogFile, "log", "", "File to store logging output.")
+	flag.String("P", "", "File to store process pid.")
+	flag.String("pid", "", "File to store process pid.")
+	flag.String("l", "", "File to store logging output.")
+	flag.String("log", "", "File to store logging output.")
+	flag.String("logformat", "text", "Log format, text or json.")
+	flag.String("tlscert", "", "Server certificate file.")
+	flag.String("tlskey", "", "Private key for server certificate.")
+	flag.String("tlscacert", "", "CA of client certificates.")
+	flag.String("tlsclientauth", "none", "Client certificates: none, optional or require.")
 	flag.BoolVar(&showVersion, "version", false, "Print version information.")
 	flag.BoolVar(&showVersion, "v", false, "Print version information.")
 
@@ -93,11 +147,6 @@ func main() {
 		PrintServerAndExit()
 	}
 
-	// One flag can set multiple options.
-	if debugAndTrace {
-		opts.Trace, opts.Debug = true, true
-	}
-
 	// Process args looking for non-flag options,
 	// 'version' and 'help' only for now
 	for _, arg := range flag.Args() {
@@ -109,23 +158,228 @@ func main() {
 		}
 	}
 
+	command := strings.ToLower(flag.Arg(0))
+
+	// Config file can also be given as the first argument
+	if len(configFile) == 0 && !commands[command] {
+		configFile = flag.Arg(0)
+	}
+
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			overrides[key] = f.Value.String()
+		}
+	})
+
+	// One flag can set multiple options.
+	switch {
+	case trace || debugAndTrace:
+		overrides["logLevel"] = "trace"
+	case debug:
+		overrides["logLevel"] = "debug"
+	}
+
 	// Parse config
-	var cfg config.Config
-	cfg.Parse()
+	cfg, err := config.Load(configFile, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if command == "config" {
+		os.Exit(printConfig(cfg, flag.Args()[1:]))
+	}
+
+	// Logging
+	if err := configureLogging(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 
 	// MongoDb
-	db.InitMongo(cfg.MongoHost, cfg.MongoPort, cfg.MongoDatabase)
+	if err := db.InitMongo(cfg.MongoHost, cfg.MongoPort, cfg.MongoDatabase,
+		cfg.MongoUser, cfg.MongoPassword); err != nil {
+		logging.Error("cannot connect to MongoDB", "err", err)
+		os.Exit(1)
+	}
+
+	switch command {
+	case "check":
+		os.Exit(check(flag.Args()[1:]))
+	case "migrate":
+		os.Exit(migrate(flag.Args()[1:]))
+	case "apikey":
+		os.Exit(apiKey(flag.Args()[1:]))
+	}
+
+	// Indexes and data must be up to date before serving
+	if _, err := db.Migrate(); err != nil {
+		logging.Error("cannot migrate database", "err", err)
+		os.Exit(1)
+	}
+
+	// Events and messages are published on NATS through the outbox
+	relay := outbox.NewRelay(db.NewOutboxRepository(), api.NatsPublish, api.ReconcileEvent, OutboxRelayInterval)
+
+	// API handler must be set up before NATS, as they share repositories
+	h := api.HTTPServer(db.NewDeviceRepository(), db.NewChannelRepository(),
+		db.NewMessageRepository(), db.NewTenantRepository(), db.NewAPIKeyRepository(),
+		relay.Outbox())
+
+	// Authentication
+	if cfg.AuthEnabled {
+		a, err := authenticator(cfg)
+		if err != nil {
+			logging.Error("cannot set up authentication", "err", err)
+			os.Exit(1)
+		}
+		api.SetAuthenticator(a)
+	}
+
+	// Messages are buffered on disk while MongoDB is down
+	stopReplay := make(chan struct{})
+	var buffer *wal.Log
+	if len(cfg.WALDir) > 0 {
+		var err error
+		buffer, err = wal.Open(wal.Config{
+			Dir:     cfg.WALDir,
+			MaxSize: int64(cfg.WALMaxSize) << 20,
+			Policy:  cfg.WALPolicy,
+		})
+		if err != nil {
+			logging.Error("cannot open write-ahead log", "dir", cfg.WALDir, "err", err)
+			os.Exit(1)
+		}
+		api.SetBuffer(buffer)
+		go buffer.Run(api.ReplayBuffered, WALReplayInterval, stopReplay)
+	}
 
 	// NATS
-	api.NatsInit(cfg.NatsHost, cfg.NatsPort)
+	api.SetEventsSubject(cfg.NatsEventsSubject)
+	ic := ingest.Config{
+		Workers:       cfg.IngestWorkers,
+		QueueSize:     cfg.IngestQueueSize,
+		BatchSize:     cfg.IngestBatchSize,
+		FlushInterval: time.Duration(cfg.IngestFlushInterval) * time.Millisecond,
+	}
+	if err := api.NatsInit(cfg.NatsHost, cfg.NatsPort, cfg.NatsQueueGroup, ic); err != nil {
+		logging.Error("cannot initialize NATS", "err", err)
+		os.Exit(1)
+	}
+
+	stopRelay := make(chan struct{})
+	go relay.Run(stopRelay)
+
+	// Health checks
+	api.Version = Version
+	api.RegisterHealthCheck("mongo", db.Ping)
 
 	// Print banner
 	color.Cyan(banner)
 	color.Cyan(fmt.Sprintf("Magic happens on port %d", cfg.HTTPPort))
 
 	// Serve HTTP
-	httpHost := fmt.Sprintf("%s:%d", cfg.HTTPHost, cfg.HTTPPort)
-	http.ListenAndServe(httpHost, api.HTTPServer())
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.HTTPHost, cfg.HTTPPort),
+		Handler: h,
+	}
+
+	stopWatch := make(chan struct{})
+	if len(cfg.TLSCert) > 0 {
+		rl, err := tlsReloader(cfg)
+		if err != nil {
+			logging.Error("cannot load certificates", "err", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = rl.TLSConfig()
+		go rl.Watch(CertsReloadInterval, stopWatch)
+	}
+	logging.Info("serving HTTP", "addr", srv.Addr, "tls", srv.TLSConfig != nil)
+
+	timeout := time.Duration(cfg.ShutdownTimeout) * time.Second
+	os.Exit(serve(srv, timeout, cfg.PidFile, func(ctx context.Context) error {
+		// HTTP server is shut down already
+		close(stopWatch)
+
+		// Replay appends to the outbox, so it is stopped first
+		close(stopReplay)
+		if buffer != nil {
+			if err := buffer.Wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		close(stopRelay)
+		return relay.Wait(ctx)
+	}))
+}
+
+// configureLogging sets up logging as requested by the config.
+func configureLogging(cfg config.Config) error {
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	return logging.Configure(logging.Config{
+		Level:      level,
+		JSON:       cfg.LogFormat == "json",
+		Timestamps: cfg.LogTime,
+		File:       cfg.LogFile,
+		MaxSize:    DefaultLogMaxSize,
+		MaxBackups: DefaultLogBackups,
+	})
+}
+
+// authenticator accepts API keys, and JWTs if any JWT key is set.
+func authenticator(cfg config.Config) (*auth.Authenticator, error) {
+	var v *auth.Verifier
+	if len(cfg.JWTSecret) > 0 || len(cfg.JWTPublicKey) > 0 || len(cfg.JWTJWKS) > 0 {
+		var err error
+		v, err = auth.NewVerifier(auth.JWTConfig{
+			Secret:        cfg.JWTSecret,
+			PublicKeyFile: cfg.JWTPublicKey,
+			JWKSFile:      cfg.JWTJWKS,
+			Issuer:        cfg.JWTIssuer,
+			Audience:      cfg.JWTAudience,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return auth.NewAuthenticator(v, db.NewAPIKeyRepository()), nil
+}
+
+// tlsReloader loads the certificates set in the config.
+func tlsReloader(cfg config.Config) (*certs.Reloader, error) {
+	auth, err := certs.ClientAuth(cfg.TLSClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	return certs.NewReloader(certs.Config{
+		CertFile:     cfg.TLSCert,
+		KeyFile:      cfg.TLSKey,
+		ClientCAFile: cfg.TLSClientCA,
+		ClientAuth:   auth,
+	})
+}
+
+// printConfig runs the `config print` command.
+func printConfig(cfg config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: mainflux-core config print")
+		return 2
+	}
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	return 0
 }
 
 var banner = `
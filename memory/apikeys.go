@@ -0,0 +1,97 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type apiKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]models.APIKey
+}
+
+var _ models.APIKeyRepository = (*apiKeyRepository)(nil)
+
+// NewAPIKeyRepository instantiates in-memory API key repository.
+func NewAPIKeyRepository() models.APIKeyRepository {
+	return &apiKeyRepository{
+		keys: make(map[string]models.APIKey),
+	}
+}
+
+func (kr *apiKeyRepository) Save(k models.APIKey) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys[k.ID] = k
+	return nil
+}
+
+func (kr *apiKeyRepository) ByHash(hash string) (models.APIKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, k := range kr.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+
+	return models.APIKey{}, models.ErrNotFound
+}
+
+func (kr *apiKeyRepository) All() ([]models.APIKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	all := make([]models.APIKey, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		all = append(all, k)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Created != all[j].Created {
+			return all[i].Created < all[j].Created
+		}
+		return all[i].ID < all[j].ID
+	})
+
+	return all, nil
+}
+
+func (kr *apiKeyRepository) Remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, ok := kr.keys[id]; !ok {
+		return models.ErrNotFound
+	}
+
+	delete(kr.keys, id)
+	return nil
+}
+
+func (kr *apiKeyRepository) RemoveByTenant(tenant string) (int, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	removed := 0
+	for id, v := range kr.keys {
+		if v.Tenant == tenant {
+			delete(kr.keys, id)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,162 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type channelRepository struct {
+	mu       sync.RWMutex
+	channels map[string]models.Channel
+}
+
+var _ models.ChannelRepository = (*channelRepository)(nil)
+
+// NewChannelRepository instantiates in-memory channel repository.
+func NewChannelRepository() models.ChannelRepository {
+	return &channelRepository{
+		channels: make(map[string]models.Channel),
+	}
+}
+
+func (cr *channelRepository) Save(c models.Channel) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.channels[c.ID] = cloneChannel(c)
+	return nil
+}
+
+func (cr *channelRepository) One(id string) (models.Channel, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	c, ok := cr.channels[id]
+	if !ok {
+		return models.Channel{}, models.ErrNotFound
+	}
+
+	return cloneChannel(c), nil
+}
+
+func (cr *channelRepository) All(q models.Query) ([]models.Channel, int, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	all := make([]models.Channel, 0, len(cr.channels))
+	for _, c := range cr.channels {
+		if q.Filter.MatchChannel(c) {
+			all = append(all, c)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return q.Less(all[i].SortValue(q.Sort), all[i].ID,
+			all[j].SortValue(q.Sort), all[j].ID)
+	})
+
+	results := []models.Channel{}
+	for _, c := range all {
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+		if q.After(c.SortValue(q.Sort), c.ID) {
+			results = append(results, cloneChannel(c))
+		}
+	}
+
+	return results, len(all), nil
+}
+
+func (cr *channelRepository) Update(c models.Channel) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	stored, ok := cr.channels[c.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	if stored.Revision != c.Revision {
+		return models.ErrConflict
+	}
+
+	c.Revision++
+	cr.channels[c.ID] = cloneChannel(c)
+	return nil
+}
+
+func (cr *channelRepository) Remove(id string, revision int64) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	stored, ok := cr.channels[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	if revision != models.AnyRevision && stored.Revision != revision {
+		return models.ErrConflict
+	}
+
+	delete(cr.channels, id)
+	return nil
+}
+
+func (cr *channelRepository) Plug(id string, devices ...string) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	c, ok := cr.channels[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	c.Devices = addToSet(c.Devices, devices...)
+	c.Updated = time.Now().UTC().Format(time.RFC3339)
+	c.Revision++
+	cr.channels[id] = c
+	return nil
+}
+
+func (cr *channelRepository) Unplug(id string, devices ...string) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	c, ok := cr.channels[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	c.Devices = pull(c.Devices, devices...)
+	c.Updated = time.Now().UTC().Format(time.RFC3339)
+	c.Revision++
+	cr.channels[id] = c
+	return nil
+}
+
+func (cr *channelRepository) RemoveByTenant(tenant string) (int, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	removed := 0
+	for id, v := range cr.channels {
+		if v.Tenant == tenant {
+			delete(cr.channels, id)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
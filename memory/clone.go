@@ -0,0 +1,103 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"github.com/mainflux/mainflux-core/models"
+)
+
+// Repositories hand out copies of the stored documents, so that callers
+// mutating returned values can not race with other goroutines.
+
+func cloneDevice(d models.Device) models.Device {
+	d.Channels = cloneStrings(d.Channels)
+	if d.Keys != nil {
+		d.Keys = append([]models.DeviceKey{}, d.Keys...)
+	}
+	d.Tags = cloneStrings(d.Tags)
+	d.Metadata = cloneMap(d.Metadata)
+	return d
+}
+
+func cloneChannel(c models.Channel) models.Channel {
+	c.Devices = cloneStrings(c.Devices)
+	c.Shared = cloneStrings(c.Shared)
+	c.Tags = cloneStrings(c.Tags)
+	c.Metadata = cloneMap(c.Metadata)
+	return c
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
+
+func cloneMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = cloneValue(v)
+	}
+
+	return c
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return cloneMap(t)
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i := range t {
+			c[i] = cloneValue(t[i])
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// addToSet appends values missing from the set, preserving order.
+func addToSet(set []string, values ...string) []string {
+	for _, v := range values {
+		if !contains(set, v) {
+			set = append(set, v)
+		}
+	}
+
+	return set
+}
+
+// pull removes all occurrences of values from the set.
+func pull(set []string, values ...string) []string {
+	res := []string{}
+	for _, s := range set {
+		if !contains(values, s) {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+func contains(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
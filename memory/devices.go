@@ -0,0 +1,162 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type deviceRepository struct {
+	mu      sync.RWMutex
+	devices map[string]models.Device
+}
+
+var _ models.DeviceRepository = (*deviceRepository)(nil)
+
+// NewDeviceRepository instantiates in-memory device repository.
+func NewDeviceRepository() models.DeviceRepository {
+	return &deviceRepository{
+		devices: make(map[string]models.Device),
+	}
+}
+
+func (dr *deviceRepository) Save(d models.Device) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	dr.devices[d.ID] = cloneDevice(d)
+	return nil
+}
+
+func (dr *deviceRepository) One(id string) (models.Device, error) {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+
+	d, ok := dr.devices[id]
+	if !ok {
+		return models.Device{}, models.ErrNotFound
+	}
+
+	return cloneDevice(d), nil
+}
+
+func (dr *deviceRepository) All(q models.Query) ([]models.Device, int, error) {
+	dr.mu.RLock()
+	defer dr.mu.RUnlock()
+
+	all := make([]models.Device, 0, len(dr.devices))
+	for _, d := range dr.devices {
+		if q.Filter.MatchDevice(d) {
+			all = append(all, d)
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return q.Less(all[i].SortValue(q.Sort), all[i].ID,
+			all[j].SortValue(q.Sort), all[j].ID)
+	})
+
+	results := []models.Device{}
+	for _, d := range all {
+		if q.Limit > 0 && len(results) == q.Limit {
+			break
+		}
+		if q.After(d.SortValue(q.Sort), d.ID) {
+			results = append(results, cloneDevice(d))
+		}
+	}
+
+	return results, len(all), nil
+}
+
+func (dr *deviceRepository) Update(d models.Device) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	stored, ok := dr.devices[d.ID]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	if stored.Revision != d.Revision {
+		return models.ErrConflict
+	}
+
+	d.Revision++
+	dr.devices[d.ID] = cloneDevice(d)
+	return nil
+}
+
+func (dr *deviceRepository) Remove(id string, revision int64) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	stored, ok := dr.devices[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	if revision != models.AnyRevision && stored.Revision != revision {
+		return models.ErrConflict
+	}
+
+	delete(dr.devices, id)
+	return nil
+}
+
+func (dr *deviceRepository) Plug(id string, channels ...string) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	d, ok := dr.devices[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	d.Channels = addToSet(d.Channels, channels...)
+	d.Updated = time.Now().UTC().Format(time.RFC3339)
+	d.Revision++
+	dr.devices[id] = d
+	return nil
+}
+
+func (dr *deviceRepository) Unplug(id string, channels ...string) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	d, ok := dr.devices[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+
+	d.Channels = pull(d.Channels, channels...)
+	d.Updated = time.Now().UTC().Format(time.RFC3339)
+	d.Revision++
+	dr.devices[id] = d
+	return nil
+}
+
+func (dr *deviceRepository) RemoveByTenant(tenant string) (int, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	removed := 0
+	for id, v := range dr.devices {
+		if v.Tenant == tenant {
+			delete(dr.devices, id)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,119 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sync"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type messageRepository struct {
+	mu       sync.RWMutex
+	messages []models.Message
+	keys     map[string]bool
+}
+
+var _ models.MessageRepository = (*messageRepository)(nil)
+
+// NewMessageRepository instantiates in-memory message repository.
+func NewMessageRepository() models.MessageRepository {
+	return &messageRepository{keys: make(map[string]bool)}
+}
+
+func (mr *messageRepository) Save(msgs ...models.Message) error {
+	dups, err := mr.SaveBatch(msgs...)
+	if err != nil {
+		return err
+	}
+
+	for _, dup := range dups {
+		if !dup {
+			return nil
+		}
+	}
+	if len(dups) > 0 {
+		return models.ErrConflict
+	}
+
+	return nil
+}
+
+func (mr *messageRepository) SaveBatch(msgs ...models.Message) ([]bool, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	dups := make([]bool, len(msgs))
+	for i, m := range msgs {
+		if len(m.Key) > 0 {
+			if mr.keys[m.Key] {
+				dups[i] = true
+				continue
+			}
+			mr.keys[m.Key] = true
+		}
+		mr.messages = append(mr.messages, m)
+	}
+
+	return dups, nil
+}
+
+func (mr *messageRepository) ByChannel(id string, start, end float64) ([]models.Message, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	results := []models.Message{}
+	for _, m := range mr.messages {
+		if m.Channel == id && m.Time > start && m.Time < end {
+			results = append(results, m)
+		}
+	}
+
+	return results, nil
+}
+
+func (mr *messageRepository) CountByChannel() (map[string]int, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, m := range mr.messages {
+		counts[m.Channel]++
+	}
+
+	return counts, nil
+}
+
+func (mr *messageRepository) RemoveByChannel(id string) (int, error) {
+	return mr.remove(func(m models.Message) bool { return m.Channel == id }), nil
+}
+
+func (mr *messageRepository) RemoveByTenant(tenant string) (int, error) {
+	return mr.remove(func(m models.Message) bool { return m.Tenant == tenant }), nil
+}
+
+// remove removes the matching messages along with their keys, so that
+// the keys can be written again, and returns their number.
+func (mr *messageRepository) remove(match func(models.Message) bool) int {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	kept := mr.messages[:0]
+	for _, m := range mr.messages {
+		if !match(m) {
+			kept = append(kept, m)
+			continue
+		}
+		delete(mr.keys, m.Key)
+	}
+
+	removed := len(mr.messages) - len(kept)
+	mr.messages = kept
+	return removed
+}
@@ -0,0 +1,166 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type outboxRepository struct {
+	mu      sync.Mutex
+	seq     int64
+	records []models.OutboxRecord
+	dead    []models.OutboxRecord
+
+	owner   string
+	expires time.Time
+}
+
+var _ models.OutboxRepository = (*outboxRepository)(nil)
+
+// NewOutboxRepository instantiates in-memory outbox repository.
+func NewOutboxRepository() models.OutboxRepository {
+	return &outboxRepository{}
+}
+
+func (or *outboxRepository) Append(records ...models.OutboxRecord) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	for _, r := range records {
+		or.seq++
+		r.Seq = or.seq
+		r.Data = append([]byte{}, r.Data...)
+		or.records = append(or.records, r)
+	}
+
+	return nil
+}
+
+func (or *outboxRepository) Stage(rec models.OutboxRecord) (int64, error) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	or.seq++
+	rec.Seq, rec.Staged = or.seq, true
+	rec.Data = append([]byte{}, rec.Data...)
+	or.records = append(or.records, rec)
+
+	return rec.Seq, nil
+}
+
+func (or *outboxRepository) Resolve(seq int64, rec models.OutboxRecord) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	for i := range or.records {
+		if or.records[i].Seq == seq && or.records[i].Staged {
+			rec.Seq, rec.Staged = seq, false
+			rec.Data = append([]byte{}, rec.Data...)
+			or.records[i] = rec
+			return nil
+		}
+	}
+
+	return models.ErrNotFound
+}
+
+func (or *outboxRepository) Pending(limit int) ([]models.OutboxRecord, error) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	if limit > len(or.records) {
+		limit = len(or.records)
+	}
+
+	return append([]models.OutboxRecord{}, or.records[:limit]...), nil
+}
+
+func (or *outboxRepository) Failed(seq int64) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	for i := range or.records {
+		if or.records[i].Seq == seq {
+			or.records[i].Attempts++
+			return nil
+		}
+	}
+
+	return models.ErrNotFound
+}
+
+func (or *outboxRepository) Remove(seqs ...int64) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	removed := make(map[int64]bool, len(seqs))
+	for _, s := range seqs {
+		removed[s] = true
+	}
+
+	kept := or.records[:0]
+	for _, r := range or.records {
+		if !removed[r.Seq] {
+			kept = append(kept, r)
+		}
+	}
+	or.records = kept
+
+	return nil
+}
+
+func (or *outboxRepository) Dead(seq int64) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	for i, r := range or.records {
+		if r.Seq == seq {
+			or.dead = append(or.dead, r)
+			or.records = append(or.records[:i], or.records[i+1:]...)
+			return nil
+		}
+	}
+
+	return models.ErrNotFound
+}
+
+func (or *outboxRepository) Count() (int, error) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	return len(or.records), nil
+}
+
+func (or *outboxRepository) Lease(owner string, ttl time.Duration) (bool, error) {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	now := time.Now()
+	if or.owner != owner && now.Before(or.expires) {
+		return false, nil
+	}
+
+	or.owner, or.expires = owner, now.Add(ttl)
+	return true, nil
+}
+
+func (or *outboxRepository) Release(owner string) error {
+	or.mu.Lock()
+	defer or.mu.Unlock()
+
+	if or.owner == owner {
+		or.expires = time.Time{}
+	}
+
+	return nil
+}
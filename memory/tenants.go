@@ -0,0 +1,82 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/mainflux/mainflux-core/models"
+)
+
+type tenantRepository struct {
+	mu      sync.RWMutex
+	tenants map[string]models.Tenant
+}
+
+var _ models.TenantRepository = (*tenantRepository)(nil)
+
+// NewTenantRepository instantiates in-memory tenant repository.
+func NewTenantRepository() models.TenantRepository {
+	return &tenantRepository{
+		tenants: make(map[string]models.Tenant),
+	}
+}
+
+func (tr *tenantRepository) Save(t models.Tenant) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if _, ok := tr.tenants[t.ID]; ok {
+		return models.ErrConflict
+	}
+
+	tr.tenants[t.ID] = t
+	return nil
+}
+
+func (tr *tenantRepository) One(id string) (models.Tenant, error) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	t, ok := tr.tenants[id]
+	if !ok {
+		return models.Tenant{}, models.ErrNotFound
+	}
+
+	return t, nil
+}
+
+func (tr *tenantRepository) All() ([]models.Tenant, error) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	all := make([]models.Tenant, 0, len(tr.tenants))
+	for _, t := range tr.tenants {
+		all = append(all, t)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ID < all[j].ID
+	})
+
+	return all, nil
+}
+
+func (tr *tenantRepository) Remove(id string) error {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if _, ok := tr.tenants[id]; !ok {
+		return models.ErrNotFound
+	}
+
+	delete(tr.tenants, id)
+	return nil
+}
@@ -0,0 +1,293 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package metrics implements counters, gauges and histograms
+// exposed in Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are latency histogram buckets, in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// registry holds all the metrics, by name.
+var registry = struct {
+	sync.RWMutex
+	metrics map[string]metric
+}{metrics: make(map[string]metric)}
+
+// metric is written to the exposition.
+type metric interface {
+	write(w io.Writer)
+}
+
+type (
+	// CounterVec is a set of counters partitioned by label values.
+	CounterVec struct {
+		desc
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// HistogramVec is a set of histograms partitioned by label values.
+	HistogramVec struct {
+		desc
+		buckets []float64
+		mu      sync.Mutex
+		values  map[string]*histogram
+	}
+
+	// GaugeFunc is a gauge whose value is read when metrics are collected.
+	GaugeFunc struct {
+		desc
+		f func() float64
+	}
+
+	desc struct {
+		name   string
+		help   string
+		labels []string
+	}
+
+	histogram struct {
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+// NewCounterVec creates and registers counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+	register(name, c)
+
+	return c
+}
+
+// NewHistogramVec creates and registers histogram with the given
+// buckets, or DefaultBuckets if there are none.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	register(name, h)
+
+	return h
+}
+
+// NewGaugeFunc creates and registers gauge.
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help}, f: f}
+	register(name, g)
+
+	return g
+}
+
+func register(name string, m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	registry.metrics[name] = m
+}
+
+// Inc increments counter with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// ObserveSince observes seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// key joins label values, checking that all of them are given.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d",
+			d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats labels of the series identified by key.
+func (d desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escape(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, typ)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(k), format(c.values[k]))
+	}
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hist := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", format(b)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(k), format(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(k), hist.count)
+	}
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, format(g.f()))
+}
+
+// Handler serves all the registered metrics in Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		Write(&buf)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// Write writes all the registered metrics in Prometheus text format.
+func Write(w io.Writer) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		registry.metrics[name].write(w)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func init() {
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
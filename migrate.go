@@ -0,0 +1,59 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mainflux/mainflux-core/db"
+)
+
+// migrate runs the `migrate up` and `migrate status` commands
+// and returns the exit status.
+func migrate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mainflux-core migrate up|status")
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		versions, err := db.Migrate()
+		for _, v := range versions {
+			fmt.Printf("Applied migration %d\n", v)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		if len(versions) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "status":
+		status, err := db.MigrationsStatus()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+		for _, s := range status {
+			applied := "pending"
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-25s  %s\n", s.Version, applied, s.Description)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n", args[0])
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,28 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package models
+
+type (
+	// APIKey struct
+	// Key authenticates its owner. The key itself is never stored,
+	// only its SHA-256 hash.
+	APIKey struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Owner string `json:"owner"`
+		// Tenant the owner belongs to, empty for the default tenant
+		Tenant string `json:"tenant"`
+		// Admin keys of the default tenant have access to all the
+		// tenants
+		Admin bool   `json:"admin"`
+		Hash  string `json:"-"`
+
+		Created string `json:"created"`
+	}
+)
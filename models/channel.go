@@ -26,7 +26,10 @@ type (
 	Channel struct {
 		ID          string `json:"id"`
 		Name        string `json:"name"`
-		Description string `json: "description"`
+		Description string `json:"description"`
+
+		// Tenant whose namespace the channel belongs to
+		Tenant string `json:"tenant"`
 
 		// Visibility:
 		// - private
@@ -37,12 +40,43 @@ type (
 		// Owner is whoever created the channel
 		Owner string `json:"owner"`
 
+		// Shared are the principals that may read the channel and
+		// publish on it, unless it is private
+		Shared []string `json:"shared"`
+
 		// Devices that have plugged in this channel
 		Devices []string `json:"devices"`
 
+		Tags []string `json:"tags"`
+
 		Created string `json:"created"`
 		Updated string `json:"updated"`
 
+		// Revision is incremented on every change, and is
+		// exposed as ETag for optimistic concurrency control
+		Revision int64 `json:"revision"`
+
 		Metadata map[string]interface{} `json:"metadata"`
 	}
 )
+
+// VisibleTo reports whether the principal with the given ID can read
+// the channel.
+func (c Channel) VisibleTo(id string) bool {
+	if len(c.Owner) == 0 || c.Owner == id {
+		return true
+	}
+
+	switch c.Visibility {
+	case "public":
+		return true
+	case "protected":
+		for _, s := range c.Shared {
+			if s == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -14,6 +14,9 @@ type (
 		ID   string `json:"id"`
 		Name string `json:"name"`
 
+		// Tenant whose namespace the device belongs to
+		Tenant string `json:"tenant"`
+
 		Description string `json:"description"`
 
 		Online        bool   `json:"online"`
@@ -22,9 +25,30 @@ type (
 
 		Channels []string `json:"channels"`
 
+		// Keys the device publishes with
+		Keys []DeviceKey `json:"keys"`
+
+		Tags []string `json:"tags"`
+
 		Created string `json:"created"`
 		Updated string `json:"updated"`
 
+		// Revision is incremented on every change, and is
+		// exposed as ETag for optimistic concurrency control
+		Revision int64 `json:"revision"`
+
 		Metadata map[string]interface{} `json:"metadata"`
 	}
+
+	// DeviceKey struct
+	// Only the hash of the key is stored, as the key itself is shown
+	// once, when generated. Keys replaced by rotation remain valid
+	// until they expire, so that devices can switch to the new key.
+	DeviceKey struct {
+		ID   string `json:"id"`
+		Hash string `json:"-"`
+
+		Created string `json:"created"`
+		Expires string `json:"expires,omitempty"`
+	}
 )
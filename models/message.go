@@ -76,5 +76,11 @@ type (
 
 		// Channel to which this message belongs
 		Channel string `json:"channel"`
+
+		// Tenant of the channel
+		Tenant string `json:"tenant"`
+
+		// Key identifies redelivered messages, which are stored once
+		Key string `json:"key,omitempty" bson:",omitempty"`
 	}
 )
@@ -0,0 +1,37 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package models
+
+type (
+	// OutboxRecord struct
+	// OutboxRecord is a NATS message stored along with the change it
+	// announces, and published afterwards by the outbox relay.
+	OutboxRecord struct {
+		// Seq orders the records, and is assigned on append
+		Seq int64 `json:"seq"`
+
+		// ID lets consumers drop the messages published more than once
+		ID string `json:"id"`
+
+		Subject string `json:"subject"`
+		Data    []byte `json:"data"`
+
+		// Key groups the records published in order, e.g. the
+		// events of one resource
+		Key string `json:"key"`
+
+		Created  string `json:"created"`
+		Attempts int    `json:"attempts"`
+
+		// Staged records are stored before the change they announce
+		// is made, and hold back the records of their key until
+		// resolved
+		Staged bool `json:"staged"`
+	}
+)
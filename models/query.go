@@ -0,0 +1,262 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// SortCreated orders resources by creation time.
+	SortCreated = "created"
+	// SortUpdated orders resources by last update time.
+	SortUpdated = "updated"
+	// SortName orders resources by name.
+	SortName = "name"
+)
+
+type (
+	// Query describes a single page of a resource listing.
+	// Resources are ordered by the Sort field, and by ID within
+	// equal Sort values, so that pages are stable.
+	Query struct {
+		// Limit is the maximal number of returned resources.
+		// Non-positive limit means no limit.
+		Limit int
+
+		// Sort is one of SortCreated, SortUpdated or SortName.
+		Sort string
+
+		// Desc reverses the order.
+		Desc bool
+
+		// Cursor is the position after which the page starts,
+		// or nil for the first page.
+		Cursor *Cursor
+
+		// Filter narrows down the listing.
+		Filter Filter
+	}
+
+	// Filter describes resources to be listed. All the set
+	// criteria must be met; zero value matches everything.
+	Filter struct {
+		// Name must be equal to the resource name.
+		Name string
+
+		// NameContains must be a case-insensitive substring of the name.
+		NameContains string
+
+		// Tags must all be attached to the resource.
+		Tags []string
+
+		// Online must match device connection state. Devices only.
+		Online *bool
+
+		// Tenant must match the resource tenant.
+		Tenant *string
+
+		// Metadata maps dot-separated metadata paths onto values.
+		// Values are compared as strings, numbers or booleans.
+		Metadata map[string]string
+
+		// VisibleTo restricts channels to the ones the principal with
+		// this ID can read: owned by it, without owner, public, or
+		// protected and shared with it. Channels only.
+		VisibleTo string
+
+		// Creation and update time bounds (exclusive), in RFC3339.
+		CreatedAfter  string
+		CreatedBefore string
+		UpdatedAfter  string
+		UpdatedBefore string
+	}
+
+	// Cursor is a position in an ordered listing, i.e. the
+	// Sort field value and the ID of the last seen resource.
+	Cursor struct {
+		Value string `json:"v"`
+		ID    string `json:"id"`
+	}
+)
+
+// SortValue returns the value of the device field the listing is sorted by.
+func (d Device) SortValue(field string) string {
+	return sortValue(field, d.Created, d.Updated, d.Name)
+}
+
+// SortValue returns the value of the channel field the listing is sorted by.
+func (c Channel) SortValue(field string) string {
+	return sortValue(field, c.Created, c.Updated, c.Name)
+}
+
+func sortValue(field, created, updated, name string) string {
+	switch field {
+	case SortUpdated:
+		return updated
+	case SortName:
+		return name
+	default:
+		return created
+	}
+}
+
+// After reports whether the resource with the given sort value and ID
+// comes after the cursor in the order described by the query.
+func (q Query) After(value, id string) bool {
+	if q.Cursor == nil {
+		return true
+	}
+
+	return q.Less(q.Cursor.Value, q.Cursor.ID, value, id)
+}
+
+// Less reports whether the resource identified by (v1, id1) is ordered
+// before the one identified by (v2, id2).
+func (q Query) Less(v1, id1, v2, id2 string) bool {
+	if v1 == v2 {
+		if q.Desc {
+			return id1 > id2
+		}
+		return id1 < id2
+	}
+
+	if q.Desc {
+		return v1 > v2
+	}
+	return v1 < v2
+}
+
+// MatchDevice reports whether the device meets the filter criteria.
+func (f Filter) MatchDevice(d Device) bool {
+	if f.Online != nil && *f.Online != d.Online {
+		return false
+	}
+
+	if f.Tenant != nil && *f.Tenant != d.Tenant {
+		return false
+	}
+
+	return f.match(d.Name, d.Tags, d.Metadata, d.Created, d.Updated)
+}
+
+// MatchChannel reports whether the channel meets the filter criteria.
+func (f Filter) MatchChannel(c Channel) bool {
+	if f.Online != nil {
+		return false
+	}
+
+	if f.Tenant != nil && *f.Tenant != c.Tenant {
+		return false
+	}
+
+	if len(f.VisibleTo) > 0 && !c.VisibleTo(f.VisibleTo) {
+		return false
+	}
+
+	return f.match(c.Name, c.Tags, c.Metadata, c.Created, c.Updated)
+}
+
+func (f Filter) match(name string, tags []string,
+	metadata map[string]interface{}, created, updated string) bool {
+	if len(f.Name) > 0 && f.Name != name {
+		return false
+	}
+
+	if len(f.NameContains) > 0 &&
+		!strings.Contains(strings.ToLower(name), strings.ToLower(f.NameContains)) {
+		return false
+	}
+
+	for _, t := range f.Tags {
+		found := false
+		for _, tag := range tags {
+			if tag == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for path, value := range f.Metadata {
+		if !matchValue(lookup(metadata, path), value) {
+			return false
+		}
+	}
+
+	return inRange(created, f.CreatedAfter, f.CreatedBefore) &&
+		inRange(updated, f.UpdatedAfter, f.UpdatedBefore)
+}
+
+// MetadataValues returns the values a metadata filter value can match:
+// the string itself and its numeric or boolean interpretation, if any.
+func MetadataValues(s string) []interface{} {
+	values := []interface{}{s}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		values = append(values, f)
+	}
+
+	if b, err := strconv.ParseBool(s); err == nil {
+		values = append(values, b)
+	}
+
+	return values
+}
+
+func matchValue(v interface{}, s string) bool {
+	// Numbers decoded from BSON may be integers
+	switch t := v.(type) {
+	case int:
+		v = float64(t)
+	case int64:
+		v = float64(t)
+	}
+
+	for _, candidate := range MetadataValues(s) {
+		if v == candidate {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lookup(m map[string]interface{}, path string) interface{} {
+	keys := strings.Split(path, ".")
+
+	var v interface{} = m
+	for _, k := range keys {
+		doc, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = doc[k]; !ok {
+			return nil
+		}
+	}
+
+	return v
+}
+
+func inRange(t, after, before string) bool {
+	if len(after) > 0 && t <= after {
+		return false
+	}
+
+	if len(before) > 0 && t >= before {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,198 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by repositories when the requested
+	// resource does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is returned by repositories when the resource
+	// has been modified since the given revision was read.
+	ErrConflict = errors.New("revision conflict")
+)
+
+// AnyRevision can be passed instead of a revision to skip the
+// optimistic concurrency check.
+const AnyRevision int64 = -1
+
+type (
+	// DeviceRepository specifies device persistence API.
+	DeviceRepository interface {
+		// Save persists a new device.
+		Save(Device) error
+
+		// One retrieves device by its ID.
+		One(string) (Device, error)
+
+		// All retrieves a page of devices described by the query,
+		// together with the total number of devices.
+		All(Query) ([]Device, int, error)
+
+		// Update replaces stored device with the given one, provided
+		// that the stored revision equals the given device's revision.
+		// Stored revision is incremented on success.
+		Update(Device) error
+
+		// Remove removes device with the given ID, provided that the
+		// stored revision equals the given one (or AnyRevision).
+		Remove(string, int64) error
+
+		// Plug adds channel IDs to the device's `Channels` registry.
+		Plug(string, ...string) error
+
+		// Unplug removes channel IDs from the device's `Channels` registry.
+		Unplug(string, ...string) error
+
+		// RemoveByTenant removes all the devices of the tenant and
+		// returns the number of removed devices.
+		RemoveByTenant(string) (int, error)
+	}
+
+	// ChannelRepository specifies channel persistence API.
+	ChannelRepository interface {
+		// Save persists a new channel.
+		Save(Channel) error
+
+		// One retrieves channel by its ID.
+		One(string) (Channel, error)
+
+		// All retrieves a page of channels described by the query,
+		// together with the total number of channels.
+		All(Query) ([]Channel, int, error)
+
+		// Update replaces stored channel with the given one, provided
+		// that the stored revision equals the given channel's revision.
+		// Stored revision is incremented on success.
+		Update(Channel) error
+
+		// Remove removes channel with the given ID, provided that the
+		// stored revision equals the given one (or AnyRevision).
+		Remove(string, int64) error
+
+		// Plug adds device IDs to the channel's `Devices` registry.
+		Plug(string, ...string) error
+
+		// Unplug removes device IDs from the channel's `Devices` registry.
+		Unplug(string, ...string) error
+
+		// RemoveByTenant removes all the channels of the tenant and
+		// returns the number of removed channels.
+		RemoveByTenant(string) (int, error)
+	}
+
+	// MessageRepository specifies message persistence API.
+	MessageRepository interface {
+		// Save persists given messages. Messages with the key of a
+		// stored message are skipped, and ErrConflict is returned if
+		// all of them are.
+		Save(...Message) error
+
+		// SaveBatch persists given messages in bulk, skipping the ones
+		// with the key of a stored message, which are flagged in the
+		// returned slice.
+		SaveBatch(...Message) ([]bool, error)
+
+		// ByChannel retrieves messages published on the channel
+		// with SenML time in the (start, end) interval.
+		ByChannel(string, float64, float64) ([]Message, error)
+
+		// CountByChannel retrieves the number of stored messages
+		// for every channel that has any.
+		CountByChannel() (map[string]int, error)
+
+		// RemoveByChannel removes all the messages published on the
+		// channel and returns the number of removed messages.
+		RemoveByChannel(string) (int, error)
+
+		// RemoveByTenant removes all the messages of the tenant and
+		// returns the number of removed messages.
+		RemoveByTenant(string) (int, error)
+	}
+
+	// APIKeyRepository specifies API key persistence API.
+	APIKeyRepository interface {
+		// Save persists a new key.
+		Save(APIKey) error
+
+		// ByHash retrieves key by the hash of its value.
+		ByHash(string) (APIKey, error)
+
+		// All retrieves all the keys, oldest first.
+		All() ([]APIKey, error)
+
+		// Remove removes key with the given ID.
+		Remove(string) error
+
+		// RemoveByTenant removes all the keys of the tenant and
+		// returns the number of removed keys.
+		RemoveByTenant(string) (int, error)
+	}
+
+	// TenantRepository specifies tenant persistence API.
+	TenantRepository interface {
+		// Save persists a new tenant. ErrConflict is returned if
+		// the tenant with the same ID exists.
+		Save(Tenant) error
+
+		// One retrieves tenant by its ID.
+		One(string) (Tenant, error)
+
+		// All retrieves all the tenants, ordered by ID.
+		All() ([]Tenant, error)
+
+		// Remove removes tenant with the given ID.
+		Remove(string) error
+	}
+
+	// OutboxRepository specifies persistence API of the outbox.
+	OutboxRepository interface {
+		// Append stores the records, assigning them increasing
+		// sequence numbers in the given order.
+		Append(...OutboxRecord) error
+
+		// Stage stores staged record, and returns its sequence number.
+		Stage(OutboxRecord) (int64, error)
+
+		// Resolve replaces the staged record by the given one, which
+		// keeps its sequence number. ErrNotFound is returned if the
+		// record is no longer staged.
+		Resolve(int64, OutboxRecord) error
+
+		// Pending retrieves at most the given number of records,
+		// ordered by sequence number.
+		Pending(int) ([]OutboxRecord, error)
+
+		// Failed counts failed publish attempt of the record.
+		Failed(int64) error
+
+		// Remove removes published records by their sequence numbers.
+		Remove(...int64) error
+
+		// Dead moves the record that can not be published to the
+		// dead letters.
+		Dead(int64) error
+
+		// Count returns the number of pending records.
+		Count() (int, error)
+
+		// Lease acquires the lease of the outbox for the given owner,
+		// or renews it, for the given duration. Returns false while
+		// the lease is held by another owner.
+		Lease(string, time.Duration) (bool, error)
+
+		// Release releases the lease held by the given owner.
+		Release(string) error
+	}
+)
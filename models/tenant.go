@@ -0,0 +1,22 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package models
+
+type (
+	// Tenant struct
+	// Tenant is a namespace isolating devices, channels and messages
+	// of one customer from the others. The default tenant has empty
+	// ID, and is not stored.
+	Tenant struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+
+		Created string `json:"created"`
+	}
+)
@@ -0,0 +1,440 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package outbox relays the messages stored in the outbox to NATS. The
+// messages are stored along with the changes they announce, so that
+// they are published eventually, even if NATS is down or the process
+// crashes in between.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/metrics"
+	"github.com/mainflux/mainflux-core/models"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// batchSize is the number of records fetched at once.
+	batchSize = 100
+	// maxBackoff bounds the delay between retries of failed publishes.
+	maxBackoff = time.Minute
+	// stagedTimeout is the age at which staged records are considered
+	// left behind, e.g. by a crash, and are reconciled.
+	stagedTimeout = time.Minute
+	// gapTimeout bounds the wait for the records in flight.
+	gapTimeout = 10 * time.Second
+	// maxAttempts is the number of failed publishes after which the
+	// record is moved to the dead letters.
+	maxAttempts = 10
+	// leaseTTL is the time for which the lease of the outbox is held
+	// by the relay that stopped renewing it, e.g. by a crash.
+	leaseTTL = 15 * time.Second
+)
+
+var (
+	// ErrAbandoned is returned by ReconcileFunc for staged records of
+	// the changes that were never made. Such records are removed.
+	ErrAbandoned = errors.New("change abandoned")
+
+	// ErrUnavailable is returned by PublishFunc while the broker is
+	// down. Records failed by it are retried without limit.
+	ErrUnavailable = errors.New("broker unavailable")
+
+	// ErrRejected is returned by PublishFunc for records the broker
+	// will never accept. Such records are moved to the dead letters
+	// right away.
+	ErrRejected = errors.New("record rejected")
+)
+
+var (
+	published = metrics.NewCounterVec("mainflux_outbox_published_total",
+		"Number of outbox records published.")
+	failures = metrics.NewCounterVec("mainflux_outbox_publish_failures_total",
+		"Number of failed attempts to publish outbox records.")
+	deadLetters = metrics.NewCounterVec("mainflux_outbox_dead_letters_total",
+		"Number of outbox records moved to the dead letters.")
+
+	// lag and pending are observed by the relay on every flush
+	lag     int64
+	pending int64
+
+	_ = metrics.NewGaugeFunc("mainflux_outbox_lag_seconds",
+		"Age of the oldest record waiting in the outbox.",
+		func() float64 { return time.Duration(atomic.LoadInt64(&lag)).Seconds() })
+	_ = metrics.NewGaugeFunc("mainflux_outbox_pending",
+		"Number of records waiting in the outbox.",
+		func() float64 { return float64(atomic.LoadInt64(&pending)) })
+)
+
+// PublishFunc publishes the data on the subject.
+type PublishFunc func(subject string, data []byte) error
+
+// ReconcileFunc returns record to be published in place of the staged
+// one left behind, by the outcome of the change it was staged for.
+type ReconcileFunc func(models.OutboxRecord) (models.OutboxRecord, error)
+
+// Relay publishes the outbox records in the order of their sequence
+// numbers, and removes the published ones. Records are published at
+// least once, so consumers drop duplicates by record ID. Of the relays
+// sharing the outbox, only the one holding its lease publishes.
+type Relay struct {
+	repo      models.OutboxRepository
+	publish   PublishFunc
+	reconcile ReconcileFunc
+	interval  time.Duration
+
+	id     string
+	leader bool
+
+	// next is the sequence number expected next, and gapAt the one
+	// missing since gapSince
+	next     int64
+	gapAt    int64
+	gapSince time.Time
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewRelay creates relay which polls the outbox in the interval, and
+// whenever records are appended through its Outbox. Staged records
+// left behind are reconciled by reconcile, or held back forever if
+// it is nil.
+func NewRelay(repo models.OutboxRepository, publish PublishFunc, reconcile ReconcileFunc, interval time.Duration) *Relay {
+	return &Relay{
+		repo:      repo,
+		publish:   publish,
+		reconcile: reconcile,
+		interval:  interval,
+		id:        uuid.NewV4().String(),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// NewRecord creates record of the data to be published on the subject,
+// in order with the other records of the key. The ID, which the data
+// should carry too, identifies duplicates.
+func NewRecord(id, subject, key string, data []byte) models.OutboxRecord {
+	return models.OutboxRecord{
+		ID:      id,
+		Subject: subject,
+		Data:    data,
+		Key:     key,
+		Created: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Outbox returns the outbox which wakes the relay up on append, so
+// that records do not wait for the next poll.
+func (r *Relay) Outbox() models.OutboxRepository {
+	return notifying{r.repo, r}
+}
+
+// notify wakes the relay up, unless it is already awake.
+func (r *Relay) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays the records until stop is closed, and then flushes the
+// outbox once more and releases its lease. Failed publishes are
+// retried with exponential backoff.
+func (r *Relay) Run(stop <-chan struct{}) {
+	defer close(r.done)
+	defer r.release()
+
+	backoff := r.interval
+	for {
+		if err := r.Flush(); err != nil {
+			logging.Warn("cannot relay outbox", "err", err, "retry", backoff)
+
+			select {
+			case <-stop:
+				r.Flush()
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = r.interval
+
+		select {
+		case <-stop:
+			r.Flush()
+			return
+		case <-r.wake:
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Wait waits until Run returns, or ctx is done.
+func (r *Relay) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush function
+// Publishes the pending records until none is left, or publishing
+// fails. Once a record fails, the following records of the same key
+// are held back, so that every key keeps its order. Staged records
+// hold back their key too, until resolved or reconciled. Records
+// behind a gap in sequence numbers wait for the gap to be filled by
+// the appends in flight, for at most gapTimeout. Nothing is published
+// while another relay holds the lease of the outbox.
+func (r *Relay) Flush() error {
+	for {
+		if leader, err := r.lease(); err != nil || !leader {
+			return err
+		}
+
+		records, err := r.repo.Pending(batchSize)
+		if err != nil {
+			return err
+		}
+		r.observe(records)
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		held := map[string]bool{}
+		var done, dropped []int64
+		var failed error
+		gap := false
+		for _, rec := range records {
+			if gap = r.gap(rec.Seq); gap {
+				break
+			}
+			if held[rec.Key] {
+				continue
+			}
+
+			if rec.Staged {
+				resolved, ok := r.resolve(rec)
+				if !ok {
+					if len(rec.Key) > 0 {
+						held[rec.Key] = true
+					}
+					continue
+				}
+				rec = resolved
+			}
+
+			// Aborted changes leave records without subject
+			if len(rec.Subject) == 0 {
+				dropped = append(dropped, rec.Seq)
+				continue
+			}
+
+			if err := r.publish(rec.Subject, rec.Data); err != nil {
+				failures.Inc()
+				failed = err
+				if r.deadLetter(rec, err) {
+					continue
+				}
+				if len(rec.Key) > 0 {
+					held[rec.Key] = true
+				}
+				continue
+			}
+			done = append(done, rec.Seq)
+		}
+
+		if err := r.repo.Remove(append(done, dropped...)...); err != nil {
+			return err
+		}
+		published.Add(float64(len(done)))
+
+		if failed != nil {
+			return failed
+		}
+		if len(done)+len(dropped) == len(records) && len(records) < batchSize {
+			r.observe(nil)
+			return nil
+		}
+		// The rest is held back
+		if gap || len(done)+len(dropped) == 0 {
+			return nil
+		}
+	}
+}
+
+// lease function
+// Acquires or renews the lease of the outbox. The relay taking the
+// lease over forgets the sequence numbers seen before, as the records
+// published meanwhile by the other relay would be taken for gaps.
+func (r *Relay) lease() (bool, error) {
+	leader, err := r.repo.Lease(r.id, leaseTTL)
+	if err != nil {
+		return false, err
+	}
+
+	if leader != r.leader {
+		logging.Info("outbox lease changed", "relay", r.id, "leader", leader)
+		r.next, r.gapAt = 0, 0
+	}
+	r.leader = leader
+	return leader, nil
+}
+
+// release releases the lease, so that other relay takes over without
+// waiting for it to expire.
+func (r *Relay) release() {
+	if !r.leader {
+		return
+	}
+
+	if err := r.repo.Release(r.id); err != nil {
+		logging.Warn("cannot release outbox lease", "err", err)
+	}
+	r.leader = false
+}
+
+// gap function
+// Reports whether the record is behind a gap in sequence numbers, i.e.
+// records that are reserved but not inserted yet. As only the lease
+// holder removes records, gaps seen since the lease was taken over are
+// left by appends in flight, or by failed ones if not filled within
+// gapTimeout.
+func (r *Relay) gap(seq int64) bool {
+	if r.next == 0 || seq <= r.next {
+		if seq >= r.next {
+			r.next = seq + 1
+		}
+		return false
+	}
+
+	if r.gapAt != r.next {
+		r.gapAt, r.gapSince = r.next, time.Now()
+	}
+	if time.Since(r.gapSince) < gapTimeout {
+		return true
+	}
+
+	logging.Warn("skipping outbox gap", "from", r.next, "to", seq-1)
+	r.next = seq + 1
+	return false
+}
+
+// deadLetter function
+// Moves the record, which failed to publish, to the dead letters if the
+// failure is permanent, or the record failed too many times. Failures
+// of the broker that is down are retried without limit.
+func (r *Relay) deadLetter(rec models.OutboxRecord, err error) bool {
+	if err == ErrUnavailable {
+		return false
+	}
+
+	if err != ErrRejected && rec.Attempts+1 < maxAttempts {
+		if err := r.repo.Failed(rec.Seq); err != nil {
+			logging.Warn("cannot count outbox failure", "seq", rec.Seq, "err", err)
+		}
+		return false
+	}
+
+	if err := r.repo.Dead(rec.Seq); err != nil {
+		logging.Warn("cannot move outbox record to dead letters", "seq", rec.Seq, "err", err)
+		return false
+	}
+
+	deadLetters.Inc()
+	logging.Error("moved outbox record to dead letters", "seq", rec.Seq, "id", rec.ID,
+		"subject", rec.Subject, "attempts", rec.Attempts+1, "err", err)
+	return true
+}
+
+// resolve function
+// Reconciles the staged record once it is stale. Returns the record to
+// be published instead, without subject if the record is to be dropped,
+// and false while the record stays staged.
+func (r *Relay) resolve(rec models.OutboxRecord) (models.OutboxRecord, bool) {
+	if r.reconcile == nil {
+		return rec, false
+	}
+	if t, err := time.Parse(time.RFC3339Nano, rec.Created); err == nil && time.Since(t) < stagedTimeout {
+		return rec, false
+	}
+
+	resolved, err := r.reconcile(rec)
+	if err == ErrAbandoned {
+		logging.Info("dropping abandoned outbox record", "seq", rec.Seq, "key", rec.Key)
+		return models.OutboxRecord{Seq: rec.Seq, Key: rec.Key}, true
+	}
+	if err == nil {
+		err = r.repo.Resolve(rec.Seq, resolved)
+	}
+	if err != nil {
+		if err != models.ErrNotFound {
+			logging.Warn("cannot reconcile outbox record", "seq", rec.Seq, "err", err)
+		}
+		return rec, false
+	}
+
+	logging.Info("reconciled outbox record", "seq", rec.Seq, "key", rec.Key)
+	resolved.Seq = rec.Seq
+	return resolved, true
+}
+
+// observe updates lag and pending metrics by the oldest pending records.
+func (r *Relay) observe(records []models.OutboxRecord) {
+	if len(records) == 0 {
+		atomic.StoreInt64(&lag, 0)
+		atomic.StoreInt64(&pending, 0)
+		return
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, records[0].Created); err == nil {
+		atomic.StoreInt64(&lag, int64(time.Since(t)))
+	}
+	if n, err := r.repo.Count(); err == nil {
+		atomic.StoreInt64(&pending, int64(n))
+	}
+}
+
+// notifying outbox wakes the relay up on append.
+type notifying struct {
+	models.OutboxRepository
+	relay *Relay
+}
+
+func (n notifying) Append(records ...models.OutboxRecord) error {
+	if err := n.OutboxRepository.Append(records...); err != nil {
+		return err
+	}
+
+	n.relay.notify()
+	return nil
+}
+
+func (n notifying) Resolve(seq int64, rec models.OutboxRecord) error {
+	if err := n.OutboxRepository.Resolve(seq, rec); err != nil {
+		return err
+	}
+
+	n.relay.notify()
+	return nil
+}
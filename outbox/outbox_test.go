@@ -0,0 +1,302 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mainflux/mainflux-core/memory"
+	"github.com/mainflux/mainflux-core/models"
+	"github.com/mainflux/mainflux-core/outbox"
+)
+
+// broker records published data, and fails on the subjects it is told
+// to, with err if set.
+type broker struct {
+	published []string
+	failing   map[string]bool
+	err       error
+}
+
+func (b *broker) publish(subject string, data []byte) error {
+	if b.failing[subject] && b.err != nil {
+		return b.err
+	}
+	if b.failing[subject] {
+		return errors.New("broker down")
+	}
+	b.published = append(b.published, string(data))
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	repo := memory.NewOutboxRepository()
+	b := &broker{failing: map[string]bool{"down": true}}
+	relay := outbox.NewRelay(repo, b.publish, nil, time.Second)
+
+	repo.Append(
+		outbox.NewRecord("1", "up", "a", []byte("a1")),
+		outbox.NewRecord("2", "down", "b", []byte("b1")),
+		outbox.NewRecord("3", "up", "a", []byte("a2")),
+		outbox.NewRecord("4", "up", "b", []byte("b2")),
+		outbox.NewRecord("5", "up", "", []byte("c1")),
+	)
+
+	// Records of b are held back after b1 fails
+	if err := relay.Flush(); err == nil {
+		t.Errorf("expected error")
+	}
+	if got := strings.Join(b.published, ","); got != "a1,a2,c1" {
+		t.Errorf("expected a1,a2,c1 published got %s", got)
+	}
+
+	pending, _ := repo.Pending(10)
+	if len(pending) != 2 || pending[0].ID != "2" || pending[0].Attempts != 1 || pending[1].ID != "4" {
+		t.Errorf("expected b1 and b2 pending got %+v", pending)
+	}
+
+	// Retry publishes them in order
+	b.failing = nil
+	if err := relay.Flush(); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if got := strings.Join(b.published, ","); got != "a1,a2,c1,b1,b2" {
+		t.Errorf("expected a1,a2,c1,b1,b2 published got %s", got)
+	}
+	if n, _ := repo.Count(); n != 0 {
+		t.Errorf("expected empty outbox got %d records", n)
+	}
+}
+
+// inflight outbox hides the records being appended from the relay.
+type inflight struct {
+	models.OutboxRepository
+	seqs map[int64]bool
+}
+
+func (i inflight) Pending(limit int) ([]models.OutboxRecord, error) {
+	records, err := i.OutboxRepository.Pending(limit)
+
+	visible := records[:0]
+	for _, r := range records {
+		if !i.seqs[r.Seq] {
+			visible = append(visible, r)
+		}
+	}
+
+	return visible, err
+}
+
+func TestGap(t *testing.T) {
+	repo := inflight{memory.NewOutboxRepository(), map[int64]bool{}}
+	b := &broker{}
+	relay := outbox.NewRelay(repo, b.publish, nil, time.Second)
+
+	repo.Append(outbox.NewRecord("1", "up", "a", []byte("a1")))
+	relay.Flush()
+
+	// Record appended later must wait for the one in flight
+	repo.seqs[2] = true
+	repo.Append(
+		outbox.NewRecord("2", "up", "a", []byte("a2")),
+		outbox.NewRecord("3", "up", "a", []byte("a3")),
+	)
+	if err := relay.Flush(); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if got := strings.Join(b.published, ","); got != "a1" {
+		t.Errorf("expected a1 published got %s", got)
+	}
+
+	delete(repo.seqs, 2)
+	relay.Flush()
+	if got := strings.Join(b.published, ","); got != "a1,a2,a3" {
+		t.Errorf("expected a1,a2,a3 published got %s", got)
+	}
+}
+
+func TestLease(t *testing.T) {
+	repo := memory.NewOutboxRepository()
+	b := &broker{}
+	first := outbox.NewRelay(repo, b.publish, nil, time.Second)
+	second := outbox.NewRelay(repo, b.publish, nil, time.Second)
+
+	// Only the relay holding the lease publishes
+	repo.Append(outbox.NewRecord("1", "up", "a", []byte("a1")))
+	first.Flush()
+	second.Flush()
+	if got := strings.Join(b.published, ","); got != "a1" {
+		t.Errorf("expected a1 published got %s", got)
+	}
+
+	repo.Append(outbox.NewRecord("2", "up", "a", []byte("a2")))
+	second.Flush()
+	if got := strings.Join(b.published, ","); got != "a1" {
+		t.Errorf("expected a1 published got %s", got)
+	}
+
+	// Stopped relay hands the lease over
+	stop := make(chan struct{})
+	close(stop)
+	first.Run(stop)
+
+	repo.Append(outbox.NewRecord("3", "up", "a", []byte("a3")))
+	second.Flush()
+	if got := strings.Join(b.published, ","); got != "a1,a2,a3" {
+		t.Errorf("expected a1,a2,a3 published got %s", got)
+	}
+
+	// Records removed by the other relay are not taken for a gap
+	stop = make(chan struct{})
+	close(stop)
+	second.Run(stop)
+
+	repo.Append(outbox.NewRecord("4", "up", "a", []byte("a4")))
+	first = outbox.NewRelay(repo, b.publish, nil, time.Second)
+	first.Run(stop)
+
+	repo.Append(outbox.NewRecord("5", "up", "a", []byte("a5")))
+	if err := second.Flush(); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if got := strings.Join(b.published, ","); got != "a1,a2,a3,a4,a5" {
+		t.Errorf("expected a1,a2,a3,a4,a5 published got %s", got)
+	}
+	if n, _ := repo.Count(); n != 0 {
+		t.Errorf("expected empty outbox got %d records", n)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	cases := []struct {
+		err      error
+		flushes  int
+		dead     bool
+		attempts int
+	}{
+		{outbox.ErrRejected, 1, true, 0},
+		{errors.New("timeout"), 9, false, 9},
+		{errors.New("timeout"), 10, true, 0},
+		// Broker outage is not the fault of the record
+		{outbox.ErrUnavailable, 20, false, 0},
+	}
+
+	for i, c := range cases {
+		repo := memory.NewOutboxRepository()
+		b := &broker{failing: map[string]bool{"bad": true}, err: c.err}
+		relay := outbox.NewRelay(repo, b.publish, nil, time.Second)
+
+		repo.Append(
+			outbox.NewRecord("1", "bad", "a", []byte("a1")),
+			outbox.NewRecord("2", "up", "a", []byte("a2")),
+		)
+		for j := 0; j < c.flushes; j++ {
+			relay.Flush()
+		}
+
+		// Dead letter no longer holds back its key
+		pending, _ := repo.Pending(10)
+		if c.dead && (len(pending) != 0 || strings.Join(b.published, ",") != "a2") {
+			t.Errorf("case %d: expected a2 published got %v with %d pending", i+1, b.published, len(pending))
+		}
+		if !c.dead && (len(pending) != 2 || len(b.published) != 0 || pending[0].Attempts != c.attempts) {
+			t.Errorf("case %d: expected records pending after %d attempts got %v published and %+v pending",
+				i+1, c.attempts, b.published, pending)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	published := make(chan string, 1)
+	publish := func(subject string, data []byte) error {
+		published <- string(data)
+		return nil
+	}
+	repo := memory.NewOutboxRepository()
+	relay := outbox.NewRelay(repo, publish, nil, time.Hour)
+
+	stop := make(chan struct{})
+	go relay.Run(stop)
+
+	// Append wakes the relay up long before the interval
+	relay.Outbox().Append(outbox.NewRecord("1", "up", "a", []byte("a1")))
+
+	select {
+	case data := <-published:
+		if data != "a1" {
+			t.Errorf("expected a1 published got %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected record to be published")
+	}
+
+	// Records left are published on stop
+	repo.Append(outbox.NewRecord("2", "up", "a", []byte("a2")))
+	close(stop)
+	if err := relay.Wait(context.Background()); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if data := <-published; data != "a2" {
+		t.Errorf("expected a2 published got %s", data)
+	}
+}
+
+func TestStaged(t *testing.T) {
+	repo := memory.NewOutboxRepository()
+	b := &broker{}
+	reconcile := func(rec models.OutboxRecord) (models.OutboxRecord, error) {
+		if string(rec.Data) == "abandoned" {
+			return rec, outbox.ErrAbandoned
+		}
+		return outbox.NewRecord(rec.ID, "up", rec.Key, []byte("reconciled")), nil
+	}
+	relay := outbox.NewRelay(repo, b.publish, reconcile, time.Second)
+
+	stale := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339Nano)
+	old := outbox.NewRecord("1", "", "a", []byte("a1"))
+	old.Created = stale
+	abandoned := outbox.NewRecord("2", "", "b", []byte("abandoned"))
+	abandoned.Created = stale
+
+	fresh, _ := repo.Stage(outbox.NewRecord("0", "", "c", []byte("c1")))
+	repo.Stage(old)
+	repo.Stage(abandoned)
+	repo.Append(
+		outbox.NewRecord("3", "up", "a", []byte("a2")),
+		outbox.NewRecord("4", "up", "b", []byte("b2")),
+		outbox.NewRecord("5", "up", "c", []byte("c2")),
+	)
+
+	// Fresh staged record holds back its key, stale ones are reconciled
+	if err := relay.Flush(); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if got := strings.Join(b.published, ","); got != "reconciled,a2,b2" {
+		t.Errorf("expected reconciled,a2,b2 published got %s", got)
+	}
+
+	// Resolved record is published in order
+	if err := repo.Resolve(fresh, outbox.NewRecord("0", "up", "c", []byte("c1"))); err != nil {
+		t.Errorf("expected no error got %v", err)
+	}
+	if err := repo.Resolve(fresh, outbox.NewRecord("0", "up", "c", []byte("c1"))); err != models.ErrNotFound {
+		t.Errorf("expected %v got %v", models.ErrNotFound, err)
+	}
+	relay.Flush()
+	if got := strings.Join(b.published, ","); got != "reconciled,a2,b2,c1,c2" {
+		t.Errorf("expected reconciled,a2,b2,c1,c2 published got %s", got)
+	}
+	if n, _ := repo.Count(); n != 0 {
+		t.Errorf("expected empty outbox got %d records", n)
+	}
+}
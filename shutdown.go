@@ -0,0 +1,131 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mainflux/mainflux-core/api"
+	"github.com/mainflux/mainflux-core/db"
+	"github.com/mainflux/mainflux-core/logging"
+)
+
+// serve serves HTTP until the server fails, or until SIGINT or SIGTERM
+// is received, in which case it shuts down gracefully. It returns the
+// exit status.
+func serve(srv *http.Server, timeout time.Duration, pidFile string, stopRelay func(context.Context) error) int {
+	if len(pidFile) > 0 {
+		if err := writePidFile(pidFile); err != nil {
+			logging.Error("cannot write PID file", "file", pidFile, "err", err)
+			return 1
+		}
+		defer removePidFile(pidFile)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// Certificates are provided by the TLS config
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	status := 0
+	select {
+	case err := <-errs:
+		logging.Error("cannot serve HTTP", "err", err)
+		status = 1
+	case sig := <-sigs:
+		logging.Info("shutting down", "signal", sig.String(), "timeout", timeout)
+	}
+
+	if err := shutdown(srv, timeout, stopRelay); err != nil {
+		status = 1
+	}
+
+	return status
+}
+
+// shutdown stops accepting connections, and waits for in-flight HTTP
+// requests, NATS messages and outbox relay to complete, at most for
+// the timeout. It then closes NATS connection, and MongoDB connection
+// unless some of the work is still running.
+func shutdown(srv *http.Server, timeout time.Duration, stopRelay func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var failed error
+
+	// Requests may publish on NATS, so they are drained first
+	if err := srv.Shutdown(ctx); err != nil {
+		logging.Error("cannot drain HTTP requests", "err", err)
+		failed = err
+	}
+
+	// Received messages are written, and re-published through the outbox
+	if err := api.NatsDrain(ctx); err != nil {
+		logging.Error("cannot drain NATS", "err", err)
+		failed = err
+	}
+
+	// Relay publishes what the requests and messages left in the outbox,
+	// while NATS is still connected. Whatever is left after, is published
+	// after restart.
+	if err := stopRelay(ctx); err != nil {
+		logging.Error("cannot drain outbox", "err", err)
+		failed = err
+	}
+
+	if err := api.NatsClose(ctx); err != nil {
+		logging.Error("cannot flush NATS", "err", err)
+		failed = err
+	}
+
+	// Work that did not complete in time may still use the database,
+	// and the process exits soon anyway
+	if failed != nil {
+		logging.Warn("leaving MongoDB connection open")
+		return failed
+	}
+
+	db.CloseMongo()
+	logging.Info("shut down")
+
+	return nil
+}
+
+// writePidFile stores the process ID.
+func writePidFile(file string) error {
+	return ioutil.WriteFile(file, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+}
+
+// removePidFile removes the PID file, if it still holds our PID.
+func removePidFile(file string) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil || string(b) != fmt.Sprintf("%d\n", os.Getpid()) {
+		return
+	}
+
+	if err := os.Remove(file); err != nil {
+		logging.Warn("cannot remove PID file", "file", file, "err", err)
+	}
+}
@@ -0,0 +1,609 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+// Package wal implements the write-ahead log, which keeps the entries
+// on disk while they cannot be written to the database, and replays
+// them in order once it recovers.
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mainflux/mainflux-core/logging"
+	"github.com/mainflux/mainflux-core/metrics"
+)
+
+// Policies applied to the entries appended to the full log
+const (
+	// Reject rejects the new entries.
+	Reject = "reject"
+	// DropOldest drops the oldest entries to make room for the new ones.
+	DropOldest = "drop-oldest"
+)
+
+const (
+	segmentExt     = ".wal"
+	checkpointFile = "checkpoint"
+	// headerSize is the size of the entry header, i.e. of the data
+	// length and CRC-32 checksum.
+	headerSize = 8
+	// segments the log is split into, so that dropping the oldest
+	// one makes room without dropping the whole log.
+	segmentsPerLog = 8
+	// maxBackoff bounds the delay between retries of failed replays.
+	maxBackoff = time.Minute
+)
+
+// ErrFull is returned when the entry does not fit the log.
+var ErrFull = errors.New("write-ahead log is full")
+
+var (
+	appended = metrics.NewCounterVec("mainflux_wal_appended_total",
+		"Number of entries appended to the write-ahead log.")
+	replayed = metrics.NewCounterVec("mainflux_wal_replayed_total",
+		"Number of write-ahead log entries replayed.")
+	rejected = metrics.NewCounterVec("mainflux_wal_rejected_total",
+		"Number of entries rejected by the full write-ahead log.")
+	dropped = metrics.NewCounterVec("mainflux_wal_dropped_total",
+		"Number of entries dropped from the full write-ahead log.")
+
+	// pending and pendingBytes are observed by the log on every change
+	pending      int64
+	pendingBytes int64
+
+	_ = metrics.NewGaugeFunc("mainflux_wal_pending",
+		"Number of entries waiting in the write-ahead log.",
+		func() float64 { return float64(atomic.LoadInt64(&pending)) })
+	_ = metrics.NewGaugeFunc("mainflux_wal_pending_bytes",
+		"Size of the entries waiting in the write-ahead log.",
+		func() float64 { return float64(atomic.LoadInt64(&pendingBytes)) })
+)
+
+// Config configures the log.
+type Config struct {
+	// Dir holds the log files.
+	Dir string
+	// MaxSize bounds the size of the log files, in bytes.
+	MaxSize int64
+	// Policy applied to the entries which do not fit, either Reject
+	// or DropOldest.
+	Policy string
+}
+
+// Depth is the backlog of the log.
+type Depth struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	// Dropped is the number of entries dropped since the log was opened.
+	Dropped int64 `json:"dropped"`
+}
+
+// ApplyFunc writes the entry to the database.
+type ApplyFunc func(data []byte) error
+
+// segment is a log file. Entries are appended to the last segment.
+type segment struct {
+	seq     int64
+	size    int64
+	entries int
+}
+
+// position of the entry in the log
+type position struct {
+	seq    int64
+	offset int64
+	next   int64
+}
+
+// Log is the write-ahead log. Entries are appended to the segment
+// files, and removed once replayed. Replay position is kept in the
+// checkpoint file, so that replay resumes after restart.
+type Log struct {
+	mu          sync.Mutex
+	cfg         Config
+	segmentSize int64
+
+	// segments are ordered from the oldest, which is replayed, to the
+	// newest, which is appended to
+	segments []*segment
+	active   *os.File
+	reader   *os.File
+	// seq is the last sequence number given to a segment
+	seq int64
+	// offset and read are replay position in the oldest segment
+	offset  int64
+	read    int
+	dropped int64
+
+	done chan struct{}
+}
+
+// Open opens the log in the directory, or creates it. Entries torn by
+// crash are truncated.
+func Open(cfg Config) (*Log, error) {
+	if cfg.MaxSize < 1 {
+		return nil, fmt.Errorf("invalid write-ahead log size %d", cfg.MaxSize)
+	}
+	if cfg.Policy != Reject && cfg.Policy != DropOldest {
+		return nil, fmt.Errorf("invalid write-ahead log policy %q", cfg.Policy)
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		cfg:         cfg,
+		segmentSize: cfg.MaxSize / segmentsPerLog,
+		done:        make(chan struct{}),
+	}
+
+	seqs, err := l.segmentSeqs()
+	if err != nil {
+		return nil, err
+	}
+
+	cpSeq, cpOffset := l.checkpoint()
+	l.seq = cpSeq
+	for _, seq := range seqs {
+		if seq > l.seq {
+			l.seq = seq
+		}
+
+		// Segments replayed before crash
+		if seq < cpSeq {
+			if err := os.Remove(l.path(seq)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		s, err := l.scan(seq, cfg.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+	}
+
+	if len(l.segments) > 0 && l.segments[0].seq == cpSeq {
+		if err := l.seek(cpOffset); err != nil {
+			return nil, err
+		}
+	}
+
+	l.observe()
+	return l, nil
+}
+
+// Append appends the entry to the log, and syncs it to disk. Entries
+// which do not fit the full log are rejected with ErrFull, unless the
+// oldest entries are dropped by the policy.
+func (l *Log) Append(data []byte) error {
+	size := int64(headerSize + len(data))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.size()+size > l.cfg.MaxSize {
+		if l.cfg.Policy != DropOldest || len(l.segments) == 0 {
+			rejected.Inc()
+			return ErrFull
+		}
+		if err := l.dropOldest(); err != nil {
+			return err
+		}
+	}
+
+	last := l.last()
+	if l.active == nil || last.size > 0 && last.size+size > l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		last = l.last()
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[headerSize:], data)
+
+	if _, err := l.active.Write(buf); err != nil {
+		return err
+	}
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+
+	last.size += size
+	last.entries++
+	appended.Inc()
+	l.observe()
+
+	return nil
+}
+
+// Replay applies the entries in order, and removes the applied ones,
+// until none is left or apply fails.
+func (l *Log) Replay(apply ApplyFunc) error {
+	for {
+		data, pos, err := l.next()
+		if err != nil || data == nil {
+			return err
+		}
+
+		if err := apply(data); err != nil {
+			return err
+		}
+
+		if err := l.commit(pos); err != nil {
+			return err
+		}
+	}
+}
+
+// Run replays the log in the interval until stop is closed. Failed
+// replays are retried with exponential backoff.
+func (l *Log) Run(apply ApplyFunc, interval time.Duration, stop <-chan struct{}) {
+	defer close(l.done)
+
+	backoff := interval
+	for {
+		if err := l.Replay(apply); err != nil {
+			logging.Warn("cannot replay write-ahead log", "err", err, "retry", backoff)
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			backoff = interval
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// Wait waits until Run returns, or ctx is done.
+func (l *Log) Wait(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Depth returns the backlog of the log.
+func (l *Log) Depth() Depth {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.depth()
+}
+
+// Close closes the log files.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeReader()
+	if l.active == nil {
+		return nil
+	}
+
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+func (l *Log) depth() Depth {
+	d := Depth{Dropped: l.dropped}
+	for _, s := range l.segments {
+		d.Entries += s.entries
+		d.Bytes += s.size
+	}
+	if len(l.segments) > 0 {
+		d.Entries -= l.read
+		d.Bytes -= l.offset
+	}
+
+	return d
+}
+
+// observe updates the pending metrics.
+func (l *Log) observe() {
+	d := l.depth()
+	atomic.StoreInt64(&pending, int64(d.Entries))
+	atomic.StoreInt64(&pendingBytes, d.Bytes)
+}
+
+// size returns the size of the log files.
+func (l *Log) size() int64 {
+	var n int64
+	for _, s := range l.segments {
+		n += s.size
+	}
+
+	return n
+}
+
+func (l *Log) last() *segment {
+	if len(l.segments) == 0 {
+		return nil
+	}
+
+	return l.segments[len(l.segments)-1]
+}
+
+func (l *Log) path(seq int64) string {
+	return filepath.Join(l.cfg.Dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+// rotate starts the new segment, which entries are appended to.
+// Sequence numbers are never reused, so that the checkpoint does not
+// refer to the new segments.
+func (l *Log) rotate() error {
+	f, err := os.OpenFile(l.path(l.seq+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	l.seq++
+
+	if l.active != nil {
+		l.active.Close()
+	}
+	l.active = f
+	l.segments = append(l.segments, &segment{seq: l.seq})
+
+	return nil
+}
+
+// dropOldest removes the oldest segment, along with the entries which
+// were not replayed yet.
+func (l *Log) dropOldest() error {
+	s := l.segments[0]
+	if len(l.segments) == 1 && l.active != nil {
+		l.active.Close()
+		l.active = nil
+	}
+	l.closeReader()
+
+	if err := os.Remove(l.path(s.seq)); err != nil {
+		return err
+	}
+
+	lost := s.entries - l.read
+	l.segments = l.segments[1:]
+	l.offset, l.read = 0, 0
+	l.dropped += int64(lost)
+	dropped.Add(float64(lost))
+	logging.Warn("write-ahead log full, dropped oldest entries", "entries", lost)
+
+	return nil
+}
+
+// next reads the entry at replay position, removing the replayed
+// segments on the way. It returns nil data if there is none.
+func (l *Log) next() ([]byte, position, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.segments) > 0 && l.read >= l.segments[0].entries {
+		// Nothing appended to the new segment yet
+		if len(l.segments) == 1 && l.segments[0].entries == 0 {
+			return nil, position{}, nil
+		}
+		if err := l.removeOldest(); err != nil {
+			return nil, position{}, err
+		}
+	}
+	if len(l.segments) == 0 {
+		return nil, position{}, nil
+	}
+
+	s := l.segments[0]
+	if l.reader == nil {
+		f, err := os.Open(l.path(s.seq))
+		if err != nil {
+			return nil, position{}, err
+		}
+		l.reader = f
+	}
+
+	data, err := readEntry(l.reader, l.offset, l.cfg.MaxSize)
+	if err != nil {
+		return nil, position{}, err
+	}
+
+	pos := position{seq: s.seq, offset: l.offset, next: l.offset + int64(headerSize+len(data))}
+	return data, pos, nil
+}
+
+// commit moves replay position past the applied entry, unless the entry
+// was dropped meanwhile.
+func (l *Log) commit(pos position) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.segments) == 0 || l.segments[0].seq != pos.seq || l.offset != pos.offset {
+		return nil
+	}
+
+	l.offset = pos.next
+	l.read++
+	replayed.Inc()
+	l.observe()
+
+	return l.writeCheckpoint(pos.seq, pos.next)
+}
+
+// removeOldest removes the replayed oldest segment. Removing the last
+// one, the next append starts a new segment.
+func (l *Log) removeOldest() error {
+	l.closeReader()
+	if len(l.segments) == 1 && l.active != nil {
+		l.active.Close()
+		l.active = nil
+	}
+
+	if err := os.Remove(l.path(l.segments[0].seq)); err != nil {
+		return err
+	}
+	l.segments = l.segments[1:]
+	l.offset, l.read = 0, 0
+	l.observe()
+
+	return nil
+}
+
+func (l *Log) closeReader() {
+	if l.reader != nil {
+		l.reader.Close()
+		l.reader = nil
+	}
+}
+
+// seek moves replay position of the oldest segment to the offset.
+func (l *Log) seek(offset int64) error {
+	f, err := os.Open(l.path(l.segments[0].seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for l.offset < offset && l.read < l.segments[0].entries {
+		data, err := readEntry(f, l.offset, l.cfg.MaxSize)
+		if err != nil {
+			return err
+		}
+		l.offset += int64(headerSize + len(data))
+		l.read++
+	}
+
+	return nil
+}
+
+// scan counts the entries of the segment, and truncates the segment
+// at the first torn or corrupted entry.
+func (l *Log) scan(seq, maxSize int64) (*segment, error) {
+	f, err := os.OpenFile(l.path(seq), os.O_RDWR, 0640)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &segment{seq: seq}
+	for {
+		data, err := readEntry(f, s.size, maxSize)
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			logging.Warn("truncating write-ahead log segment", "seq", seq, "offset", s.size, "err", err)
+			return s, f.Truncate(s.size)
+		}
+
+		s.size += int64(headerSize + len(data))
+		s.entries++
+	}
+}
+
+// segmentSeqs returns sequence numbers of the segment files, in order.
+func (l *Log) segmentSeqs() ([]int64, error) {
+	files, err := ioutil.ReadDir(l.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []int64
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	return seqs, nil
+}
+
+// checkpoint returns replay position recorded in the checkpoint file.
+func (l *Log) checkpoint() (int64, int64) {
+	b, err := ioutil.ReadFile(filepath.Join(l.cfg.Dir, checkpointFile))
+	if err != nil {
+		return 0, 0
+	}
+
+	var seq, offset int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &seq, &offset); err != nil {
+		return 0, 0
+	}
+
+	return seq, offset
+}
+
+// writeCheckpoint records replay position, replacing the checkpoint
+// file at once.
+func (l *Log) writeCheckpoint(seq, offset int64) error {
+	path := filepath.Join(l.cfg.Dir, checkpointFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(fmt.Sprintf("%d %d\n", seq, offset)), 0640); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// readEntry reads the entry at the offset. It returns io.EOF if there
+// is no entry, and an error if the entry is torn or corrupted, i.e.
+// larger than the log too.
+func readEntry(f *os.File, offset, maxSize int64) ([]byte, error) {
+	var hdr [headerSize]byte
+	n, err := f.ReadAt(hdr[:], offset)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if n < headerSize {
+		return nil, errors.New("torn entry header")
+	}
+
+	size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	if size > maxSize {
+		return nil, errors.New("corrupted entry header")
+	}
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset+headerSize); err != nil {
+		return nil, errors.New("torn entry")
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, errors.New("corrupted entry")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,175 @@
+/**
+ * Copyright (c) Mainflux
+ *
+ * Mainflux server is licensed under an Apache license, version 2.0.
+ * All rights not explicitly granted in the Apache license, version 2.0 are reserved.
+ * See the included LICENSE file for more details.
+ */
+
+package wal_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mainflux/mainflux-core/wal"
+)
+
+func open(t *testing.T, dir string, maxSize int64, policy string) *wal.Log {
+	l, err := wal.Open(wal.Config{Dir: dir, MaxSize: maxSize, Policy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// replay replays the log, failing on the entry given.
+func replay(l *wal.Log, failing string) ([]string, error) {
+	applied := []string{}
+	err := l.Replay(func(data []byte) error {
+		if string(data) == failing {
+			return errors.New("database down")
+		}
+		applied = append(applied, string(data))
+		return nil
+	})
+
+	return applied, err
+}
+
+func TestReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l := open(t, dir, 1<<20, wal.Reject)
+	for _, e := range []string{"a", "b", "c"} {
+		if err := l.Append([]byte(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Replay stops at the failing entry
+	applied, err := replay(l, "b")
+	if err == nil || strings.Join(applied, ",") != "a" {
+		t.Errorf("expected a applied and error got %v and %v", applied, err)
+	}
+	if d := l.Depth(); d.Entries != 2 || d.Bytes != 2*9 {
+		t.Errorf("expected 2 entries of 18 bytes pending got %+v", d)
+	}
+
+	// Replay resumes after restart
+	l.Close()
+	l = open(t, dir, 1<<20, wal.Reject)
+	defer l.Close()
+
+	if err := l.Append([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err = replay(l, "")
+	if err != nil || strings.Join(applied, ",") != "b,c,d" {
+		t.Errorf("expected b,c,d applied got %v and %v", applied, err)
+	}
+	if d := l.Depth(); d.Entries != 0 || d.Bytes != 0 {
+		t.Errorf("expected empty log got %+v", d)
+	}
+
+	// Replayed segments are removed
+	files, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if len(files) != 0 {
+		t.Errorf("expected no segments got %v", files)
+	}
+
+	if err := l.Append([]byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	applied, _ = replay(l, "")
+	if strings.Join(applied, ",") != "e" {
+		t.Errorf("expected e applied got %v", applied)
+	}
+}
+
+func TestTornEntry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	l := open(t, dir, 1<<20, wal.Reject)
+	l.Append([]byte("a"))
+	l.Append([]byte("b"))
+	l.Close()
+
+	// Crash in the middle of append
+	files, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 5, 1})
+	f.Close()
+
+	l = open(t, dir, 1<<20, wal.Reject)
+	defer l.Close()
+
+	l.Append([]byte("c"))
+	applied, err := replay(l, "")
+	if err != nil || strings.Join(applied, ",") != "a,b,c" {
+		t.Errorf("expected a,b,c applied got %v and %v", applied, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	// Log fits 8 entries of 10 bytes, each in its own segment
+	const maxSize = 8 * 18
+
+	cases := []struct {
+		policy  string
+		err     error
+		applied string
+		dropped int64
+	}{
+		{wal.Reject, wal.ErrFull, "e00000000,e00000001,e00000002,e00000003,e00000004,e00000005,e00000006,e00000007", 0},
+		{wal.DropOldest, nil, "e00000002,e00000003,e00000004,e00000005,e00000006,e00000007,e00000008,e00000009", 2},
+	}
+
+	for i, c := range cases {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		l := open(t, dir, maxSize, c.policy)
+
+		var err error
+		for j := 0; j < 10; j++ {
+			if aerr := l.Append([]byte(fmt.Sprintf("e%08d", j))); aerr != nil {
+				err = aerr
+			}
+		}
+		if err != c.err {
+			t.Errorf("case %d: expected error %v got %v", i+1, c.err, err)
+		}
+
+		if d := l.Depth(); d.Entries != 8 || d.Dropped != c.dropped {
+			t.Errorf("case %d: expected 8 entries and %d dropped got %+v", i+1, c.dropped, d)
+		}
+
+		applied, _ := replay(l, "")
+		if got := strings.Join(applied, ","); got != c.applied {
+			t.Errorf("case %d: expected %s applied got %s", i+1, c.applied, got)
+		}
+		l.Close()
+	}
+}